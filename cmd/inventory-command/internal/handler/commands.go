@@ -3,19 +3,23 @@ package handler
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"net/http"
-	"saga-pattern/internal/client"
+	"saga-pattern/internal/database"
 	"saga-pattern/internal/database/models"
+	"saga-pattern/internal/etag"
+	"saga-pattern/internal/events"
 
-	"github.com/segmentio/kafka-go"
 	"github.com/uptrace/bun"
 )
 
 const (
 	InventoryTopic = "inventory"
-	InventoryKey   = "InventoryCreated"
 )
 
+// ErrBelowReserved is returned when a correction leaves less stock on hand than is reserved
+var ErrBelowReserved = errors.New("stock on hand cannot be below the reserved stock")
+
 type InventoryPayload struct {
 	Product  string `json:"product"`
 	Quantity int64  `json:"quantity"`
@@ -44,63 +48,117 @@ func GetInventoryByID(ctx context.Context, db *bun.DB, id string) (*models.Inven
 	return inventory, nil
 }
 
-func CreateInventory(ctx context.Context, db *bun.DB, r *http.Request, api client.API) (*models.Inventory, error) {
-	var payload InventoryPayload
+// InventoryDetails is the stock of a product with the reservations still holding part of it
+type InventoryDetails struct {
+	*models.Inventory
+	Available    int64
+	Reservations []models.Reservation
+}
 
-	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+// GetInventoryDetails returns the inventory with its available stock and active reservations
+func GetInventoryDetails(ctx context.Context, db *bun.DB, id string) (*InventoryDetails, error) {
+	inventory, err := GetInventoryByID(ctx, db, id)
+
+	if err != nil {
 		return nil, err
 	}
 
-	inventory := &models.Inventory{
-		ProductID: payload.Product,
-		Quantity:  payload.Quantity,
-	}
+	details := &InventoryDetails{Inventory: inventory, Available: inventory.Available(), Reservations: []models.Reservation{}}
 
-	if _, err := db.NewInsert().Model(inventory).Exec(ctx); err != nil {
+	err = db.NewSelect().Model(&details.Reservations).
+		Where("product_id = ?", inventory.ProductID).
+		Where("status = ?", models.ReservationStatusReserved).
+		Order("expires_at").
+		Scan(ctx)
+
+	if err != nil {
 		return nil, err
 	}
 
-	value := map[string]interface{}{
-		"id":       inventory.ID,
-		"product":  inventory.ProductID,
-		"quantity": inventory.Quantity,
-	}
+	return details, nil
+}
 
-	jsonValue, err := json.Marshal(value)
+func CreateInventory(ctx context.Context, db *bun.DB, r *http.Request) (*models.Inventory, error) {
+	var payload InventoryPayload
 
-	if err != nil {
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
 		return nil, err
 	}
 
-	message := kafka.Message{
-		Topic: InventoryTopic,
-		Key:   []byte(InventoryKey),
-		Value: jsonValue,
+	inventory := &models.Inventory{
+		ProductID: payload.Product,
+		OnHand:    payload.Quantity,
+		Version:   1,
 	}
 
-	if err := api.SendMessage(ctx, message); err != nil {
+	err := db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		if _, err := tx.NewInsert().Model(inventory).Exec(ctx); err != nil {
+			return err
+		}
+
+		event := events.InventoryCreated{
+			ID:       inventory.ID,
+			Product:  inventory.ProductID,
+			Quantity: inventory.OnHand,
+		}
+
+		envelope := events.New(events.SourceInventory, inventory.ProductID, event)
+
+		return database.EnqueueEvent(ctx, tx, InventoryTopic, envelope, event)
+	})
+
+	if err != nil {
 		return nil, err
 	}
 
 	return inventory, nil
 }
 
-func UpdateInventory(ctx context.Context, db *bun.DB, id string, r *http.Request, api client.API) (*models.Inventory, error) {
-	var payload InventoryPayload
+// UpdateInventory corrects the stock on hand of the inventory. The request must carry the
+// ETag of the inventory in If-Match, so a correction never overwrites a reservation made
+// since the inventory was read.
+func UpdateInventory(ctx context.Context, db *bun.DB, id string, r *http.Request) (*models.Inventory, error) {
+	precondition, err := etag.IfMatch(r)
 
-	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+	if err != nil {
 		return nil, err
 	}
 
-	inventory, err := GetInventoryByID(ctx, db, id)
+	var payload InventoryPayload
 
-	if err != nil {
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
 		return nil, err
 	}
 
-	inventory.Quantity = payload.Quantity
+	inventory := new(models.Inventory)
+
+	err = db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		if err := tx.NewSelect().Model(inventory).Where("id = ?", id).Scan(ctx); err != nil {
+			return err
+		}
+
+		if !precondition.Matches(inventory.Version) {
+			return database.ErrVersionMismatch
+		}
+
+		// The quantity is the stock on hand, the reserved stock is only moved by the orders
+		if payload.Quantity < inventory.Reserved {
+			return ErrBelowReserved
+		}
 
-	if _, err := db.NewUpdate().Model(inventory).Where("id = ?", id).Exec(ctx); err != nil {
+		query := tx.NewUpdate().Model(inventory).Set("on_hand = ?", payload.Quantity).WherePK()
+
+		if err := database.UpdateVersion(ctx, query, inventory.Version); err != nil {
+			return err
+		}
+
+		inventory.OnHand = payload.Quantity
+		inventory.Version++
+
+		return nil
+	})
+
+	if err != nil {
 		return nil, err
 	}
 
@@ -6,19 +6,20 @@ import (
 	"encoding/json"
 	"errors"
 	"net/http"
-	"saga-pattern/internal/client"
+	"saga-pattern/internal/database"
+	"saga-pattern/internal/etag"
 
 	"github.com/uptrace/bun"
 	"go.uber.org/fx"
 	"go.uber.org/zap"
 )
 
-func StartServer(lc fx.Lifecycle, db *bun.DB, logger *zap.Logger, api client.API, ctx context.Context) {
+func StartServer(lc fx.Lifecycle, db *bun.DB, logger *zap.Logger, ctx context.Context) {
 	lc.Append(fx.Hook{
 		OnStart: func(ctx context.Context) error {
 			go func() {
 				logger.Info("Starting server on port 8080")
-				if err := http.ListenAndServe(":8080", NewHandler(logger, db, ctx, api)); err != nil {
+				if err := http.ListenAndServe(":8080", NewHandler(logger, db, ctx)); err != nil {
 					logger.Error("Failed to start server", zap.Error(err))
 				}
 			}()
@@ -27,7 +28,7 @@ func StartServer(lc fx.Lifecycle, db *bun.DB, logger *zap.Logger, api client.API
 	})
 }
 
-func NewHandler(logger *zap.Logger, db *bun.DB, ctx context.Context, api client.API) http.Handler {
+func NewHandler(logger *zap.Logger, db *bun.DB, ctx context.Context) http.Handler {
 	mux := http.NewServeMux()
 
 	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
@@ -55,7 +56,7 @@ func NewHandler(logger *zap.Logger, db *bun.DB, ctx context.Context, api client.
 
 	mux.HandleFunc("GET /inventory/{id}", func(w http.ResponseWriter, r *http.Request) {
 		id := r.PathValue("id")
-		inventory, err := GetInventoryByID(r.Context(), db, id)
+		inventory, err := GetInventoryDetails(r.Context(), db, id)
 
 		if err != nil {
 			logger.Error("Failed to get inventory", zap.Error(err), zap.String("id", id))
@@ -70,13 +71,14 @@ func NewHandler(logger *zap.Logger, db *bun.DB, ctx context.Context, api client.
 			return
 		}
 
+		etag.Set(w, inventory.Version)
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusOK)
 		json.NewEncoder(w).Encode(inventory)
 	})
 
 	mux.HandleFunc("POST /inventory", func(w http.ResponseWriter, r *http.Request) {
-		inventory, err := CreateInventory(r.Context(), db, r, api)
+		inventory, err := CreateInventory(r.Context(), db, r)
 
 		if err != nil {
 			logger.Error("Failed to create inventory", zap.Error(err))
@@ -91,14 +93,35 @@ func NewHandler(logger *zap.Logger, db *bun.DB, ctx context.Context, api client.
 
 	mux.HandleFunc("PUT /inventory/{id}", func(w http.ResponseWriter, r *http.Request) {
 		id := r.PathValue("id")
-		inventory, err := UpdateInventory(r.Context(), db, id, r, api)
+		inventory, err := UpdateInventory(r.Context(), db, id, r)
 
 		if err != nil {
-			logger.Error("Failed to update inventory", zap.Error(err))
-			w.WriteHeader(http.StatusInternalServerError)
+			logger.Error("Failed to update inventory", zap.Error(err), zap.String("id", id))
+
+			switch {
+			case errors.Is(err, sql.ErrNoRows):
+				w.WriteHeader(http.StatusNotFound)
+				json.NewEncoder(w).Encode(map[string]string{"error": "Inventory not found"})
+			case errors.Is(err, etag.ErrMissing):
+				w.WriteHeader(http.StatusPreconditionRequired)
+				json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+			case errors.Is(err, etag.ErrMalformed):
+				w.WriteHeader(http.StatusBadRequest)
+				json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+			case errors.Is(err, database.ErrVersionMismatch):
+				w.WriteHeader(http.StatusPreconditionFailed)
+				json.NewEncoder(w).Encode(map[string]string{"error": "Inventory was changed, get it again"})
+			case errors.Is(err, ErrBelowReserved):
+				w.WriteHeader(http.StatusConflict)
+				json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+			default:
+				w.WriteHeader(http.StatusInternalServerError)
+			}
+
 			return
 		}
 
+		etag.Set(w, inventory.Version)
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusOK)
 		json.NewEncoder(w).Encode(inventory)
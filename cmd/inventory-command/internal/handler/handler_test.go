@@ -7,6 +7,7 @@ import (
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 
 	"github.com/uptrace/bun"
@@ -14,12 +15,13 @@ import (
 
 	"saga-pattern/internal/database"
 	"saga-pattern/internal/database/models"
+	"saga-pattern/internal/etag"
 )
 
 func setupHandler(t *testing.T) (http.Handler, *bun.DB) {
-	db := database.NewMockDatabase(t, &models.Inventory{})
+	db := database.NewMockDatabase(t, &models.Inventory{}, &models.Reservation{})
 	logger, _ := zap.NewDevelopment()
-	handler := NewHandler(logger, db, context.Background(), nil)
+	handler := NewHandler(logger, db, context.Background())
 	return handler, db
 }
 
@@ -96,7 +98,7 @@ func TestGetListInventoryEndpoint(t *testing.T) {
 			populateDB: func(db *bun.DB) []models.Inventory {
 				inventory := &models.Inventory{
 					ProductID: "1",
-					Quantity:  1,
+					OnHand:    1,
 				}
 
 				_, _ = db.NewInsert().Model(inventory).Returning("*").Exec(context.Background())
@@ -115,7 +117,7 @@ func TestGetListInventoryEndpoint(t *testing.T) {
 				for i := 0; i < 10; i++ {
 					inventory := &models.Inventory{
 						ProductID: fmt.Sprintf("%d", i),
-						Quantity:  int64(i),
+						OnHand:    int64(i),
 					}
 
 					inventoryList = append(inventoryList, *inventory)
@@ -204,7 +206,7 @@ func TestGetUniqueInventoryEndpoint(t *testing.T) {
 			populateDB: func(db *bun.DB) *models.Inventory {
 				inventory := &models.Inventory{
 					ProductID: "1",
-					Quantity:  1,
+					OnHand:    1,
 				}
 
 				_, _ = db.NewInsert().Model(inventory).Returning("*").Exec(context.Background())
@@ -273,3 +275,163 @@ func TestGetUniqueInventoryEndpoint(t *testing.T) {
 		})
 	}
 }
+
+func TestGetInventoryShowsReservations(t *testing.T) {
+	handler, db := setupHandler(t)
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	ctx := context.Background()
+
+	inventory := &models.Inventory{ProductID: "1", OnHand: 10, Reserved: 3}
+
+	if _, err := db.NewInsert().Model(inventory).Exec(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	reservations := []*models.Reservation{
+		{OrderID: "order-1", ProductID: "1", Quantity: 3, Status: models.ReservationStatusReserved},
+		{OrderID: "order-2", ProductID: "1", Quantity: 2, Status: models.ReservationStatusReleased},
+	}
+
+	for _, reservation := range reservations {
+		if _, err := db.NewInsert().Model(reservation).Exec(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	resp, err := http.Get(fmt.Sprintf("%s/inventory/%d", server.URL, inventory.ID))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	var details InventoryDetails
+
+	if err := json.NewDecoder(resp.Body).Decode(&details); err != nil {
+		t.Fatal(err)
+	}
+
+	if details.OnHand != 10 || details.Reserved != 3 || details.Available != 7 {
+		t.Errorf("expected 10 on hand, 3 reserved and 7 available, got %d, %d and %d", details.OnHand, details.Reserved, details.Available)
+	}
+
+	if len(details.Reservations) != 1 || details.Reservations[0].OrderID != "order-1" {
+		t.Errorf("expected the reservation of order-1 only, got %v", details.Reservations)
+	}
+}
+
+func TestUpdateInventoryRequiresTheCurrentVersion(t *testing.T) {
+	handler, db := setupHandler(t)
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	ctx := context.Background()
+
+	inventory := &models.Inventory{ProductID: "1", OnHand: 10, Reserved: 3}
+
+	if _, err := db.NewInsert().Model(inventory).Exec(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	url := fmt.Sprintf("%s/inventory/%d", server.URL, inventory.ID)
+
+	resp, err := http.Get(url)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	read := resp.Header.Get("ETag")
+
+	if read != etag.Format(1) {
+		t.Fatalf("expected the ETag of version 1, got %q", read)
+	}
+
+	put := func(ifMatch string, quantity int64) *http.Response {
+		req, err := http.NewRequest(http.MethodPut, url, strings.NewReader(fmt.Sprintf(`{"quantity": %d}`, quantity)))
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if ifMatch != "" {
+			req.Header.Set("If-Match", ifMatch)
+		}
+
+		resp, err := http.DefaultClient.Do(req)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+
+		return resp
+	}
+
+	if resp := put("", 20); resp.StatusCode != http.StatusPreconditionRequired {
+		t.Errorf("expected status %d without If-Match, got %d", http.StatusPreconditionRequired, resp.StatusCode)
+	}
+
+	if resp := put(read, 2); resp.StatusCode != http.StatusConflict {
+		t.Errorf("expected status %d below the reserved stock, got %d", http.StatusConflict, resp.StatusCode)
+	}
+
+	// A reservation of the saga bumps the version between the read and the write
+	_, err = database.BumpVersion(db.NewUpdate().Model((*models.Inventory)(nil))).
+		Set("reserved = reserved + 1").
+		Where("id = ?", inventory.ID).
+		Exec(ctx)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if resp := put(read, 20); resp.StatusCode != http.StatusPreconditionFailed {
+		t.Errorf("expected status %d for a stale version, got %d", http.StatusPreconditionFailed, resp.StatusCode)
+	}
+
+	if resp := put("2", 20); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected status %d for a malformed If-Match, got %d", http.StatusBadRequest, resp.StatusCode)
+	}
+
+	resp = put(etag.Format(2), 20)
+
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("ETag") != etag.Format(3) {
+		t.Errorf("expected status %d and the ETag of version 3, got %d and %q", http.StatusOK, resp.StatusCode, resp.Header.Get("ETag"))
+	}
+
+	// If-Match: * overwrites whatever version the inventory is at
+	resp = put("*", 25)
+
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("ETag") != etag.Format(4) {
+		t.Errorf("expected status %d and the ETag of version 4 for *, got %d and %q", http.StatusOK, resp.StatusCode, resp.Header.Get("ETag"))
+	}
+
+	if err := db.NewSelect().Model(inventory).WherePK().Scan(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if inventory.OnHand != 25 || inventory.Reserved != 4 {
+		t.Errorf("expected 4 of 25 reserved, got %d of %d", inventory.Reserved, inventory.OnHand)
+	}
+
+	req, err := http.NewRequest(http.MethodPut, server.URL+"/inventory/100", strings.NewReader(`{"quantity": 1}`))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req.Header.Set("If-Match", "*")
+
+	if resp, err = http.DefaultClient.Do(req); err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("expected status %d for * on a missing inventory, got %d", http.StatusNotFound, resp.StatusCode)
+	}
+}
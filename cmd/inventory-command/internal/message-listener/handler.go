@@ -2,137 +2,571 @@ package message_listener
 
 import (
 	"context"
-	"encoding/json"
+	"database/sql"
+	"errors"
 	"saga-pattern/internal/client"
+	"saga-pattern/internal/database"
 	"saga-pattern/internal/database/models"
-	"github.com/segmentio/kafka-go"
+	"saga-pattern/internal/events"
+	"saga-pattern/internal/router"
+	"time"
 
 	"github.com/uptrace/bun"
-	"fmt"
 	"go.uber.org/fx"
 	"go.uber.org/zap"
 )
 
-type OrderMessage struct {
-	ID       int64   `json:"id"`
-	OrderID  string  `json:"order_id"`
-	UserID   int64   `json:"user_id"`
-	Product  string  `json:"product"`
-	Quantity int64   `json:"quantity"`
-	Price    float64 `json:"price"`
-}
-
 const (
-	OrderCreatedKey = "OrderCreated"
-	OrderCreatedTopic = "orders"
-	RevertOrderTopic = "inventory"
-	RevertOrderKey = "RevertOrder"
+	ReserveInventoryType = events.ReserveInventoryType
+	ReleaseInventoryType = events.ReleaseInventoryType
+	OrderCanceledType    = events.OrderCanceledType
+	OrderConfirmedType   = events.OrderConfirmedType
+
+	// SagaTopic carries the commands of the saga orchestrator
+	SagaTopic = "saga"
+
+	// OrderTopic carries the confirmations and cancellations of the orders
+	OrderTopic = "orders"
+
+	// InventoryTopic receives the replies of the inventory service to the saga commands
+	InventoryTopic = "inventory"
 )
 
-func StartKafkaListener(lc fx.Lifecycle, db *bun.DB, logger *zap.Logger, api client.API) {
-	lc.Append(fx.Hook{
-		OnStart: func(ctx context.Context) error {
-			go func() {
-				logger.Info("Starting Kafka message listener")
-
-				if err := db.PingContext(ctx); err != nil {
-					logger.Error("Database connection is not healthy", zap.Error(err))
-					return
-				}
-				logger.Info("Database connection verified")
-				
-				for {
-					message, err := api.ReadMessage(ctx)
-					
-					if err != nil {
-						logger.Error("Failed to read message from Kafka", zap.Error(err))
-						continue
-					}
-
-					logger.Info("Received message from Kafka", 
-						zap.String("topic", message.Topic),
-						zap.String("key", string(message.Key)),
-						zap.String("key_hex", fmt.Sprintf("%x", message.Key)),
-						zap.String("value", string(message.Value)))
-
-					key := string(message.Key)
-
-					switch key {
-					case OrderCreatedKey:
-						if err := handleOrderCreated(db, logger, message.Value, api); err != nil {
-							logger.Error("Failed to handle OrderCreated message, reverting message sent", zap.Error(err))
-						}
-					default:
-						logger.Warn("Unknown message type", zap.String("key", key))
-					}
-				}
-			}()
-			return nil
-		},
+var (
+	// ErrProductNotFound rejects the reservation of a product without inventory
+	ErrProductNotFound = errors.New("product not found")
+
+	// ErrInsufficientStock rejects the reservation of more than the available stock
+	ErrInsufficientStock = errors.New("insufficient stock")
+)
+
+// ReservationError rejects an order with the lines whose stock cannot be reserved
+type ReservationError struct {
+	Reason string
+	Lines  []events.FailedLine
+}
+
+func (e *ReservationError) Error() string {
+	return e.Reason
+}
+
+// NewRouter registers the handlers of the saga commands and order events consumed by the
+// inventory service
+func NewRouter(db *bun.DB, logger *zap.Logger) *router.Router {
+	r := router.New(logger, router.WithUnknownPolicy(router.IgnoreUnknown))
+
+	r.Use(
+		router.Logging(logger),
+		router.Retry(client.NewRetrier(logger, db, nil)),
+		router.Deduplicate(db, logger),
+		router.Recover(logger),
+	)
+
+	router.Handle(r, func(ctx context.Context, command events.ReserveInventory) error {
+		return handleReserveInventory(ctx, db, logger, router.Envelope(ctx), command)
 	})
+
+	router.Handle(r, func(ctx context.Context, command events.ReleaseInventory) error {
+		return handleReleaseInventory(ctx, db, logger, router.Envelope(ctx), command)
+	})
+
+	router.Handle(r, func(ctx context.Context, event events.OrderConfirmed) error {
+		return handleOrderConfirmed(ctx, db, logger, router.Envelope(ctx), event)
+	})
+
+	router.Handle(r, func(ctx context.Context, event events.OrderCanceled) error {
+		return handleOrderCanceled(ctx, db, logger, event)
+	})
+
+	return r
+}
+
+func StartKafkaListener(lc fx.Lifecycle, db *bun.DB, logger *zap.Logger, api client.API, ctx context.Context) {
+	router.Start(ctx, lc, logger, db, api, NewRouter(db, logger))
 }
 
-func handleOrderCreated(db *bun.DB, logger *zap.Logger, value []byte, api client.API) error {
-	var orderMsg OrderMessage
-	if err := json.Unmarshal(value, &orderMsg); err != nil {
+// handleReserveInventory reserves the stock of every line of the order in a single
+// transaction. When a line cannot be reserved nothing is, and the failure lists every line
+// that failed.
+func handleReserveInventory(ctx context.Context, db *bun.DB, logger *zap.Logger, envelope events.Envelope, command events.ReserveInventory) error {
+	logger.Info("Processing ReserveInventory command",
+		zap.String("orderID", command.OrderID),
+		zap.Int("lines", len(command.Lines)))
+
+	inboxKey := database.InboxKey(command.OrderID, ReserveInventoryType)
+
+	err := db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		if err := database.MarkProcessed(ctx, tx, inboxKey); err != nil {
+			return err
+		}
+
+		if err := reserveLines(ctx, tx, logger, command); err != nil {
+			return err
+		}
+
+		event := events.InventoryReserved{OrderID: command.OrderID, Lines: command.Lines}
+
+		return database.EnqueueEvent(ctx, tx, InventoryTopic, events.NewFrom(envelope, events.SourceInventory, event), event)
+	})
+
+	if errors.Is(err, database.ErrDuplicateMessage) {
+		logger.Info("Skipping duplicate ReserveInventory command", zap.String("orderID", command.OrderID))
+		return nil
+	}
+
+	// The lines reserved before the failing ones were rolled back with the transaction
+	var rejected *ReservationError
+
+	if errors.As(err, &rejected) {
+		logger.Warn("Rejecting reservation of order",
+			zap.String("orderID", command.OrderID),
+			zap.String("reason", rejected.Reason),
+			zap.Int("failed", len(rejected.Lines)))
+
+		err := rejectReservation(ctx, db, inboxKey, envelope, command.OrderID, rejected.Reason, rejected.Lines)
+
+		if errors.Is(err, database.ErrDuplicateMessage) {
+			return nil
+		}
+
 		return err
 	}
 
-	var revertMessage kafka.Message = kafka.Message{
-		Topic: RevertOrderTopic,
-		Key: []byte(RevertOrderKey),
-		Value: []byte(orderMsg.OrderID),
+	// Unexpected errors are retried and dead lettered, only a rejected order is answered
+	if err != nil {
+		logger.Error("Failed to reserve inventory", zap.String("orderID", command.OrderID), zap.Error(err))
+		return err
 	}
 
-	logger.Info("Processing OrderCreated message", 
-		zap.String("orderID", orderMsg.OrderID),
-		zap.String("product", orderMsg.Product),
-		zap.Int64("quantity", orderMsg.Quantity))
+	logger.Info("Successfully reserved inventory for order",
+		zap.String("orderID", command.OrderID),
+		zap.Int("lines", len(command.Lines)))
 
-	inventory := &models.Inventory{}
-	err := db.NewSelect().Model(inventory).
-		Where("product_id = ?", orderMsg.Product).
-		Scan(context.Background())
+	return nil
+}
 
-	if err != nil {
-		logger.Error("Reverting order, failed to get inventory for product", 
-			zap.String("product", orderMsg.Product), 
-			zap.Error(err))
+// reserveLines reserves every line of the order and records a reservation per line. Every
+// line is tried so the ReservationError it returns lists all the lines that failed.
+func reserveLines(ctx context.Context, tx bun.Tx, logger *zap.Logger, command events.ReserveInventory) error {
+	if len(command.Lines) == 0 {
+		return &ReservationError{Reason: "order has no lines"}
+	}
+
+	expiresAt := time.Now().Add(ReservationTTL())
 
-		api.SendMessage(context.Background(), revertMessage)
+	var failed []events.FailedLine
 
+	for _, line := range command.Lines {
+		inventory := &models.Inventory{}
+
+		err := reserveStock(ctx, tx, inventory, line.Product, line.Quantity)
+
+		if errors.Is(err, ErrProductNotFound) || errors.Is(err, ErrInsufficientStock) {
+			logger.Warn("Cannot reserve line of order",
+				zap.String("orderID", command.OrderID),
+				zap.String("product", line.Product),
+				zap.Int64("requested", line.Quantity),
+				zap.Int64("available", inventory.Available()),
+				zap.Error(err))
+
+			failed = append(failed, events.FailedLine{Product: line.Product, Quantity: line.Quantity, Reason: err.Error()})
+			continue
+		}
+
+		if err != nil {
+			return err
+		}
+
+		reservation := &models.Reservation{
+			OrderID:   command.OrderID,
+			ProductID: line.Product,
+			Quantity:  line.Quantity,
+			Status:    models.ReservationStatusReserved,
+			ExpiresAt: expiresAt,
+		}
+
+		if _, err := tx.NewInsert().Model(reservation).Exec(ctx); err != nil {
+			return err
+		}
+	}
+
+	if len(failed) > 0 {
+		return &ReservationError{Reason: failed[0].Reason, Lines: failed}
+	}
+
+	return nil
+}
+
+// handleReleaseInventory gives back the stock reserved by the order, it compensates the
+// reservation when a later step of the saga fails. The command is always answered so the
+// compensation can go on, even when there is nothing left to release.
+func handleReleaseInventory(ctx context.Context, db *bun.DB, logger *zap.Logger, envelope events.Envelope, command events.ReleaseInventory) error {
+	logger.Info("Processing ReleaseInventory command",
+		zap.String("orderID", command.OrderID),
+		zap.String("reason", command.Reason))
+
+	err := db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		if err := database.MarkProcessed(ctx, tx, database.InboxKey(command.OrderID, ReleaseInventoryType)); err != nil {
+			return err
+		}
+
+		released, err := releaseReservation(ctx, tx, logger, command.OrderID, models.ReservationStatusReserved, models.ReservationStatusCommitted, models.ReservationStatusExpired)
+
+		if err != nil {
+			return err
+		}
+
+		event := events.InventoryReleased{OrderID: command.OrderID, Lines: []events.OrderLine{}}
+
+		for _, reservation := range released {
+			event.Lines = append(event.Lines, events.OrderLine{Product: reservation.ProductID, Quantity: reservation.Quantity})
+		}
+
+		return database.EnqueueEvent(ctx, tx, InventoryTopic, events.NewFrom(envelope, events.SourceInventory, event), event)
+	})
+
+	if errors.Is(err, database.ErrDuplicateMessage) {
+		logger.Info("Skipping duplicate ReleaseInventory command", zap.String("orderID", command.OrderID))
 		return nil
 	}
 
-	if inventory.Quantity < orderMsg.Quantity {
-		logger.Warn("Reverting order, insufficient inventory for order", 
-			zap.String("orderID", orderMsg.OrderID),
-			zap.String("product", orderMsg.Product),
-			zap.Int64("requested", orderMsg.Quantity),
-			zap.Int64("available", inventory.Quantity))
+	return err
+}
+
+// handleOrderCanceled gives back the stock still held for an order canceled by its user
+// without waiting for the compensation of the saga. A committed reservation is left to
+// ReleaseInventory, which only runs once the shipment of the order was really canceled.
+func handleOrderCanceled(ctx context.Context, db *bun.DB, logger *zap.Logger, canceled events.OrderCanceled) error {
+	logger.Info("Processing OrderCanceled event",
+		zap.String("orderID", canceled.OrderID),
+		zap.String("reason", canceled.Reason))
 
-		api.SendMessage(context.Background(), revertMessage)
+	err := db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		if err := database.MarkProcessed(ctx, tx, database.InboxKey(canceled.OrderID, OrderCanceledType)); err != nil {
+			return err
+		}
 
+		_, err := releaseReservation(ctx, tx, logger, canceled.OrderID, models.ReservationStatusReserved, models.ReservationStatusExpired)
+
+		return err
+	})
+
+	if errors.Is(err, database.ErrDuplicateMessage) {
+		logger.Info("Skipping duplicate OrderCanceled event", zap.String("orderID", canceled.OrderID))
+		return nil
+	}
+
+	return err
+}
+
+// handleOrderConfirmed commits the reservation of a confirmed order: its stock is sold and
+// leaves the stock on hand. When the stock of an expired reservation is gone nothing is
+// committed, and InventoryCommitFailed cancels the saga of the order.
+func handleOrderConfirmed(ctx context.Context, db *bun.DB, logger *zap.Logger, envelope events.Envelope, confirmed events.OrderConfirmed) error {
+	logger.Info("Processing OrderConfirmed event", zap.String("orderID", confirmed.OrderID))
+
+	inboxKey := database.InboxKey(confirmed.OrderID, OrderConfirmedType)
+
+	err := db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		if err := database.MarkProcessed(ctx, tx, inboxKey); err != nil {
+			return err
+		}
+
+		return commitReservation(ctx, tx, logger, confirmed.OrderID)
+	})
+
+	if errors.Is(err, database.ErrDuplicateMessage) {
+		logger.Info("Skipping duplicate OrderConfirmed event", zap.String("orderID", confirmed.OrderID))
 		return nil
 	}
 
-	inventory.Quantity -= orderMsg.Quantity
+	// The lines committed before the failing one were rolled back with the transaction
+	var rejected *ReservationError
+
+	if errors.As(err, &rejected) {
+		logger.Error("Cannot commit inventory of confirmed order, canceling it",
+			zap.String("orderID", confirmed.OrderID),
+			zap.String("reason", rejected.Reason))
+
+		err := db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+			if err := database.MarkProcessed(ctx, tx, inboxKey); err != nil {
+				return err
+			}
+
+			event := events.InventoryCommitFailed{OrderID: confirmed.OrderID, Reason: rejected.Reason, Lines: rejected.Lines}
+
+			return database.EnqueueEvent(ctx, tx, InventoryTopic, events.NewFrom(envelope, events.SourceInventory, event), event)
+		})
+
+		if errors.Is(err, database.ErrDuplicateMessage) {
+			return nil
+		}
+
+		return err
+	}
+
+	return err
+}
+
+// commitReservation moves the reservations of the order from the reserved stock out of the
+// stock on hand. An expired reservation no longer holds its stock, so it is taken again from
+// the stock left on hand, and the commit fails with a ReservationError when it is gone
+// instead of overselling the product. The reservations released by a compensation are left
+// as they are.
+func commitReservation(ctx context.Context, tx bun.Tx, logger *zap.Logger, orderID string) error {
+	var reservations []models.Reservation
 
-	_, err = db.NewUpdate().Model(inventory).
-		Where("product_id = ?", orderMsg.Product).
-		Exec(context.Background())
+	err := tx.NewSelect().Model(&reservations).
+		Where("order_id = ?", orderID).
+		Where("status IN (?)", bun.In([]models.ReservationStatus{models.ReservationStatusReserved, models.ReservationStatusExpired})).
+		Order("id").
+		Scan(ctx)
 
 	if err != nil {
-		logger.Error("Reverting order, failed to update inventory", zap.Error(err))
-		api.SendMessage(context.Background(), revertMessage)
 		return err
 	}
 
-	logger.Info("Successfully processed order and updated inventory", 
-		zap.String("orderID", orderMsg.OrderID),
-		zap.String("product", orderMsg.Product),
-		zap.Int64("quantity", orderMsg.Quantity),
-		zap.Int64("remaining", inventory.Quantity))
+	committed := 0
+
+	for _, reservation := range reservations {
+		result, err := tx.NewUpdate().Model((*models.Reservation)(nil)).
+			Set("status = ?", models.ReservationStatusCommitted).
+			Set("committed_at = ?", time.Now()).
+			Where("id = ?", reservation.ID).
+			Where("status = ?", reservation.Status).
+			Exec(ctx)
+
+		if err != nil {
+			return err
+		}
+
+		if affected, err := result.RowsAffected(); err != nil || affected == 0 {
+			if err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		sell := database.BumpVersion(tx.NewUpdate().Model((*models.Inventory)(nil))).
+			Set("on_hand = on_hand - ?", reservation.Quantity).
+			Where("product_id = ?", reservation.ProductID)
+
+		if reservation.Status == models.ReservationStatusExpired {
+			sell = sell.Where("on_hand - reserved >= ?", reservation.Quantity)
+		} else {
+			sell = sell.Set("reserved = reserved - ?", reservation.Quantity)
+		}
+
+		result, err = sell.Exec(ctx)
+
+		if err != nil {
+			return err
+		}
+
+		if affected, err := result.RowsAffected(); err != nil || affected == 0 {
+			if err != nil {
+				return err
+			}
+
+			logger.Warn("Cannot commit inventory of order, its reservation expired and the stock is gone",
+				zap.String("orderID", orderID),
+				zap.String("product", reservation.ProductID),
+				zap.Int64("quantity", reservation.Quantity))
+
+			line := events.FailedLine{Product: reservation.ProductID, Quantity: reservation.Quantity, Reason: ErrInsufficientStock.Error()}
+
+			return &ReservationError{Reason: "reservation expired and the stock is gone", Lines: []events.FailedLine{line}}
+		}
+
+		logger.Info("Committed inventory of order",
+			zap.String("orderID", orderID),
+			zap.String("product", reservation.ProductID),
+			zap.Int64("quantity", reservation.Quantity),
+			zap.Stringer("status", reservation.Status))
+
+		committed++
+	}
+
+	if committed == 0 {
+		logger.Warn("Nothing to commit for order, its reservations are missing or released", zap.String("orderID", orderID))
+	}
 
 	return nil
-}
\ No newline at end of file
+}
+
+// releaseReservation gives back the stock of the lines of the order in one of statuses and
+// returns the reservations it released, none when there was nothing left to release
+func releaseReservation(ctx context.Context, tx bun.Tx, logger *zap.Logger, orderID string, statuses ...models.ReservationStatus) ([]models.Reservation, error) {
+	var reservations []models.Reservation
+
+	err := tx.NewSelect().Model(&reservations).
+		Where("order_id = ?", orderID).
+		Where("status IN (?)", bun.In(statuses)).
+		Order("id").
+		Scan(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var released []models.Reservation
+
+	for i := range reservations {
+		free := release
+
+		if reservations[i].Status == models.ReservationStatusCommitted {
+			free = restock
+		}
+
+		ok, err := free(ctx, tx, logger, &reservations[i])
+
+		if err != nil {
+			return nil, err
+		}
+
+		if ok {
+			released = append(released, reservations[i])
+		}
+	}
+
+	if len(released) == 0 {
+		logger.Info("Nothing to release for order", zap.String("orderID", orderID))
+	}
+
+	return released, nil
+}
+
+// release frees the stock held by a reserved reservation for the other orders, an expired
+// one had already freed it. Committed stock is left alone, only restock puts it back on hand.
+// It reports false when there was nothing to release.
+func release(ctx context.Context, tx bun.Tx, logger *zap.Logger, reservation *models.Reservation) (bool, error) {
+	status := reservation.Status
+
+	if status != models.ReservationStatusReserved && status != models.ReservationStatusExpired {
+		logger.Info("Reservation holds no stock to release", zap.String("orderID", reservation.OrderID), zap.Stringer("status", status))
+		return false, nil
+	}
+
+	if ok, err := markReleased(ctx, tx, reservation); !ok || err != nil {
+		return false, err
+	}
+
+	if status == models.ReservationStatusReserved {
+		_, err := database.BumpVersion(tx.NewUpdate().Model((*models.Inventory)(nil))).
+			Set("reserved = reserved - ?", reservation.Quantity).
+			Where("product_id = ?", reservation.ProductID).
+			Exec(ctx)
+
+		if err != nil {
+			return false, err
+		}
+	}
+
+	logger.Info("Released inventory of order",
+		zap.String("orderID", reservation.OrderID),
+		zap.String("product", reservation.ProductID),
+		zap.Int64("quantity", reservation.Quantity),
+		zap.Stringer("status", status))
+
+	return true, nil
+}
+
+// restock puts the stock of a committed reservation back on hand. It reports false when
+// the reservation is not committed or was restocked in between.
+func restock(ctx context.Context, tx bun.Tx, logger *zap.Logger, reservation *models.Reservation) (bool, error) {
+	if reservation.Status != models.ReservationStatusCommitted {
+		return false, nil
+	}
+
+	if ok, err := markReleased(ctx, tx, reservation); !ok || err != nil {
+		return false, err
+	}
+
+	_, err := database.BumpVersion(tx.NewUpdate().Model((*models.Inventory)(nil))).
+		Set("on_hand = on_hand + ?", reservation.Quantity).
+		Where("product_id = ?", reservation.ProductID).
+		Exec(ctx)
+
+	if err != nil {
+		return false, err
+	}
+
+	logger.Info("Restocked inventory of order",
+		zap.String("orderID", reservation.OrderID),
+		zap.String("product", reservation.ProductID),
+		zap.Int64("quantity", reservation.Quantity))
+
+	return true, nil
+}
+
+// markReleased moves the reservation to released from the status it was read with, so a
+// single release of the same reservation wins. It reports false when another one did.
+func markReleased(ctx context.Context, tx bun.Tx, reservation *models.Reservation) (bool, error) {
+	result, err := tx.NewUpdate().Model((*models.Reservation)(nil)).
+		Set("status = ?", models.ReservationStatusReleased).
+		Set("released_at = ?", time.Now()).
+		Where("id = ?", reservation.ID).
+		Where("status = ?", reservation.Status).
+		Exec(ctx)
+
+	if err != nil {
+		return false, err
+	}
+
+	if affected, err := result.RowsAffected(); err != nil || affected == 0 {
+		return false, err
+	}
+
+	reservation.Status = models.ReservationStatusReleased
+
+	return true, nil
+}
+
+// reserveStock holds quantity of the stock of product and loads its inventory. The check and
+// the update are a single conditional statement, so concurrent reservations of the same
+// product cannot both pass the check and oversell it.
+func reserveStock(ctx context.Context, tx bun.Tx, inventory *models.Inventory, product string, quantity int64) error {
+	result, err := database.BumpVersion(tx.NewUpdate().Model((*models.Inventory)(nil))).
+		Set("reserved = reserved + ?", quantity).
+		Where("product_id = ?", product).
+		Where("on_hand - reserved >= ?", quantity).
+		Exec(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	affected, err := result.RowsAffected()
+
+	if err != nil {
+		return err
+	}
+
+	err = tx.NewSelect().Model(inventory).Where("product_id = ?", product).Scan(ctx)
+
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return ErrProductNotFound
+	case err != nil:
+		return err
+	case affected == 0:
+		return ErrInsufficientStock
+	}
+
+	return nil
+}
+
+// rejectReservation answers ReserveInventory with InventoryReservationFailed, in a
+// transaction of its own since the one reserving the stock was rolled back
+func rejectReservation(ctx context.Context, db *bun.DB, inboxKey string, cause events.Envelope, orderID string, reason string, lines []events.FailedLine) error {
+	return db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		if err := database.MarkProcessed(ctx, tx, inboxKey); err != nil {
+			return err
+		}
+
+		event := events.InventoryReservationFailed{OrderID: orderID, Reason: reason, Lines: lines}
+
+		return database.EnqueueEvent(ctx, tx, InventoryTopic, events.NewFrom(cause, events.SourceInventory, event), event)
+	})
+}
@@ -0,0 +1,713 @@
+package message_listener
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/uptrace/bun"
+	"github.com/uptrace/bun/dialect/sqlitedialect"
+	"github.com/uptrace/bun/driver/sqliteshim"
+	"go.uber.org/zap"
+
+	"saga-pattern/internal/client"
+	"saga-pattern/internal/database"
+	"saga-pattern/internal/database/models"
+	"saga-pattern/internal/events"
+	"saga-pattern/internal/router"
+)
+
+func TestHandleReserveInventoryIsIdempotent(t *testing.T) {
+	db := database.NewMockDatabase(t, &models.Inventory{}, &models.Reservation{}, &models.OutboxMessage{}, &models.InboxMessage{})
+	logger, _ := zap.NewDevelopment()
+	ctx := context.Background()
+
+	inventory := &models.Inventory{ProductID: "1", OnHand: 10}
+
+	if _, err := db.NewInsert().Model(inventory).Exec(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	command := events.ReserveInventory{OrderID: "order-1", Lines: []events.OrderLine{{Product: "1", Quantity: 3}}}
+	headers, value, err := events.Encode(events.New(events.SourceOrchestrator, command.OrderID, command), command)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	message := client.Message{Topic: SagaTopic, Key: []byte(command.OrderID), Value: value, Headers: headers}
+
+	r := NewRouter(db, logger)
+
+	for i := 0; i < 2; i++ {
+		if err := r.Dispatch(ctx, message); err != nil {
+			t.Fatalf("delivery %d: %v", i+1, err)
+		}
+	}
+
+	if err := db.NewSelect().Model(inventory).WherePK().Scan(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if inventory.OnHand != 10 || inventory.Reserved != 3 {
+		t.Errorf("expected 3 of 10 reserved after a duplicate delivery, got %d of %d", inventory.Reserved, inventory.OnHand)
+	}
+
+	if inventory.Version != 2 {
+		t.Errorf("expected the reservation to bump the version once, got version %d", inventory.Version)
+	}
+}
+
+func TestHandleReserveInventoryReplies(t *testing.T) {
+	db := database.NewMockDatabase(t, &models.Inventory{}, &models.Reservation{}, &models.OutboxMessage{}, &models.InboxMessage{})
+	logger, _ := zap.NewDevelopment()
+	ctx := context.Background()
+
+	if _, err := db.NewInsert().Model(&models.Inventory{ProductID: "1", OnHand: 5}).Exec(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	r := NewRouter(db, logger)
+
+	for _, command := range []events.ReserveInventory{
+		{OrderID: "order-1", Lines: []events.OrderLine{{Product: "1", Quantity: 3}}},
+		{OrderID: "order-2", Lines: []events.OrderLine{{Product: "1", Quantity: 3}}},
+	} {
+		envelope := events.New(events.SourceOrchestrator, command.OrderID, command)
+		headers, value, err := events.Encode(envelope, command)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := r.Dispatch(ctx, client.Message{Topic: SagaTopic, Key: []byte(command.OrderID), Value: value, Headers: headers}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var replies []models.OutboxMessage
+
+	if err := db.NewSelect().Model(&replies).Order("id").Scan(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{events.InventoryReservedType, events.InventoryReservationFailedType}
+
+	if len(replies) != len(expected) {
+		t.Fatalf("expected %d replies, got %d", len(expected), len(replies))
+	}
+
+	for i, reply := range replies {
+		if reply.Topic != InventoryTopic || reply.Headers[events.HeaderType] != expected[i] {
+			t.Errorf("expected %s on %s, got %s on %s", expected[i], InventoryTopic, reply.Headers[events.HeaderType], reply.Topic)
+		}
+	}
+}
+
+func TestReserveInventoryReservesEveryLineOrNone(t *testing.T) {
+	db := database.NewMockDatabase(t, &models.Inventory{}, &models.Reservation{}, &models.OutboxMessage{}, &models.InboxMessage{})
+	logger, _ := zap.NewDevelopment()
+	ctx := context.Background()
+
+	inventories := []*models.Inventory{
+		{ProductID: "1", OnHand: 10},
+		{ProductID: "2", OnHand: 1},
+	}
+
+	for _, inventory := range inventories {
+		if _, err := db.NewInsert().Model(inventory).Exec(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r := NewRouter(db, logger)
+
+	// The first line can be reserved, the second lacks stock and the third is unknown
+	rejected := events.ReserveInventory{OrderID: "order-1", Lines: []events.OrderLine{
+		{Product: "1", Quantity: 3},
+		{Product: "2", Quantity: 2},
+		{Product: "3", Quantity: 1},
+	}}
+
+	accepted := events.ReserveInventory{OrderID: "order-2", Lines: []events.OrderLine{
+		{Product: "1", Quantity: 3},
+		{Product: "2", Quantity: 1},
+	}}
+
+	dispatchTwice(t, r, SagaTopic, rejected)
+	dispatchTwice(t, r, SagaTopic, accepted)
+
+	for i, expected := range []int64{3, 1} {
+		if err := db.NewSelect().Model(inventories[i]).WherePK().Scan(ctx); err != nil {
+			t.Fatal(err)
+		}
+
+		if inventories[i].Reserved != expected {
+			t.Errorf("expected %d of product %s reserved by order-2 only, got %d", expected, inventories[i].ProductID, inventories[i].Reserved)
+		}
+	}
+
+	count, err := db.NewSelect().Model((*models.Reservation)(nil)).Where("order_id = ?", "order-1").Count(ctx)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if count != 0 {
+		t.Errorf("expected no reservation for the rejected order, got %d", count)
+	}
+
+	var messages []models.OutboxMessage
+
+	if err := db.NewSelect().Model(&messages).Order("id").Scan(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(messages) != 2 || messages[0].Headers[events.HeaderType] != events.InventoryReservationFailedType {
+		t.Fatalf("expected a failure then a reservation, got %d messages", len(messages))
+	}
+
+	var failed events.InventoryReservationFailed
+
+	if err := json.Unmarshal(messages[0].Value, &failed); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []events.FailedLine{
+		{Product: "2", Quantity: 2, Reason: ErrInsufficientStock.Error()},
+		{Product: "3", Quantity: 1, Reason: ErrProductNotFound.Error()},
+	}
+
+	if !reflect.DeepEqual(failed.Lines, expected) {
+		t.Errorf("expected the failed lines %+v, got %+v", expected, failed.Lines)
+	}
+}
+
+// TestReservationsStopAtTheStockOnHand checks the accounting of many orders of the same
+// product, TestConcurrentReservationsOfTheLastUnits the conditional update they rely on
+func TestReservationsStopAtTheStockOnHand(t *testing.T) {
+	db := database.NewMockDatabase(t, &models.Inventory{}, &models.Reservation{}, &models.OutboxMessage{}, &models.InboxMessage{})
+	logger := zap.NewNop()
+	ctx := context.Background()
+
+	inventory := &models.Inventory{ProductID: "1", OnHand: 10}
+
+	if _, err := db.NewInsert().Model(inventory).Exec(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	r := NewRouter(db, logger)
+
+	const orders = 20
+
+	for i := 0; i < orders; i++ {
+		command := events.ReserveInventory{OrderID: fmt.Sprintf("order-%d", i), Lines: []events.OrderLine{{Product: "1", Quantity: 3}}}
+		headers, value, err := events.Encode(events.New(events.SourceOrchestrator, command.OrderID, command), command)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := r.Dispatch(ctx, client.Message{Topic: SagaTopic, Key: []byte(command.OrderID), Value: value, Headers: headers}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := db.NewSelect().Model(inventory).WherePK().Scan(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if inventory.Reserved != 9 || inventory.Available() < 0 {
+		t.Errorf("expected 9 of 10 reserved, got %d of %d", inventory.Reserved, inventory.OnHand)
+	}
+
+	var messages []models.OutboxMessage
+
+	if err := db.NewSelect().Model(&messages).Scan(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	refused := 0
+
+	for _, message := range messages {
+		if message.Headers[events.HeaderType] != events.InventoryReservationFailedType {
+			continue
+		}
+
+		var failed events.InventoryReservationFailed
+
+		if err := json.Unmarshal(message.Value, &failed); err != nil {
+			t.Fatal(err)
+		}
+
+		if failed.Reason != ErrInsufficientStock.Error() {
+			t.Errorf("expected %s to fail with %q, got %q", failed.OrderID, ErrInsufficientStock, failed.Reason)
+		}
+
+		refused++
+	}
+
+	if refused != orders-3 {
+		t.Errorf("expected %d orders to be refused, got %d", orders-3, refused)
+	}
+}
+
+// Two orders race for the last units: the second transaction starts while the first one
+// holds its reservation, and its update must see the stock the first one took
+func TestConcurrentReservationsOfTheLastUnits(t *testing.T) {
+	ctx := context.Background()
+
+	// Unlike an in-memory database, a file is shared by the connections of the pool
+	sqldb, err := sql.Open(sqliteshim.ShimName, "file:"+filepath.Join(t.TempDir(), "inventory.db"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db := bun.NewDB(sqldb, sqlitedialect.New())
+	t.Cleanup(func() { _ = db.Close() })
+
+	// With WAL the second transaction waits for the write lock instead of deadlocking
+	if _, err := db.ExecContext(ctx, "PRAGMA journal_mode = WAL"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := db.NewCreateTable().Model((*models.Inventory)(nil)).Exec(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := db.NewInsert().Model(&models.Inventory{ProductID: "1", OnHand: 5}).Exec(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	begin := func() bun.Tx {
+		conn, err := db.Conn(ctx)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		t.Cleanup(func() { _ = conn.Close() })
+
+		if _, err := conn.ExecContext(ctx, "PRAGMA busy_timeout = 5000"); err != nil {
+			t.Fatal(err)
+		}
+
+		tx, err := conn.BeginTx(ctx, nil)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return tx
+	}
+
+	first, second := begin(), begin()
+
+	if err := reserveStock(ctx, first, &models.Inventory{}, "1", 3); err != nil {
+		t.Fatal(err)
+	}
+
+	reserved := make(chan error, 1)
+
+	go func() {
+		err := reserveStock(ctx, second, &models.Inventory{}, "1", 3)
+
+		if err != nil {
+			_ = second.Rollback()
+		} else {
+			err = second.Commit()
+		}
+
+		reserved <- err
+	}()
+
+	select {
+	case err := <-reserved:
+		t.Fatalf("expected the second reservation to wait for the first one, got %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	if err := first.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := <-reserved; !errors.Is(err, ErrInsufficientStock) {
+		t.Fatalf("expected exactly one reservation to succeed, the second got %v", err)
+	}
+
+	inventory := &models.Inventory{}
+
+	if err := db.NewSelect().Model(inventory).Where("product_id = ?", "1").Scan(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if inventory.Reserved != 3 {
+		t.Errorf("expected 3 of 5 reserved, got %d", inventory.Reserved)
+	}
+}
+
+func TestHandleReleaseInventoryRestocksOnce(t *testing.T) {
+	db := database.NewMockDatabase(t, &models.Inventory{}, &models.Reservation{}, &models.OutboxMessage{}, &models.InboxMessage{})
+	logger, _ := zap.NewDevelopment()
+	ctx := context.Background()
+
+	inventory := &models.Inventory{ProductID: "1", OnHand: 10}
+
+	if _, err := db.NewInsert().Model(inventory).Exec(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	r := NewRouter(db, logger)
+
+	reserve := events.ReserveInventory{OrderID: "order-1", Lines: []events.OrderLine{{Product: "1", Quantity: 3}}}
+	release := events.ReleaseInventory{OrderID: "order-1", Lines: []events.OrderLine{{Product: "1", Quantity: 3}}, Reason: "insufficient balance"}
+
+	// The order-2 never reserved anything, releasing it must not restock
+	unreserved := events.ReleaseInventory{OrderID: "order-2", Lines: []events.OrderLine{{Product: "1", Quantity: 4}}, Reason: "saga expired"}
+
+	for _, command := range []events.Event{reserve, release, unreserved} {
+		dispatchTwice(t, r, SagaTopic, command)
+	}
+
+	if err := db.NewSelect().Model(inventory).WherePK().Scan(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if inventory.OnHand != 10 || inventory.Reserved != 0 {
+		t.Errorf("expected nothing reserved after a duplicate delivery, got %d of %d", inventory.Reserved, inventory.OnHand)
+	}
+
+	var replies []models.OutboxMessage
+
+	if err := db.NewSelect().Model(&replies).Scan(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	released := 0
+
+	for _, reply := range replies {
+		if reply.Headers[events.HeaderType] == events.InventoryReleasedType {
+			released++
+		}
+	}
+
+	if released != 2 {
+		t.Errorf("expected an InventoryReleased reply per released order, got %d", released)
+	}
+}
+
+func TestOrderCanceledAndReleaseInventoryRestockOnce(t *testing.T) {
+	db := database.NewMockDatabase(t, &models.Inventory{}, &models.Reservation{}, &models.OutboxMessage{}, &models.InboxMessage{})
+	logger, _ := zap.NewDevelopment()
+	ctx := context.Background()
+
+	inventory := &models.Inventory{ProductID: "1", OnHand: 10}
+
+	if _, err := db.NewInsert().Model(inventory).Exec(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	r := NewRouter(db, logger)
+
+	dispatchTwice(t, r, SagaTopic, events.ReserveInventory{OrderID: "order-1", Lines: []events.OrderLine{{Product: "1", Quantity: 3}}})
+	dispatchTwice(t, r, OrderTopic, events.OrderCanceled{OrderID: "order-1", Reason: "canceled by the user"})
+	dispatchTwice(t, r, SagaTopic, events.ReleaseInventory{OrderID: "order-1", Lines: []events.OrderLine{{Product: "1", Quantity: 3}}, Reason: "canceled by the user"})
+
+	if err := db.NewSelect().Model(inventory).WherePK().Scan(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if inventory.OnHand != 10 || inventory.Reserved != 0 {
+		t.Errorf("expected the stock to be given back once, got %d of %d reserved", inventory.Reserved, inventory.OnHand)
+	}
+
+	reservation := &models.Reservation{}
+
+	if err := db.NewSelect().Model(reservation).Where("order_id = ?", "order-1").Scan(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if reservation.Status != models.ReservationStatusReleased {
+		t.Errorf("expected the reservation to be released, got %s", reservation.Status)
+	}
+}
+
+func TestOrderConfirmedCommitsTheReservation(t *testing.T) {
+	db := database.NewMockDatabase(t, &models.Inventory{}, &models.Reservation{}, &models.OutboxMessage{}, &models.InboxMessage{})
+	logger, _ := zap.NewDevelopment()
+	ctx := context.Background()
+
+	inventory := &models.Inventory{ProductID: "1", OnHand: 10}
+
+	if _, err := db.NewInsert().Model(inventory).Exec(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	r := NewRouter(db, logger)
+
+	dispatchTwice(t, r, SagaTopic, events.ReserveInventory{OrderID: "order-1", Lines: []events.OrderLine{{Product: "1", Quantity: 3}}})
+	dispatchTwice(t, r, OrderTopic, events.OrderConfirmed{OrderID: "order-1"})
+
+	if err := db.NewSelect().Model(inventory).WherePK().Scan(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if inventory.OnHand != 7 || inventory.Reserved != 0 {
+		t.Errorf("expected 7 on hand and nothing reserved once committed, got %d of %d reserved", inventory.Reserved, inventory.OnHand)
+	}
+
+	// A committed order compensated later puts its stock back on hand
+	dispatchTwice(t, r, SagaTopic, events.ReleaseInventory{OrderID: "order-1", Lines: []events.OrderLine{{Product: "1", Quantity: 3}}, Reason: "shipment canceled"})
+
+	if err := db.NewSelect().Model(inventory).WherePK().Scan(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if inventory.OnHand != 10 || inventory.Reserved != 0 {
+		t.Errorf("expected 10 on hand once released, got %d of %d reserved", inventory.Reserved, inventory.OnHand)
+	}
+}
+
+func TestOrderCanceledLeavesCommittedStockToTheCompensation(t *testing.T) {
+	db := database.NewMockDatabase(t, &models.Inventory{}, &models.Reservation{}, &models.OutboxMessage{}, &models.InboxMessage{})
+	logger, _ := zap.NewDevelopment()
+	ctx := context.Background()
+
+	inventory := &models.Inventory{ProductID: "1", OnHand: 10}
+
+	if _, err := db.NewInsert().Model(inventory).Exec(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	r := NewRouter(db, logger)
+
+	dispatchTwice(t, r, SagaTopic, events.ReserveInventory{OrderID: "order-1", Lines: []events.OrderLine{{Product: "1", Quantity: 3}}})
+	dispatchTwice(t, r, OrderTopic, events.OrderConfirmed{OrderID: "order-1"})
+	dispatchTwice(t, r, OrderTopic, events.OrderCanceled{OrderID: "order-1", Reason: "canceled by the user"})
+
+	if err := db.NewSelect().Model(inventory).WherePK().Scan(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if inventory.OnHand != 7 || inventory.Reserved != 0 {
+		t.Errorf("expected the committed stock to stay sold until the shipment is canceled, got %d of %d reserved", inventory.Reserved, inventory.OnHand)
+	}
+
+	dispatchTwice(t, r, SagaTopic, events.ReleaseInventory{OrderID: "order-1", Lines: []events.OrderLine{{Product: "1", Quantity: 3}}, Reason: "canceled by the user"})
+
+	if err := db.NewSelect().Model(inventory).WherePK().Scan(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if inventory.OnHand != 10 || inventory.Reserved != 0 {
+		t.Errorf("expected 10 on hand once the compensation released the order, got %d of %d reserved", inventory.Reserved, inventory.OnHand)
+	}
+}
+
+func TestSweeperReleasesExpiredReservations(t *testing.T) {
+	db := database.NewMockDatabase(t, &models.Inventory{}, &models.Reservation{}, &models.OutboxMessage{}, &models.InboxMessage{})
+	logger, _ := zap.NewDevelopment()
+	ctx := context.Background()
+
+	inventory := &models.Inventory{ProductID: "1", OnHand: 10, Reserved: 5}
+
+	if _, err := db.NewInsert().Model(inventory).Exec(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	reservations := []*models.Reservation{
+		{OrderID: "expired", ProductID: "1", Quantity: 2, Status: models.ReservationStatusReserved, ExpiresAt: time.Now().Add(-time.Minute)},
+		{OrderID: "active", ProductID: "1", Quantity: 3, Status: models.ReservationStatusReserved, ExpiresAt: time.Now().Add(time.Hour)},
+	}
+
+	for _, reservation := range reservations {
+		if _, err := db.NewInsert().Model(reservation).Exec(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	sweeper := NewSweeper(logger, db)
+
+	for i, expected := range []int{1, 0} {
+		released, err := sweeper.Sweep(ctx)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if released != expected {
+			t.Errorf("sweep %d: expected %d released reservations, got %d", i+1, expected, released)
+		}
+	}
+
+	if err := db.NewSelect().Model(inventory).WherePK().Scan(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if inventory.Reserved != 3 {
+		t.Errorf("expected the active reservation to be held, got %d reserved", inventory.Reserved)
+	}
+
+	if err := db.NewSelect().Model(reservations[0]).WherePK().Scan(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if reservations[0].Status != models.ReservationStatusExpired {
+		t.Errorf("expected the reservation to be expired, got %s", reservations[0].Status)
+	}
+}
+
+func TestOrderConfirmedAfterExpiry(t *testing.T) {
+	db := database.NewMockDatabase(t, &models.Inventory{}, &models.Reservation{}, &models.OutboxMessage{}, &models.InboxMessage{})
+	logger, _ := zap.NewDevelopment()
+	ctx := context.Background()
+
+	inventory := &models.Inventory{ProductID: "1", OnHand: 10}
+
+	if _, err := db.NewInsert().Model(inventory).Exec(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	r := NewRouter(db, logger)
+
+	for _, orderID := range []string{"order-1", "order-2"} {
+		dispatchTwice(t, r, SagaTopic, events.ReserveInventory{OrderID: orderID, Lines: []events.OrderLine{{Product: "1", Quantity: 4}}})
+	}
+
+	_, err := db.NewUpdate().Model((*models.Reservation)(nil)).
+		Set("expires_at = ?", time.Now().Add(-time.Minute)).
+		Where("1 = 1").
+		Exec(ctx)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if released, err := NewSweeper(logger, db).Sweep(ctx); err != nil || released != 2 {
+		t.Fatalf("expected both reservations to expire, got %d (%v)", released, err)
+	}
+
+	// The stock left by the first order is taken again when it is confirmed after all
+	dispatchTwice(t, r, OrderTopic, events.OrderConfirmed{OrderID: "order-1"})
+
+	// Another order takes the rest of the stock before the second one is confirmed
+	dispatchTwice(t, r, SagaTopic, events.ReserveInventory{OrderID: "order-3", Lines: []events.OrderLine{{Product: "1", Quantity: 5}}})
+
+	// The confirmation is answered, and not retried until it is dead lettered
+	dispatchTwice(t, r, OrderTopic, events.OrderConfirmed{OrderID: "order-2"})
+
+	var replies []models.OutboxMessage
+
+	if err := db.NewSelect().Model(&replies).Where("topic = ?", InventoryTopic).Order("id").Scan(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	last := replies[len(replies)-1]
+
+	if last.Headers[events.HeaderType] != events.InventoryCommitFailedType || last.Key != "order-2" {
+		t.Fatalf("expected InventoryCommitFailed for order-2 as the last reply, got %s for %s", last.Headers[events.HeaderType], last.Key)
+	}
+
+	_, failed, err := events.Decode[events.InventoryCommitFailed](last.Headers, last.Value)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(failed.Lines) != 1 || failed.Lines[0].Product != "1" || failed.Lines[0].Quantity != 4 {
+		t.Errorf("expected the 4 units of product 1 to fail, got %+v", failed.Lines)
+	}
+
+	if err := db.NewSelect().Model(inventory).WherePK().Scan(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if inventory.OnHand != 6 || inventory.Reserved != 5 {
+		t.Errorf("expected 5 of 6 on hand reserved without overselling, got %d of %d reserved", inventory.Reserved, inventory.OnHand)
+	}
+
+	statuses := map[string]models.ReservationStatus{
+		"order-1": models.ReservationStatusCommitted,
+		"order-2": models.ReservationStatusExpired,
+		"order-3": models.ReservationStatusReserved,
+	}
+
+	for orderID, expected := range statuses {
+		reservation := &models.Reservation{}
+
+		if err := db.NewSelect().Model(reservation).Where("order_id = ?", orderID).Scan(ctx); err != nil {
+			t.Fatal(err)
+		}
+
+		if reservation.Status != expected {
+			t.Errorf("expected the reservation of %s to be %s, got %s", orderID, expected, reservation.Status)
+		}
+	}
+}
+
+// dispatchTwice delivers the event twice, like a redelivery of the broker would
+func dispatchTwice(t *testing.T, r *router.Router, topic string, event events.Event) {
+	t.Helper()
+
+	key := event.(events.OrderAggregate).OrderKey()
+	headers, value, err := events.Encode(events.New(events.SourceOrchestrator, key, event), event)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	message := client.Message{Topic: topic, Key: []byte(key), Value: value, Headers: headers}
+
+	for i := 0; i < 2; i++ {
+		if err := r.Dispatch(context.Background(), message); err != nil {
+			t.Fatalf("%s delivery %d: %v", event.EventType(), i+1, err)
+		}
+	}
+}
+
+func TestReserveInventoryRetriesUnexpectedErrors(t *testing.T) {
+	// Without the reservations table every attempt fails like a transient database error
+	db := database.NewMockDatabase(t, &models.Inventory{}, &models.OutboxMessage{}, &models.InboxMessage{})
+	logger, _ := zap.NewDevelopment()
+	ctx := context.Background()
+
+	if _, err := db.NewInsert().Model(&models.Inventory{ProductID: "1", OnHand: 5}).Exec(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	command := events.ReserveInventory{OrderID: "order-1", Lines: []events.OrderLine{{Product: "1", Quantity: 3}}}
+	envelope := events.New(events.SourceOrchestrator, command.OrderID, command)
+
+	if err := handleReserveInventory(ctx, db, logger, envelope, command); err == nil {
+		t.Fatal("expected the error to be returned for a retry")
+	}
+
+	if replies, err := db.NewSelect().Model((*models.OutboxMessage)(nil)).Count(ctx); err != nil || replies != 0 {
+		t.Fatalf("expected no reply to a failed attempt, got %d (%v)", replies, err)
+	}
+
+	if _, err := db.NewCreateTable().Model((*models.Reservation)(nil)).Exec(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := handleReserveInventory(ctx, db, logger, envelope, command); err != nil {
+		t.Fatalf("expected the retry to reserve the order, got %v", err)
+	}
+
+	var outbox []models.OutboxMessage
+
+	if err := db.NewSelect().Model(&outbox).Scan(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(outbox) != 1 || outbox[0].Headers[events.HeaderType] != events.InventoryReservedType {
+		t.Errorf("expected a single InventoryReserved reply, got %+v", outbox)
+	}
+}
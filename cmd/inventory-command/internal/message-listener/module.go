@@ -3,5 +3,7 @@ package message_listener
 import "go.uber.org/fx"
 
 var Module = fx.Module("message-listener",
+	fx.Provide(NewSweeper),
 	fx.Invoke(StartKafkaListener),
+	fx.Invoke(StartSweeper),
 ) 
\ No newline at end of file
@@ -0,0 +1,176 @@
+package message_listener
+
+import (
+	"context"
+	"os"
+	"saga-pattern/internal/database"
+	"saga-pattern/internal/database/models"
+	"time"
+
+	"github.com/uptrace/bun"
+	"go.uber.org/fx"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultReservationTTL = 15 * time.Minute
+
+	sweeperInterval  = 30 * time.Second
+	sweeperBatchSize = 50
+)
+
+var reservation_ttl = os.Getenv("RESERVATION_TTL")
+
+// ReservationTTL reads from RESERVATION_TTL how long the stock of an order is held before
+// it is released when the order is not confirmed
+func ReservationTTL() time.Duration {
+	if ttl, err := time.ParseDuration(reservation_ttl); err == nil && ttl > 0 {
+		return ttl
+	}
+
+	return defaultReservationTTL
+}
+
+// Sweeper releases the stock of the reservations past their expiry. Every replica runs one,
+// expiring a reservation is a conditional update so its stock is never given back twice.
+type Sweeper struct {
+	db        *bun.DB
+	logger    *zap.Logger
+	interval  time.Duration
+	batchSize int
+}
+
+func NewSweeper(logger *zap.Logger, db *bun.DB) *Sweeper {
+	return &Sweeper{
+		db:        db,
+		logger:    logger,
+		interval:  sweeperInterval,
+		batchSize: sweeperBatchSize,
+	}
+}
+
+// Sweep releases the expired reservations and returns how many were released
+func (s *Sweeper) Sweep(ctx context.Context) (int, error) {
+	var reservations []models.Reservation
+
+	err := s.db.NewSelect().Model(&reservations).
+		Where("status = ?", models.ReservationStatusReserved).
+		Where("expires_at < ?", time.Now()).
+		Order("expires_at").
+		Limit(s.batchSize).
+		Scan(ctx)
+
+	if err != nil {
+		return 0, err
+	}
+
+	released := 0
+
+	for i := range reservations {
+		reservation := &reservations[i]
+
+		err := s.db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+			ok, err := expire(ctx, tx, s.logger, reservation)
+
+			if ok {
+				released++
+			}
+
+			return err
+		})
+
+		if err != nil {
+			if ctx.Err() != nil {
+				return released, ctx.Err()
+			}
+
+			s.logger.Error("Failed to release expired reservation", zap.String("orderID", reservation.OrderID), zap.Error(err))
+		}
+	}
+
+	return released, nil
+}
+
+// expire marks a reserved reservation as expired and gives its stock back to the other
+// orders. It reports false when the reservation was committed or released in between.
+func expire(ctx context.Context, tx bun.Tx, logger *zap.Logger, reservation *models.Reservation) (bool, error) {
+	result, err := tx.NewUpdate().Model((*models.Reservation)(nil)).
+		Set("status = ?", models.ReservationStatusExpired).
+		Set("released_at = ?", time.Now()).
+		Where("id = ?", reservation.ID).
+		Where("status = ?", models.ReservationStatusReserved).
+		Exec(ctx)
+
+	if err != nil {
+		return false, err
+	}
+
+	if affected, err := result.RowsAffected(); err != nil || affected == 0 {
+		return false, err
+	}
+
+	_, err = database.BumpVersion(tx.NewUpdate().Model((*models.Inventory)(nil))).
+		Set("reserved = reserved - ?", reservation.Quantity).
+		Where("product_id = ?", reservation.ProductID).
+		Exec(ctx)
+
+	if err != nil {
+		return false, err
+	}
+
+	logger.Info("Released expired inventory of order",
+		zap.String("orderID", reservation.OrderID),
+		zap.String("product", reservation.ProductID),
+		zap.Int64("quantity", reservation.Quantity))
+
+	reservation.Status = models.ReservationStatusExpired
+
+	return true, nil
+}
+
+// StartSweeper runs the sweeper every interval until the application stops
+func StartSweeper(lc fx.Lifecycle, sweeper *Sweeper) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+
+	lc.Append(fx.Hook{
+		OnStart: func(context.Context) error {
+			go func() {
+				defer close(done)
+
+				ticker := time.NewTicker(sweeper.interval)
+				defer ticker.Stop()
+
+				sweeper.logger.Info("Starting reservation sweeper", zap.Duration("ttl", ReservationTTL()))
+
+				for {
+					select {
+					case <-ctx.Done():
+						return
+					case <-ticker.C:
+						released, err := sweeper.Sweep(ctx)
+
+						if err != nil && ctx.Err() == nil {
+							sweeper.logger.Error("Failed to sweep expired reservations", zap.Error(err))
+						}
+
+						if released > 0 {
+							sweeper.logger.Info("Released expired reservations", zap.Int("count", released))
+						}
+					}
+				}
+			}()
+			return nil
+		},
+		OnStop: func(stopCtx context.Context) error {
+			cancel()
+
+			select {
+			case <-done:
+				return nil
+			case <-stopCtx.Done():
+				return stopCtx.Err()
+			}
+		},
+	})
+}
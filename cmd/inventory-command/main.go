@@ -4,6 +4,7 @@ import (
 	"context"
 	"saga-pattern/cmd/inventory-command/internal/handler"
 	"saga-pattern/cmd/inventory-command/internal/message-listener"
+	"saga-pattern/cmd/inventory-command/migrations"
 	"saga-pattern/internal/client"
 	"saga-pattern/internal/database"
 
@@ -17,7 +18,7 @@ var options = fx.Options(
 	fx.Provide(func() context.Context { return ctx }),
 	fx.Provide(zap.NewExample),
 	client.Module,
-	database.Module,
+	database.Module(migrations.Schema),
 	handler.Module,
 	message_listener.Module,
 )
@@ -0,0 +1,44 @@
+CREATE TABLE IF NOT EXISTS "inventory" (
+	"id" BIGSERIAL NOT NULL,
+	"product_id" VARCHAR,
+	"on_hand" BIGINT NOT NULL DEFAULT 0,
+	"reserved" BIGINT NOT NULL DEFAULT 0,
+	"version" BIGINT NOT NULL DEFAULT 1,
+	PRIMARY KEY ("id")
+);
+
+CREATE TABLE IF NOT EXISTS "reservations" (
+	"id" BIGSERIAL NOT NULL,
+	"order_id" VARCHAR NOT NULL,
+	"product_id" VARCHAR NOT NULL,
+	"quantity" BIGINT,
+	"status" BIGINT,
+	"reserved_at" TIMESTAMPTZ NOT NULL DEFAULT current_timestamp,
+	"expires_at" TIMESTAMPTZ,
+	"committed_at" TIMESTAMPTZ,
+	"released_at" TIMESTAMPTZ,
+	PRIMARY KEY ("id"),
+	CONSTRAINT "reservations_order_product" UNIQUE ("order_id", "product_id")
+);
+
+CREATE TABLE IF NOT EXISTS "outbox" (
+	"id" BIGSERIAL NOT NULL,
+	"topic" VARCHAR,
+	"key" VARCHAR,
+	"value" BYTEA,
+	"headers" JSONB,
+	"status" BIGINT,
+	"attempts" BIGINT,
+	"last_error" VARCHAR,
+	"created_at" TIMESTAMPTZ NOT NULL DEFAULT current_timestamp,
+	"delivered_at" TIMESTAMPTZ,
+	PRIMARY KEY ("id")
+);
+
+CREATE TABLE IF NOT EXISTS "inbox" (
+	"id" BIGSERIAL NOT NULL,
+	"message_key" VARCHAR NOT NULL,
+	"processed_at" TIMESTAMPTZ NOT NULL DEFAULT current_timestamp,
+	PRIMARY KEY ("id"),
+	UNIQUE ("message_key")
+);
@@ -0,0 +1,50 @@
+package migrations
+
+import (
+	"context"
+
+	"github.com/uptrace/bun"
+	"github.com/uptrace/bun/dialect"
+
+	"saga-pattern/internal/database"
+)
+
+func init() {
+	Schema.Migrations.MustRegister(upgradeBaselineInventory, func(ctx context.Context, db *bun.DB) error {
+		// The baseline schema is not restored, the initial schema drops the converted table
+		return nil
+	})
+}
+
+// upgradeBaselineInventory converts the inventory table created before the migrations, which
+// the initial schema leaves as it is: its quantity becomes the stock on hand and it gains the
+// reserved stock and the version. A database created by the migrations is left untouched.
+func upgradeBaselineInventory(ctx context.Context, db *bun.DB) error {
+	baseline, err := database.HasColumn(ctx, db, "inventory", "quantity")
+
+	if err != nil || !baseline {
+		return err
+	}
+
+	queries := []string{
+		`ALTER TABLE "inventory" RENAME COLUMN "quantity" TO "on_hand"`,
+		`UPDATE "inventory" SET "on_hand" = 0 WHERE "on_hand" IS NULL`,
+		`ALTER TABLE "inventory" ADD COLUMN "reserved" BIGINT NOT NULL DEFAULT 0`,
+		`ALTER TABLE "inventory" ADD COLUMN "version" BIGINT NOT NULL DEFAULT 1`,
+	}
+
+	// SQLite cannot change the constraints of an existing column
+	if db.Dialect().Name() == dialect.PG {
+		queries = append(queries, `ALTER TABLE "inventory" ALTER COLUMN "on_hand" SET DEFAULT 0, ALTER COLUMN "on_hand" SET NOT NULL`)
+	}
+
+	return db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		for _, query := range queries {
+			if _, err := tx.ExecContext(ctx, query); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
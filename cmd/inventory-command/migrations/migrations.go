@@ -0,0 +1,20 @@
+package migrations
+
+import (
+	"embed"
+
+	"saga-pattern/internal/database"
+	"saga-pattern/internal/database/models"
+)
+
+//go:embed *.sql
+var files embed.FS
+
+// Schema is the part of the database owned by the inventory service
+var Schema = database.NewSchema(files, "cmd/inventory-command/migrations",
+	(*models.Inventory)(nil),
+	(*models.Reservation)(nil),
+	(*models.OutboxMessage)(nil),
+	(*models.InboxMessage)(nil),
+	(*models.Lease)(nil),
+)
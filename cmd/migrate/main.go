@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/uptrace/bun/migrate"
+	"go.uber.org/zap"
+
+	inventory "saga-pattern/cmd/inventory-command/migrations"
+	orders "saga-pattern/cmd/orders-command/migrations"
+	payment "saga-pattern/cmd/payment-command/migrations"
+	orchestrator "saga-pattern/cmd/saga-orchestrator/migrations"
+	shipping "saga-pattern/cmd/shipping-command/migrations"
+	"saga-pattern/internal/database"
+)
+
+// schemas maps the name of every service to the schema it owns
+var schemas = map[string]database.Schema{
+	"orders":       orders.Schema,
+	"inventory":    inventory.Schema,
+	"payment":      payment.Schema,
+	"shipping":     shipping.Schema,
+	"orchestrator": orchestrator.Schema,
+}
+
+const usage = `usage: migrate <service> <command>
+
+services: orders, inventory, payment, shipping, orchestrator
+
+commands:
+  up             apply every pending migration
+  down           roll back the last group of applied migrations
+  status         list migrations and whether they are applied
+  create <name>  write an empty up and down migration named <name>
+  unlock         release a migration lock left behind by a crashed process`
+
+var errUsage = errors.New(usage)
+
+func main() {
+	log := zap.NewExample()
+
+	if err := run(context.Background(), log, os.Args[1:]); err != nil {
+		if errors.Is(err, errUsage) {
+			fmt.Fprintln(os.Stderr, usage)
+			os.Exit(2)
+		}
+
+		log.Fatal("Migration failed", zap.Error(err))
+	}
+}
+
+func run(ctx context.Context, log *zap.Logger, args []string) error {
+	if len(args) < 2 {
+		return errUsage
+	}
+
+	schema, ok := schemas[args[0]]
+
+	if !ok {
+		return errUsage
+	}
+
+	args = args[1:]
+
+	if args[0] == "create" {
+		if len(args) != 2 {
+			return errUsage
+		}
+
+		return create(ctx, schema, args[1])
+	}
+
+	if len(args) != 1 {
+		return errUsage
+	}
+
+	db, err := database.NewDatabase(log)
+
+	if err != nil {
+		return err
+	}
+
+	defer db.Close()
+
+	migrator := database.NewMigrator(db, schema.Migrations)
+
+	switch args[0] {
+	case "up":
+		return database.Migrate(ctx, db, schema.Migrations, log)
+	case "down":
+		return down(ctx, log, migrator)
+	case "status":
+		return status(ctx, migrator)
+	case "unlock":
+		if err := migrator.Init(ctx); err != nil {
+			return err
+		}
+
+		return migrator.Unlock(ctx)
+	default:
+		return errUsage
+	}
+}
+
+func down(ctx context.Context, log *zap.Logger, migrator *migrate.Migrator) error {
+	if err := database.Lock(ctx, migrator, log); err != nil {
+		return err
+	}
+
+	defer migrator.Unlock(ctx)
+
+	group, err := migrator.Rollback(ctx)
+
+	if err != nil {
+		return fmt.Errorf("failed to roll back migrations: %w", err)
+	}
+
+	if group.IsZero() {
+		log.Info("There are no migrations to roll back")
+		return nil
+	}
+
+	log.Info("Rolled back migrations", zap.Int64("group", group.ID), zap.String("migrations", group.Migrations.String()))
+
+	return nil
+}
+
+func status(ctx context.Context, migrator *migrate.Migrator) error {
+	if err := migrator.Init(ctx); err != nil {
+		return err
+	}
+
+	ms, err := migrator.MigrationsWithStatus(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	for _, m := range ms {
+		state := "pending"
+
+		if m.IsApplied() {
+			state = fmt.Sprintf("applied at %s (group %d)", m.MigratedAt.Format("2006-01-02 15:04:05"), m.GroupID)
+		}
+
+		fmt.Printf("%s_%s\t%s\n", m.Name, m.Comment, state)
+	}
+
+	return nil
+}
+
+func create(ctx context.Context, schema database.Schema, name string) error {
+	name = strings.ToLower(strings.ReplaceAll(name, " ", "_"))
+
+	// Creating files only needs the migrations directory, not a connection
+	files, err := database.NewMigrator(nil, schema.Migrations).CreateTxSQLMigrations(ctx, name)
+
+	if err != nil {
+		return err
+	}
+
+	for _, f := range files {
+		fmt.Println("created", f.Path)
+	}
+
+	return nil
+}
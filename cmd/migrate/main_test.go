@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/uptrace/bun"
+	"go.uber.org/zap"
+
+	"saga-pattern/internal/database"
+	"saga-pattern/internal/database/models"
+)
+
+func TestSchemasMatchTheirModels(t *testing.T) {
+	for name, schema := range schemas {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			db := database.NewMockDatabase(t)
+
+			require.NoError(t, database.Migrate(ctx, db, schema.Migrations, zap.NewNop()))
+			require.NoError(t, schema.Check(ctx, db))
+
+			_, err := database.NewMigrator(db, schema.Migrations).Rollback(ctx)
+			require.NoError(t, err)
+			require.Error(t, schema.Check(ctx, db), "the down steps drop what the up steps created")
+		})
+	}
+}
+
+func TestMigrationsUpgradeTheBaselineSchema(t *testing.T) {
+	// The tables of the baseline were created by the services before the migrations
+	tests := map[string]struct {
+		baseline []string
+		check    func(t *testing.T, db *bun.DB)
+	}{
+		"orders": {
+			baseline: []string{
+				`CREATE TABLE "orders" ("id" INTEGER PRIMARY KEY, "order_id" VARCHAR, "price" DOUBLE PRECISION, "product_id" VARCHAR, "quantity" BIGINT, "status" BIGINT, "user_id" BIGINT)`,
+				`INSERT INTO "orders" ("order_id", "price", "product_id", "quantity", "status", "user_id") VALUES ('order-1', 9.5, '1', 2, 1, 7)`,
+			},
+			check: func(t *testing.T, db *bun.DB) {
+				order := &models.Order{}
+				require.NoError(t, db.NewSelect().Model(order).Relation("Items").Where("o.order_id = ?", "order-1").Scan(context.Background()))
+				require.Equal(t, models.OrderStatusConfirmed, order.Status)
+				require.EqualValues(t, 1, order.Version)
+				require.Equal(t, []models.OrderItem{{ID: order.ID, OrderID: "order-1", ProductID: "1", Quantity: 2, Price: 9.5}}, order.Items)
+			},
+		},
+		"inventory": {
+			baseline: []string{
+				`CREATE TABLE "inventory" ("id" INTEGER PRIMARY KEY, "product_id" VARCHAR, "quantity" BIGINT)`,
+				`INSERT INTO "inventory" ("product_id", "quantity") VALUES ('1', 10)`,
+			},
+			check: func(t *testing.T, db *bun.DB) {
+				inventory := &models.Inventory{}
+				require.NoError(t, db.NewSelect().Model(inventory).Where("product_id = ?", "1").Scan(context.Background()))
+				require.EqualValues(t, 10, inventory.OnHand)
+				require.EqualValues(t, 0, inventory.Reserved)
+				require.EqualValues(t, 1, inventory.Version)
+			},
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			db := database.NewMockDatabase(t)
+
+			for _, query := range tt.baseline {
+				_, err := db.ExecContext(ctx, query)
+				require.NoError(t, err)
+			}
+
+			schema := schemas[name]
+
+			require.NoError(t, database.Migrate(ctx, db, schema.Migrations, zap.NewNop()))
+			require.NoError(t, schema.Check(ctx, db))
+
+			tt.check(t, db)
+		})
+	}
+}
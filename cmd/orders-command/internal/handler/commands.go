@@ -3,30 +3,74 @@ package handler
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
-	"saga-pattern/internal/client"
+	"saga-pattern/internal/database"
 	"saga-pattern/internal/database/models"
+	"saga-pattern/internal/etag"
+	"saga-pattern/internal/events"
 
 	"github.com/google/uuid"
-	"github.com/segmentio/kafka-go"
 	"github.com/uptrace/bun"
 )
 
 const (
 	OrderTopic = "orders"
-	OrderKey   = "OrderCreated"
+
+	defaultCancelReason = "canceled by the user"
 )
 
-type OrderPayload struct {
-	Price    float64 `json:"price"`
+// ErrNotCancelable is returned when canceling an order that is already over or being canceled
+var ErrNotCancelable = errors.New("order cannot be canceled")
+
+type CancelPayload struct {
+	Reason string `json:"reason"`
+}
+
+// ErrInvalidOrder is returned when the lines of a new order cannot be ordered
+var ErrInvalidOrder = errors.New("invalid order")
+
+type OrderLinePayload struct {
 	Product  string  `json:"product"`
 	Quantity int64   `json:"quantity"`
-	UserID   int64   `json:"user_id"`
+	Price    float64 `json:"price"`
+}
+
+type OrderPayload struct {
+	UserID int64              `json:"user_id"`
+	Lines  []OrderLinePayload `json:"lines"`
+}
+
+// validate checks the order has lines, each with a distinct product and a positive quantity
+func (p OrderPayload) validate() error {
+	if len(p.Lines) == 0 {
+		return fmt.Errorf("%w: an order needs at least one line", ErrInvalidOrder)
+	}
+
+	products := make(map[string]bool, len(p.Lines))
+
+	for _, line := range p.Lines {
+		switch {
+		case line.Product == "":
+			return fmt.Errorf("%w: a line has no product", ErrInvalidOrder)
+		case line.Quantity <= 0:
+			return fmt.Errorf("%w: the quantity of product %s must be positive", ErrInvalidOrder, line.Product)
+		case line.Price < 0:
+			return fmt.Errorf("%w: the price of product %s cannot be negative", ErrInvalidOrder, line.Product)
+		case products[line.Product]:
+			return fmt.Errorf("%w: product %s is on several lines", ErrInvalidOrder, line.Product)
+		}
+
+		products[line.Product] = true
+	}
+
+	return nil
 }
 
 func GetOrders(ctx context.Context, db *bun.DB) (*[]models.Order, error) {
 	orders := new([]models.Order)
-	err := db.NewSelect().Model(orders).Limit(20).Scan(ctx)
+	err := db.NewSelect().Model(orders).Relation("Items").Limit(20).Scan(ctx)
 
 	if err != nil {
 		return nil, err
@@ -38,7 +82,7 @@ func GetOrders(ctx context.Context, db *bun.DB) (*[]models.Order, error) {
 func GetOrder(ctx context.Context, db *bun.DB, id string) (*models.Order, error) {
 	order := new(models.Order)
 
-	err := db.NewSelect().Model(order).Where("id = ?", id).Scan(ctx)
+	err := db.NewSelect().Model(order).Relation("Items").Where("id = ?", id).Scan(ctx)
 
 	if err != nil {
 		return nil, err
@@ -47,48 +91,117 @@ func GetOrder(ctx context.Context, db *bun.DB, id string) (*models.Order, error)
 	return order, nil
 }
 
-func CreateOrder(ctx context.Context, db *bun.DB, r *http.Request, api client.API) (*models.Order, error) {
+func CreateOrder(ctx context.Context, db *bun.DB, r *http.Request) (*models.Order, error) {
 	var payload OrderPayload
 
 	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
 		return nil, err
 	}
 
+	if err := payload.validate(); err != nil {
+		return nil, err
+	}
+
 	order := &models.Order{
-		Price:     payload.Price,
-		OrderID:   uuid.New().String(),
-		ProductID: payload.Product,
-		Quantity:  payload.Quantity,
-		UserID:    payload.UserID,
-		Status:    models.OrderStatusPending,
+		OrderID: uuid.New().String(),
+		UserID:  payload.UserID,
+		Status:  models.OrderStatusPending,
+		Version: 1,
 	}
 
-	if _, err := db.NewInsert().Model(order).Exec(ctx); err != nil {
-		return nil, err
+	lines := make([]events.OrderLine, 0, len(payload.Lines))
+
+	for _, line := range payload.Lines {
+		order.Items = append(order.Items, models.OrderItem{
+			OrderID:   order.OrderID,
+			ProductID: line.Product,
+			Quantity:  line.Quantity,
+			Price:     line.Price,
+		})
+
+		lines = append(lines, events.OrderLine{Product: line.Product, Quantity: line.Quantity, Price: line.Price})
 	}
 
-	value := map[string]interface{}{
-		"id":       order.ID,
-		"order_id": order.OrderID,
-		"user_id":  order.UserID,
-		"product":  order.ProductID,
-		"quantity": order.Quantity,
-		"price":    order.Price,
+	// The order, its items and its OrderCreated event are written in the same transaction,
+	// the outbox relay takes care of publishing the event afterwards.
+	err := db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		if _, err := tx.NewInsert().Model(order).Exec(ctx); err != nil {
+			return err
+		}
+
+		if _, err := tx.NewInsert().Model(&order.Items).Exec(ctx); err != nil {
+			return err
+		}
+
+		event := events.OrderCreated{
+			ID:      order.ID,
+			OrderID: order.OrderID,
+			UserID:  order.UserID,
+			Lines:   lines,
+		}
+
+		envelope := events.New(events.SourceOrders, order.OrderID, event)
+
+		return database.EnqueueEvent(ctx, tx, OrderTopic, envelope, event)
+	})
+
+	if err != nil {
+		return nil, err
 	}
 
-	jsonValue, err := json.Marshal(value)
+	return order, nil
+}
+
+// CancelOrder marks a pending or confirmed order as cancelling and publishes OrderCanceled.
+// The request must carry the ETag of the order in If-Match. The order is canceled once the
+// saga compensated every step.
+func CancelOrder(ctx context.Context, db *bun.DB, id string, r *http.Request) (*models.Order, error) {
+	precondition, err := etag.IfMatch(r)
 
 	if err != nil {
 		return nil, err
 	}
 
-	message := kafka.Message{
-		Topic: OrderTopic,
-		Key:   []byte(OrderKey),
-		Value: jsonValue,
+	// The reason is optional, an empty body cancels with the default one
+	var payload CancelPayload
+	_ = json.NewDecoder(r.Body).Decode(&payload)
+
+	if payload.Reason == "" {
+		payload.Reason = defaultCancelReason
 	}
 
-	if err := api.SendMessage(ctx, message); err != nil {
+	order := new(models.Order)
+
+	err = db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		if err := tx.NewSelect().Model(order).Where("id = ?", id).Scan(ctx); err != nil {
+			return err
+		}
+
+		if !precondition.Matches(order.Version) {
+			return database.ErrVersionMismatch
+		}
+
+		if order.Status != models.OrderStatusPending && order.Status != models.OrderStatusConfirmed {
+			return ErrNotCancelable
+		}
+
+		query := tx.NewUpdate().Model(order).Set("status = ?", models.OrderStatusCancelling).WherePK()
+
+		if err := database.UpdateVersion(ctx, query, order.Version); err != nil {
+			return err
+		}
+
+		order.Status = models.OrderStatusCancelling
+		order.Version++
+
+		event := events.OrderCanceled{OrderID: order.OrderID, Reason: payload.Reason}
+
+		envelope := events.New(events.SourceOrders, order.OrderID, event)
+
+		return database.EnqueueEvent(ctx, tx, OrderTopic, envelope, event)
+	})
+
+	if err != nil {
 		return nil, err
 	}
 
@@ -7,6 +7,8 @@ import (
 	"errors"
 	"net/http"
 	"saga-pattern/internal/client"
+	"saga-pattern/internal/database"
+	"saga-pattern/internal/etag"
 
 	"github.com/uptrace/bun"
 	"go.uber.org/fx"
@@ -72,16 +74,24 @@ func NewHandler(logger *zap.Logger, db *bun.DB, ctx context.Context, api client.
 			return
 		}
 
+		etag.Set(w, order.Version)
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusOK)
 		json.NewEncoder(w).Encode(order)
 	})
 
 	mux.HandleFunc("POST /orders", func(w http.ResponseWriter, r *http.Request) {
-		order, err := CreateOrder(r.Context(), db, r, api)
+		order, err := CreateOrder(r.Context(), db, r)
 
 		if err != nil {
 			logger.Error("Failed to create order", zap.Error(err))
+
+			if errors.Is(err, ErrInvalidOrder) {
+				w.WriteHeader(http.StatusBadRequest)
+				json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+				return
+			}
+
 			w.WriteHeader(http.StatusInternalServerError)
 			w.Write([]byte("Failed to create order"))
 			return
@@ -91,6 +101,43 @@ func NewHandler(logger *zap.Logger, db *bun.DB, ctx context.Context, api client.
 		json.NewEncoder(w).Encode(order)
 	})
 
+	mux.HandleFunc("POST /orders/{id}/cancel", func(w http.ResponseWriter, r *http.Request) {
+		id := r.PathValue("id")
+
+		order, err := CancelOrder(r.Context(), db, id, r)
+
+		if err != nil {
+			logger.Error("Failed to cancel order", zap.Error(err), zap.String("id", id))
+
+			switch {
+			case errors.Is(err, sql.ErrNoRows):
+				w.WriteHeader(http.StatusNotFound)
+				json.NewEncoder(w).Encode(map[string]string{"error": "Order not found"})
+			case errors.Is(err, etag.ErrMissing):
+				w.WriteHeader(http.StatusPreconditionRequired)
+				json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+			case errors.Is(err, etag.ErrMalformed):
+				w.WriteHeader(http.StatusBadRequest)
+				json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+			case errors.Is(err, database.ErrVersionMismatch):
+				w.WriteHeader(http.StatusPreconditionFailed)
+				json.NewEncoder(w).Encode(map[string]string{"error": "Order was changed, get it again"})
+			case errors.Is(err, ErrNotCancelable):
+				w.WriteHeader(http.StatusConflict)
+				json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+			default:
+				w.WriteHeader(http.StatusInternalServerError)
+			}
+
+			return
+		}
+
+		etag.Set(w, order.Version)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(order)
+	})
+
 	return mux
 }
 
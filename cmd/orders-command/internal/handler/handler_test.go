@@ -7,19 +7,25 @@ import (
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 
 	"github.com/uptrace/bun"
 	"go.uber.org/zap"
 
+	"saga-pattern/internal/client"
 	"saga-pattern/internal/database"
 	"saga-pattern/internal/database/models"
+	"saga-pattern/internal/etag"
+	"saga-pattern/internal/events"
 )
 
 func setupHandler(t *testing.T) (http.Handler, *bun.DB) {
-	db := database.NewMockDatabase(t, &models.Order{})
+	db := database.NewMockDatabase(t, &models.Order{}, &models.OrderItem{}, &models.OutboxMessage{})
 	logger, _ := zap.NewDevelopment()
-	handler := NewHandler(logger, db, context.Background(), nil)
+	broker := client.NewMemoryBroker(1)
+	api := client.NewAPI(logger, make(client.MessageChan), broker.Subscribe("test", "orders"))
+	handler := NewHandler(logger, db, context.Background(), api)
 	return handler, db
 }
 
@@ -95,11 +101,8 @@ func TestGetListOrdersEndpoint(t *testing.T) {
 			expectedStatus: http.StatusOK,
 			populateDB: func(db *bun.DB) []models.Order {
 				order := &models.Order{
-					Price:     100,
-					OrderID:   "1",
-					ProductID: "1",
-					Quantity:  1,
-					UserID:    1,
+					OrderID: "1",
+					UserID:  1,
 				}
 
 				_, _ = db.NewInsert().Model(order).Returning("*").Exec(context.Background())
@@ -117,11 +120,8 @@ func TestGetListOrdersEndpoint(t *testing.T) {
 
 				for i := 0; i < 10; i++ {
 					order := &models.Order{
-						Price:     float64(i),
-						OrderID:   fmt.Sprintf("%d", i),
-						ProductID: fmt.Sprintf("%d", i),
-						Quantity:  int64(i),
-						UserID:    int64(i),
+						OrderID: fmt.Sprintf("%d", i),
+						UserID:  int64(i),
 					}
 
 					orders = append(orders, *order)
@@ -209,11 +209,8 @@ func TestGetUniqueOrdersEndpoint(t *testing.T) {
 			expectedStatus: http.StatusOK,
 			populateDB: func(db *bun.DB) *models.Order {
 				order := &models.Order{
-					Price:     100,
-					OrderID:   "1",
-					ProductID: "1",
-					Quantity:  1,
-					UserID:    1,
+					OrderID: "1",
+					UserID:  1,
 				}
 
 				_, _ = db.NewInsert().Model(order).Returning("*").Exec(context.Background())
@@ -282,3 +279,255 @@ func TestGetUniqueOrdersEndpoint(t *testing.T) {
 		})
 	}
 }
+
+func TestCancelOrderEndpoint(t *testing.T) {
+	tests := []struct {
+		name           string
+		status         models.OrderStatus
+		endpointURL    string
+		ifMatch        string
+		expectedStatus int
+		expectedOrder  models.OrderStatus
+	}{
+		{
+			name:           "POST request should mark a confirmed order as cancelling",
+			status:         models.OrderStatusConfirmed,
+			endpointURL:    "/orders/1/cancel",
+			ifMatch:        etag.Format(1),
+			expectedStatus: http.StatusAccepted,
+			expectedOrder:  models.OrderStatusCancelling,
+		},
+		{
+			name:           "POST request should return 409 Conflict for a completed order",
+			status:         models.OrderStatusCompleted,
+			endpointURL:    "/orders/1/cancel",
+			ifMatch:        etag.Format(1),
+			expectedStatus: http.StatusConflict,
+			expectedOrder:  models.OrderStatusCompleted,
+		},
+		{
+			name:           "POST request should return 409 Conflict for an order already cancelling",
+			status:         models.OrderStatusCancelling,
+			endpointURL:    "/orders/1/cancel",
+			ifMatch:        etag.Format(1),
+			expectedStatus: http.StatusConflict,
+			expectedOrder:  models.OrderStatusCancelling,
+		},
+		{
+			name:           "POST request should return 404 Not Found when the order does not exist",
+			status:         models.OrderStatusPending,
+			endpointURL:    "/orders/100/cancel",
+			ifMatch:        etag.Format(1),
+			expectedStatus: http.StatusNotFound,
+			expectedOrder:  models.OrderStatusPending,
+		},
+		{
+			name:           "POST request should return 428 Precondition Required without If-Match",
+			status:         models.OrderStatusPending,
+			endpointURL:    "/orders/1/cancel",
+			expectedStatus: http.StatusPreconditionRequired,
+			expectedOrder:  models.OrderStatusPending,
+		},
+		{
+			name:           "POST request should return 412 Precondition Failed for a stale version",
+			status:         models.OrderStatusPending,
+			endpointURL:    "/orders/1/cancel",
+			ifMatch:        etag.Format(2),
+			expectedStatus: http.StatusPreconditionFailed,
+			expectedOrder:  models.OrderStatusPending,
+		},
+		{
+			name:           "POST request should cancel the order at any version with If-Match *",
+			status:         models.OrderStatusPending,
+			endpointURL:    "/orders/1/cancel",
+			ifMatch:        "*",
+			expectedStatus: http.StatusAccepted,
+			expectedOrder:  models.OrderStatusCancelling,
+		},
+		{
+			name:           "POST request should return 404 Not Found for If-Match * when the order does not exist",
+			status:         models.OrderStatusPending,
+			endpointURL:    "/orders/100/cancel",
+			ifMatch:        "*",
+			expectedStatus: http.StatusNotFound,
+			expectedOrder:  models.OrderStatusPending,
+		},
+		{
+			name:           "POST request should cancel the order when one ETag of the If-Match list matches",
+			status:         models.OrderStatusPending,
+			endpointURL:    "/orders/1/cancel",
+			ifMatch:        `"5", W/"1", "1"`,
+			expectedStatus: http.StatusAccepted,
+			expectedOrder:  models.OrderStatusCancelling,
+		},
+		{
+			name:           "POST request should return 412 Precondition Failed for a weak ETag",
+			status:         models.OrderStatusPending,
+			endpointURL:    "/orders/1/cancel",
+			ifMatch:        `W/"1"`,
+			expectedStatus: http.StatusPreconditionFailed,
+			expectedOrder:  models.OrderStatusPending,
+		},
+		{
+			name:           "POST request should return 400 Bad Request for a malformed If-Match",
+			status:         models.OrderStatusPending,
+			endpointURL:    "/orders/1/cancel",
+			ifMatch:        "version-1",
+			expectedStatus: http.StatusBadRequest,
+			expectedOrder:  models.OrderStatusPending,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler, db := setupHandler(t)
+			server := httptest.NewServer(handler)
+			defer server.Close()
+
+			order := &models.Order{OrderID: "order-1", Status: tt.status}
+
+			if _, err := db.NewInsert().Model(order).Exec(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+
+			req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("%s%s", server.URL, tt.endpointURL), nil)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if tt.ifMatch != "" {
+				req.Header.Set("If-Match", tt.ifMatch)
+			}
+
+			resp, err := http.DefaultClient.Do(req)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+
+			if resp.StatusCode != tt.expectedStatus {
+				t.Errorf("expected status %d, got %d", tt.expectedStatus, resp.StatusCode)
+			}
+
+			if err := db.NewSelect().Model(order).WherePK().Scan(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+
+			if tt.expectedStatus == http.StatusAccepted && resp.Header.Get("ETag") != etag.Format(order.Version) {
+				t.Errorf("expected the ETag of version %d, got %s", order.Version, resp.Header.Get("ETag"))
+			}
+
+			if order.Status != tt.expectedOrder {
+				t.Errorf("expected the order to be %s, got %s", tt.expectedOrder, order.Status)
+			}
+
+			count, err := db.NewSelect().Model((*models.OutboxMessage)(nil)).
+				Where("topic = ?", OrderTopic).
+				Count(context.Background())
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if published := tt.expectedStatus == http.StatusAccepted; published != (count == 1) {
+				t.Errorf("expected OrderCanceled to be published: %t, got %d messages", published, count)
+			}
+		})
+	}
+}
+
+func TestCreateOrderEndpoint(t *testing.T) {
+	tests := []struct {
+		name           string
+		body           string
+		expectedStatus int
+		expectedLines  int
+	}{
+		{
+			name:           "POST request should create an order with every line",
+			body:           `{"user_id": 1, "lines": [{"product": "1", "quantity": 2, "price": 10}, {"product": "2", "quantity": 1, "price": 5}]}`,
+			expectedStatus: http.StatusCreated,
+			expectedLines:  2,
+		},
+		{
+			name:           "POST request should return 400 Bad Request without lines",
+			body:           `{"user_id": 1, "lines": []}`,
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "POST request should return 400 Bad Request for a product on several lines",
+			body:           `{"user_id": 1, "lines": [{"product": "1", "quantity": 2}, {"product": "1", "quantity": 1}]}`,
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "POST request should return 400 Bad Request for a line without quantity",
+			body:           `{"user_id": 1, "lines": [{"product": "1", "quantity": 0}]}`,
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler, db := setupHandler(t)
+			server := httptest.NewServer(handler)
+			defer server.Close()
+
+			resp, err := http.Post(server.URL+"/orders", "application/json", strings.NewReader(tt.body))
+
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+
+			if resp.StatusCode != tt.expectedStatus {
+				t.Fatalf("expected status %d, got %d", tt.expectedStatus, resp.StatusCode)
+			}
+
+			var messages []models.OutboxMessage
+
+			if err := db.NewSelect().Model(&messages).Scan(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+
+			if tt.expectedStatus != http.StatusCreated {
+				if len(messages) != 0 {
+					t.Errorf("expected no OrderCreated event, got %d messages", len(messages))
+				}
+
+				return
+			}
+
+			var created models.Order
+
+			if err := json.NewDecoder(resp.Body).Decode(&created); err != nil {
+				t.Fatal(err)
+			}
+
+			order := new(models.Order)
+
+			if err := db.NewSelect().Model(order).Relation("Items").Where("o.id = ?", created.ID).Scan(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+
+			if len(order.Items) != tt.expectedLines {
+				t.Errorf("expected %d items, got %d", tt.expectedLines, len(order.Items))
+			}
+
+			if len(messages) != 1 {
+				t.Fatalf("expected a single OrderCreated event, got %d messages", len(messages))
+			}
+
+			var event events.OrderCreated
+
+			if err := json.Unmarshal(messages[0].Value, &event); err != nil {
+				t.Fatal(err)
+			}
+
+			if len(event.Lines) != tt.expectedLines || event.Total() != 25 {
+				t.Errorf("expected OrderCreated with %d lines worth 25, got %+v", tt.expectedLines, event)
+			}
+		})
+	}
+}
@@ -2,81 +2,201 @@ package message_listener
 
 import (
 	"context"
+	"errors"
 	"saga-pattern/internal/client"
+	"saga-pattern/internal/database"
 	"saga-pattern/internal/database/models"
+	"saga-pattern/internal/events"
+	"saga-pattern/internal/router"
 
 	"github.com/uptrace/bun"
-	"fmt"
 	"go.uber.org/fx"
 	"go.uber.org/zap"
 )
 
 const (
-	OrderRevertedKey = "RevertOrder"
+	RevertOrderType       = events.RevertOrderType
+	PaymentSucceededType  = events.PaymentSucceededType
+	ShipmentDeliveredType = events.ShipmentDeliveredType
+
+	// SagaTopic carries the commands of the saga orchestrator
+	SagaTopic = "saga"
+
+	// OrderTopic receives the replies of the orders service to the saga commands and the
+	// confirmations of the orders
+	OrderTopic = "orders"
 )
 
-type OrderRevertedMessage struct {
-	OrderID string `json:"order_id"`
-}
+// NewRouter registers the handlers of the saga commands and events consumed by the orders service
+func NewRouter(db *bun.DB, logger *zap.Logger) *router.Router {
+	r := router.New(logger, router.WithUnknownPolicy(router.IgnoreUnknown))
+
+	r.Use(
+		router.Logging(logger),
+		router.Retry(client.NewRetrier(logger, db, nil)),
+		router.Deduplicate(db, logger),
+		router.Recover(logger),
+	)
+
+	router.Handle(r, func(ctx context.Context, event events.RevertOrder) error {
+		return handleRevertOrder(ctx, db, logger, router.Envelope(ctx), event)
+	})
+
+	router.Handle(r, func(ctx context.Context, event events.PaymentSucceeded) error {
+		return handlePaymentSucceeded(ctx, db, logger, router.Envelope(ctx), event)
+	})
 
-func StartKafkaListener(lc fx.Lifecycle, db *bun.DB, logger *zap.Logger, api client.API) {
-	lc.Append(fx.Hook{
-		OnStart: func(ctx context.Context) error {
-			go func() {
-				logger.Info("Starting Kafka message listener")
-
-				if err := db.PingContext(ctx); err != nil {
-					logger.Error("Database connection is not healthy", zap.Error(err))
-					return
-				}
-				logger.Info("Database connection verified")
-				
-				for {
-					message, err := api.ReadMessage(ctx)
-					
-					if err != nil {
-						logger.Error("Failed to read message from Kafka", zap.Error(err))
-						continue
-					}
-
-					logger.Info("Received message from Kafka", 
-						zap.String("topic", message.Topic),
-						zap.String("key", string(message.Key)),
-						zap.String("key_hex", fmt.Sprintf("%x", message.Key)),
-						zap.String("value", string(message.Value)))
-
-					key := string(message.Key)
-
-					switch key {
-					case OrderRevertedKey:
-						if err := handleOrderReverted(db, logger, message.Value, api); err != nil {
-							logger.Error("Failed to handle OrderReverted message", zap.Error(err))
-						}
-					default:
-						logger.Warn("Unknown message type", zap.String("key", key))
-					}
-				}
-			}()
-			return nil
-		},
+	router.Handle(r, func(ctx context.Context, event events.ShipmentDelivered) error {
+		return handleShipmentDelivered(ctx, db, logger, event)
 	})
+
+	return r
+}
+
+func StartKafkaListener(lc fx.Lifecycle, db *bun.DB, logger *zap.Logger, api client.API, ctx context.Context) {
+	router.Start(ctx, lc, logger, db, api, NewRouter(db, logger))
 }
 
-func handleOrderReverted(db *bun.DB, logger *zap.Logger, value []byte, api client.API) error {
-	// The value is a plain order_id string, not JSON.
-	orderID := string(value)
+// handleRevertOrder cancels an order that is still pending, confirmed or being canceled. A
+// completed order was delivered, possibly while its cancellation was in flight, so it is
+// left as it is and OrderRevertFailed stops the compensation of the saga.
+func handleRevertOrder(ctx context.Context, db *bun.DB, logger *zap.Logger, envelope events.Envelope, revert events.RevertOrder) error {
+	orderID := revert.OrderID
+
+	err := db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		if err := database.MarkProcessed(ctx, tx, database.InboxKey(orderID, RevertOrderType)); err != nil {
+			return err
+		}
+
+		result, err := database.BumpVersion(tx.NewUpdate().Model(&models.Order{})).
+			Where("order_id = ?", orderID).
+			Where("status IN (?)", bun.In([]models.OrderStatus{models.OrderStatusPending, models.OrderStatusConfirmed, models.OrderStatusCancelling})).
+			Set("status = ?", models.OrderStatusCanceled).
+			Exec(ctx)
+
+		if err != nil {
+			return err
+		}
+
+		reverted, err := result.RowsAffected()
 
-	_, err := db.NewUpdate().Model(&models.Order{}).
-		Where("order_id = ?", orderID).
-		Set("status = ?", models.OrderStatusCanceled).
-		Exec(context.Background())
+		if err != nil {
+			return err
+		}
+
+		var event events.Event = events.OrderReverted{OrderID: orderID}
+
+		if reverted == 0 {
+			completed, err := tx.NewSelect().Model((*models.Order)(nil)).
+				Where("order_id = ?", orderID).
+				Where("status = ?", models.OrderStatusCompleted).
+				Exists(ctx)
+
+			if err != nil {
+				return err
+			}
+
+			if completed {
+				logger.Warn("Order cannot be reverted, it was already completed", zap.String("orderID", orderID))
+
+				event = events.OrderRevertFailed{OrderID: orderID, Reason: "order already completed"}
+			}
+		}
+
+		return database.EnqueueEvent(ctx, tx, OrderTopic, events.NewFrom(envelope, events.SourceOrders, event), event)
+	})
+
+	if errors.Is(err, database.ErrDuplicateMessage) {
+		logger.Info("Skipping duplicate RevertOrder message", zap.String("orderID", orderID))
+		return nil
+	}
 
 	if err != nil {
 		logger.Error("Failed to revert order", zap.Error(err))
 		return err
 	}
 
-	logger.Info("Reverted order", zap.String("orderID", orderID))
+	logger.Info("Reverted order", zap.String("orderID", orderID), zap.String("reason", revert.Reason))
 
 	return nil
-}
\ No newline at end of file
+}
+
+// handlePaymentSucceeded confirms the order once its stock is reserved and its user charged.
+// Only pending orders are confirmed, an order canceled in the meantime stays canceled.
+func handlePaymentSucceeded(ctx context.Context, db *bun.DB, logger *zap.Logger, envelope events.Envelope, paid events.PaymentSucceeded) error {
+	orderID := paid.OrderID
+
+	err := db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		if err := database.MarkProcessed(ctx, tx, database.InboxKey(orderID, PaymentSucceededType)); err != nil {
+			return err
+		}
+
+		result, err := database.BumpVersion(tx.NewUpdate().Model(&models.Order{})).
+			Where("order_id = ?", orderID).
+			Where("status = ?", models.OrderStatusPending).
+			Set("status = ?", models.OrderStatusConfirmed).
+			Exec(ctx)
+
+		if err != nil {
+			return err
+		}
+
+		// Only a confirmation is announced, not a payment of an order already canceled
+		if affected, err := result.RowsAffected(); err != nil || affected == 0 {
+			return err
+		}
+
+		event := events.OrderConfirmed{OrderID: orderID}
+
+		return database.EnqueueEvent(ctx, tx, OrderTopic, events.NewFrom(envelope, events.SourceOrders, event), event)
+	})
+
+	if errors.Is(err, database.ErrDuplicateMessage) {
+		logger.Info("Skipping duplicate PaymentSucceeded message", zap.String("orderID", orderID))
+		return nil
+	}
+
+	if err != nil {
+		logger.Error("Failed to confirm order", zap.Error(err))
+		return err
+	}
+
+	logger.Info("Confirmed order", zap.String("orderID", orderID))
+
+	return nil
+}
+
+// handleShipmentDelivered completes a confirmed order once it reached its user. An order
+// being canceled is completed as well: the saga completed with the delivery before it got
+// the cancellation, so nothing is compensated.
+func handleShipmentDelivered(ctx context.Context, db *bun.DB, logger *zap.Logger, delivered events.ShipmentDelivered) error {
+	orderID := delivered.OrderID
+
+	err := db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		if err := database.MarkProcessed(ctx, tx, database.InboxKey(orderID, ShipmentDeliveredType)); err != nil {
+			return err
+		}
+
+		_, err := database.BumpVersion(tx.NewUpdate().Model(&models.Order{})).
+			Where("order_id = ?", orderID).
+			Where("status IN (?)", bun.In([]models.OrderStatus{models.OrderStatusConfirmed, models.OrderStatusCancelling})).
+			Set("status = ?", models.OrderStatusCompleted).
+			Exec(ctx)
+
+		return err
+	})
+
+	if errors.Is(err, database.ErrDuplicateMessage) {
+		logger.Info("Skipping duplicate ShipmentDelivered message", zap.String("orderID", orderID))
+		return nil
+	}
+
+	if err != nil {
+		logger.Error("Failed to complete order", zap.Error(err))
+		return err
+	}
+
+	logger.Info("Completed order", zap.String("orderID", orderID))
+
+	return nil
+}
@@ -0,0 +1,149 @@
+package message_listener
+
+import (
+	"context"
+	"testing"
+
+	"go.uber.org/zap"
+
+	"saga-pattern/internal/client"
+	"saga-pattern/internal/database"
+	"saga-pattern/internal/database/models"
+	"saga-pattern/internal/events"
+	"saga-pattern/internal/router"
+)
+
+func TestOrderReachesATerminalStatus(t *testing.T) {
+	db := database.NewMockDatabase(t, &models.Order{}, &models.OutboxMessage{}, &models.InboxMessage{})
+	logger, _ := zap.NewDevelopment()
+	ctx := context.Background()
+
+	orders := []*models.Order{
+		{OrderID: "order-1", Status: models.OrderStatusPending},
+		{OrderID: "order-2", Status: models.OrderStatusPending},
+		{OrderID: "order-3", Status: models.OrderStatusPending},
+		{OrderID: "order-4", Status: models.OrderStatusCancelling},
+	}
+
+	for _, order := range orders {
+		if _, err := db.NewInsert().Model(order).Exec(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	paid := events.PaymentSucceeded{OrderID: "order-1", UserID: 1, Amount: 30}
+	revert := events.RevertOrder{OrderID: "order-2", Reason: "insufficient inventory"}
+	confirmed := events.PaymentSucceeded{OrderID: "order-3", UserID: 1, Amount: 30}
+	delivered := events.ShipmentDelivered{OrderID: "order-3"}
+
+	// A cancelling order is canceled by the last compensation of its saga
+	reverted := events.RevertOrder{OrderID: "order-4", Reason: "canceled by the user"}
+
+	r := NewRouter(db, logger)
+
+	for _, event := range []events.Event{paid, revert, confirmed, delivered, reverted} {
+		envelope := events.New(events.SourceOrchestrator, event.(events.OrderAggregate).OrderKey(), event)
+		headers, value, err := events.Encode(envelope, event)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		message := client.Message{Key: []byte(envelope.SagaID), Value: value, Headers: headers}
+
+		// Every message is delivered twice
+		for i := 0; i < 2; i++ {
+			if err := r.Dispatch(ctx, message); err != nil {
+				t.Fatalf("%s delivery %d: %v", event.EventType(), i+1, err)
+			}
+		}
+	}
+
+	expected := []models.OrderStatus{models.OrderStatusConfirmed, models.OrderStatusCanceled, models.OrderStatusCompleted, models.OrderStatusCanceled}
+
+	for i, order := range orders {
+		if err := db.NewSelect().Model(order).WherePK().Scan(ctx); err != nil {
+			t.Fatal(err)
+		}
+
+		if order.Status != expected[i] {
+			t.Errorf("expected %s to have status %d, got %d", order.OrderID, expected[i], order.Status)
+		}
+	}
+
+	var published []models.OutboxMessage
+
+	if err := db.NewSelect().Model(&published).Where("topic = ?", OrderTopic).Scan(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	counts := map[string]int{}
+
+	for _, message := range published {
+		counts[message.Headers[events.HeaderType]]++
+	}
+
+	if counts[events.OrderRevertedType] != 2 {
+		t.Errorf("expected an OrderReverted reply per reverted order, got %d", counts[events.OrderRevertedType])
+	}
+
+	if counts[events.OrderConfirmedType] != 2 {
+		t.Errorf("expected an OrderConfirmed event per confirmed order, got %d", counts[events.OrderConfirmedType])
+	}
+}
+
+func TestOrderDeliveredWhileCancelingIsNotReverted(t *testing.T) {
+	db := database.NewMockDatabase(t, &models.Order{}, &models.OutboxMessage{}, &models.InboxMessage{})
+	logger, _ := zap.NewDevelopment()
+	ctx := context.Background()
+
+	order := &models.Order{OrderID: "order-1", Status: models.OrderStatusCancelling}
+
+	if _, err := db.NewInsert().Model(order).Exec(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	r := NewRouter(db, logger)
+
+	// The shipment is delivered before the compensation of the cancellation reaches the order
+	dispatchTwice(t, r, events.ShipmentDelivered{OrderID: order.OrderID})
+	dispatchTwice(t, r, events.RevertOrder{OrderID: order.OrderID, Reason: "changed my mind"})
+
+	if err := db.NewSelect().Model(order).WherePK().Scan(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if order.Status != models.OrderStatusCompleted {
+		t.Errorf("expected the delivered order to stay completed, got %d", order.Status)
+	}
+
+	var replies []models.OutboxMessage
+
+	if err := db.NewSelect().Model(&replies).Where("topic = ?", OrderTopic).Scan(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(replies) != 1 || replies[0].Headers[events.HeaderType] != events.OrderRevertFailedType {
+		t.Errorf("expected a single OrderRevertFailed reply, got %d replies", len(replies))
+	}
+}
+
+// dispatchTwice delivers the event twice, like a redelivery of the broker would
+func dispatchTwice(t *testing.T, r *router.Router, event events.Event) {
+	t.Helper()
+
+	envelope := events.New(events.SourceOrchestrator, event.(events.OrderAggregate).OrderKey(), event)
+	headers, value, err := events.Encode(envelope, event)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	message := client.Message{Key: []byte(envelope.SagaID), Value: value, Headers: headers}
+
+	for i := 0; i < 2; i++ {
+		if err := r.Dispatch(context.Background(), message); err != nil {
+			t.Fatalf("%s delivery %d: %v", event.EventType(), i+1, err)
+		}
+	}
+}
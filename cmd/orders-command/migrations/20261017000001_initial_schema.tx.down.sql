@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS "inbox";
+DROP TABLE IF EXISTS "outbox";
+DROP TABLE IF EXISTS "order_items";
+DROP TABLE IF EXISTS "orders";
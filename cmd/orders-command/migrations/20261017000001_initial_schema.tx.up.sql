@@ -0,0 +1,40 @@
+CREATE TABLE IF NOT EXISTS "orders" (
+	"id" BIGSERIAL NOT NULL,
+	"order_id" VARCHAR,
+	"status" BIGINT,
+	"user_id" BIGINT,
+	"version" BIGINT NOT NULL DEFAULT 1,
+	PRIMARY KEY ("id")
+);
+
+CREATE TABLE IF NOT EXISTS "order_items" (
+	"id" BIGSERIAL NOT NULL,
+	"order_id" VARCHAR NOT NULL,
+	"product_id" VARCHAR NOT NULL,
+	"quantity" BIGINT,
+	"price" DOUBLE PRECISION,
+	PRIMARY KEY ("id"),
+	CONSTRAINT "order_items_order_product" UNIQUE ("order_id", "product_id")
+);
+
+CREATE TABLE IF NOT EXISTS "outbox" (
+	"id" BIGSERIAL NOT NULL,
+	"topic" VARCHAR,
+	"key" VARCHAR,
+	"value" BYTEA,
+	"headers" JSONB,
+	"status" BIGINT,
+	"attempts" BIGINT,
+	"last_error" VARCHAR,
+	"created_at" TIMESTAMPTZ NOT NULL DEFAULT current_timestamp,
+	"delivered_at" TIMESTAMPTZ,
+	PRIMARY KEY ("id")
+);
+
+CREATE TABLE IF NOT EXISTS "inbox" (
+	"id" BIGSERIAL NOT NULL,
+	"message_key" VARCHAR NOT NULL,
+	"processed_at" TIMESTAMPTZ NOT NULL DEFAULT current_timestamp,
+	PRIMARY KEY ("id"),
+	UNIQUE ("message_key")
+);
@@ -0,0 +1,55 @@
+package migrations
+
+import (
+	"context"
+
+	"github.com/uptrace/bun"
+	"github.com/uptrace/bun/dialect"
+
+	"saga-pattern/internal/database"
+)
+
+func init() {
+	Schema.Migrations.MustRegister(upgradeBaselineOrders, func(ctx context.Context, db *bun.DB) error {
+		// The baseline schema is not restored, the initial schema drops the converted table
+		return nil
+	})
+}
+
+// upgradeBaselineOrders converts the orders table created before the migrations, which the
+// initial schema leaves as it is: the single product of every order moves to its line in
+// order_items and the order gains a version. A database created by the migrations is left
+// untouched.
+func upgradeBaselineOrders(ctx context.Context, db *bun.DB) error {
+	baseline, err := database.HasColumn(ctx, db, "orders", "product_id")
+
+	if err != nil || !baseline {
+		return err
+	}
+
+	// A baseline order has a single line, which keeps the id of the order
+	queries := []string{
+		`INSERT INTO "order_items" ("id", "order_id", "product_id", "quantity", "price")
+			SELECT "id", "order_id", "product_id", "quantity", "price" FROM "orders"
+			WHERE "order_id" IS NOT NULL AND "product_id" IS NOT NULL`,
+		`ALTER TABLE "orders" DROP COLUMN "product_id"`,
+		`ALTER TABLE "orders" DROP COLUMN "quantity"`,
+		`ALTER TABLE "orders" DROP COLUMN "price"`,
+		`ALTER TABLE "orders" ADD COLUMN "version" BIGINT NOT NULL DEFAULT 1`,
+	}
+
+	// The new lines must not reuse the ids taken from the orders
+	if db.Dialect().Name() == dialect.PG {
+		queries = append(queries, `SELECT setval(pg_get_serial_sequence('order_items', 'id'), (SELECT COALESCE(MAX("id"), 0) + 1 FROM "order_items"), false)`)
+	}
+
+	return db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		for _, query := range queries {
+			if _, err := tx.ExecContext(ctx, query); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
@@ -0,0 +1,20 @@
+package migrations
+
+import (
+	"embed"
+
+	"saga-pattern/internal/database"
+	"saga-pattern/internal/database/models"
+)
+
+//go:embed *.sql
+var files embed.FS
+
+// Schema is the part of the database owned by the orders service
+var Schema = database.NewSchema(files, "cmd/orders-command/migrations",
+	(*models.Order)(nil),
+	(*models.OrderItem)(nil),
+	(*models.OutboxMessage)(nil),
+	(*models.InboxMessage)(nil),
+	(*models.Lease)(nil),
+)
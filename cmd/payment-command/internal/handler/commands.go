@@ -0,0 +1,105 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"saga-pattern/internal/database"
+	"saga-pattern/internal/database/models"
+	"saga-pattern/internal/etag"
+
+	"github.com/uptrace/bun"
+)
+
+type AccountPayload struct {
+	UserID  int64   `json:"user_id"`
+	Balance float64 `json:"balance"`
+}
+
+func GetAccounts(ctx context.Context, db *bun.DB) (*[]models.Account, error) {
+	accounts := new([]models.Account)
+	err := db.NewSelect().Model(accounts).Limit(20).Scan(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return accounts, nil
+}
+
+func GetAccount(ctx context.Context, db *bun.DB, userID string) (*models.Account, error) {
+	account := new(models.Account)
+
+	err := db.NewSelect().Model(account).Where("user_id = ?", userID).Scan(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return account, nil
+}
+
+func CreateAccount(ctx context.Context, db *bun.DB, r *http.Request) (*models.Account, error) {
+	var payload AccountPayload
+
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		return nil, err
+	}
+
+	account := &models.Account{
+		UserID:  payload.UserID,
+		Balance: payload.Balance,
+		Version: 1,
+	}
+
+	if _, err := db.NewInsert().Model(account).Exec(ctx); err != nil {
+		return nil, err
+	}
+
+	return account, nil
+}
+
+// UpdateAccount sets the balance of the account of a user. The If-Match header must carry
+// the version the balance was read at, so a correction never erases a charge made since.
+func UpdateAccount(ctx context.Context, db *bun.DB, userID string, r *http.Request) (*models.Account, error) {
+	precondition, err := etag.IfMatch(r)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var payload AccountPayload
+
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		return nil, err
+	}
+
+	account := new(models.Account)
+
+	err = db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		if err := tx.NewSelect().Model(account).Where("user_id = ?", userID).Scan(ctx); err != nil {
+			return err
+		}
+
+		if !precondition.Matches(account.Version) {
+			return database.ErrVersionMismatch
+		}
+
+		query := tx.NewUpdate().Model(account).Set("balance = ?", payload.Balance).WherePK()
+
+		if err := database.UpdateVersion(ctx, query, account.Version); err != nil {
+			return err
+		}
+
+		account.Balance = payload.Balance
+		account.Version++
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return account, nil
+}
@@ -0,0 +1,133 @@
+package handler
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"saga-pattern/internal/database"
+	"saga-pattern/internal/etag"
+
+	"github.com/uptrace/bun"
+	"go.uber.org/fx"
+	"go.uber.org/zap"
+)
+
+func StartServer(lc fx.Lifecycle, db *bun.DB, logger *zap.Logger) {
+	lc.Append(fx.Hook{
+		OnStart: func(ctx context.Context) error {
+			go func() {
+				logger.Info("Starting server on port 8080")
+				if err := http.ListenAndServe(":8080", NewHandler(logger, db)); err != nil {
+					logger.Error("Failed to start server", zap.Error(err))
+				}
+			}()
+			return nil
+		},
+	})
+}
+
+func NewHandler(logger *zap.Logger, db *bun.DB) http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("Payment service is running"))
+	})
+
+	mux.HandleFunc("GET /accounts", func(w http.ResponseWriter, r *http.Request) {
+		accounts, err := GetAccounts(r.Context(), db)
+		if err != nil {
+			logger.Error("Failed to get accounts", zap.Error(err))
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		if len(*accounts) == 0 {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(accounts)
+	})
+
+	mux.HandleFunc("GET /accounts/{userID}", func(w http.ResponseWriter, r *http.Request) {
+		userID := r.PathValue("userID")
+		account, err := GetAccount(r.Context(), db, userID)
+
+		if err != nil {
+			logger.Error("Failed to get account", zap.Error(err), zap.String("userID", userID))
+
+			if errors.Is(err, sql.ErrNoRows) {
+				w.WriteHeader(http.StatusNotFound)
+				json.NewEncoder(w).Encode(map[string]string{"error": "Account not found"})
+				return
+			}
+
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		etag.Set(w, account.Version)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(account)
+	})
+
+	mux.HandleFunc("POST /accounts", func(w http.ResponseWriter, r *http.Request) {
+		account, err := CreateAccount(r.Context(), db, r)
+
+		if err != nil {
+			logger.Error("Failed to create account", zap.Error(err))
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(account)
+	})
+
+	mux.HandleFunc("PUT /accounts/{userID}", func(w http.ResponseWriter, r *http.Request) {
+		userID := r.PathValue("userID")
+		account, err := UpdateAccount(r.Context(), db, userID, r)
+
+		if err != nil {
+			logger.Error("Failed to update account", zap.Error(err), zap.String("userID", userID))
+
+			switch {
+			case errors.Is(err, sql.ErrNoRows):
+				w.WriteHeader(http.StatusNotFound)
+				json.NewEncoder(w).Encode(map[string]string{"error": "Account not found"})
+			case errors.Is(err, etag.ErrMissing):
+				w.WriteHeader(http.StatusPreconditionRequired)
+				json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+			case errors.Is(err, etag.ErrMalformed):
+				w.WriteHeader(http.StatusBadRequest)
+				json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+			case errors.Is(err, database.ErrVersionMismatch):
+				w.WriteHeader(http.StatusPreconditionFailed)
+				json.NewEncoder(w).Encode(map[string]string{"error": "Account was changed, get it again"})
+			default:
+				w.WriteHeader(http.StatusInternalServerError)
+			}
+
+			return
+		}
+
+		etag.Set(w, account.Version)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(account)
+	})
+
+	return mux
+}
+
+var Module = fx.Module("payment-command",
+	fx.Invoke(StartServer),
+)
@@ -0,0 +1,210 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/uptrace/bun"
+	"go.uber.org/zap"
+
+	"saga-pattern/internal/database"
+	"saga-pattern/internal/database/models"
+	"saga-pattern/internal/etag"
+)
+
+func setupHandler(t *testing.T) (http.Handler, *bun.DB) {
+	db := database.NewMockDatabase(t, &models.Account{})
+	logger, _ := zap.NewDevelopment()
+	handler := NewHandler(logger, db)
+	return handler, db
+}
+
+func TestHealthEndpoint(t *testing.T) {
+	handler, _ := setupHandler(t)
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/health")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected status %d, got %d", http.StatusOK, resp.StatusCode)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(body) != "Payment service is running" {
+		t.Errorf("Expected body %q, got %q", "Payment service is running", string(body))
+	}
+}
+
+func TestGetAccountEndpoint(t *testing.T) {
+	tests := []struct {
+		name           string
+		endpointURL    string
+		expectedStatus int
+		populateDB     func(db *bun.DB) *models.Account
+	}{
+		{
+			name:           "GET request should return 200 OK and the account of the user",
+			endpointURL:    "/accounts/7",
+			expectedStatus: http.StatusOK,
+			populateDB: func(db *bun.DB) *models.Account {
+				account := &models.Account{UserID: 7, Balance: 100}
+
+				_, _ = db.NewInsert().Model(account).Returning("*").Exec(context.Background())
+
+				return account
+			},
+		},
+		{
+			name:           "GET request should return 404 Not Found when the user has no account",
+			endpointURL:    "/accounts/100",
+			expectedStatus: http.StatusNotFound,
+			populateDB: func(db *bun.DB) *models.Account {
+				return nil
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler, db := setupHandler(t)
+			server := httptest.NewServer(handler)
+			defer server.Close()
+
+			expectedAccount := tt.populateDB(db)
+
+			resp, err := http.Get(fmt.Sprintf("%s%s", server.URL, tt.endpointURL))
+
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+
+			if resp.StatusCode != tt.expectedStatus {
+				t.Errorf("expected status %d, got %d", tt.expectedStatus, resp.StatusCode)
+			}
+
+			if expectedAccount == nil {
+				return
+			}
+
+			var account models.Account
+
+			if err := json.NewDecoder(resp.Body).Decode(&account); err != nil {
+				t.Fatal(err)
+			}
+
+			if account.ID != expectedAccount.ID || account.Balance != expectedAccount.Balance {
+				t.Errorf("expected account %v, got %v", expectedAccount, account)
+			}
+		})
+	}
+}
+
+func TestUpdateAccountEndpoint(t *testing.T) {
+	handler, db := setupHandler(t)
+	server := httptest.NewServer(handler)
+	defer server.Close()
+	ctx := context.Background()
+
+	account := &models.Account{UserID: 7, Balance: 100, Version: 1}
+
+	if _, err := db.NewInsert().Model(account).Exec(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := http.Get(server.URL + "/accounts/7")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	read := resp.Header.Get("ETag")
+
+	if read != etag.Format(1) {
+		t.Fatalf("expected the ETag of version 1, got %q", read)
+	}
+
+	put := func(ifMatch string, balance float64) *http.Response {
+		req, err := http.NewRequest(http.MethodPut, server.URL+"/accounts/7", strings.NewReader(fmt.Sprintf(`{"balance": %v}`, balance)))
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if ifMatch != "" {
+			req.Header.Set("If-Match", ifMatch)
+		}
+
+		resp, err := http.DefaultClient.Do(req)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+
+		return resp
+	}
+
+	if resp := put("", 250); resp.StatusCode != http.StatusPreconditionRequired {
+		t.Errorf("expected status %d without If-Match, got %d", http.StatusPreconditionRequired, resp.StatusCode)
+	}
+
+	// A charge of the saga bumps the version between the read and the write
+	_, err = database.BumpVersion(db.NewUpdate().Model((*models.Account)(nil))).
+		Set("balance = balance - 30").
+		Where("id = ?", account.ID).
+		Exec(ctx)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if resp := put(read, 250); resp.StatusCode != http.StatusPreconditionFailed {
+		t.Errorf("expected status %d for a stale version, got %d", http.StatusPreconditionFailed, resp.StatusCode)
+	}
+
+	if resp := put("2", 250); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected status %d for a malformed If-Match, got %d", http.StatusBadRequest, resp.StatusCode)
+	}
+
+	if resp := put(`W/"2"`, 250); resp.StatusCode != http.StatusPreconditionFailed {
+		t.Errorf("expected status %d for a weak ETag, got %d", http.StatusPreconditionFailed, resp.StatusCode)
+	}
+
+	resp = put(etag.Format(2), 250)
+
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("ETag") != etag.Format(3) {
+		t.Errorf("expected status %d and the ETag of version 3, got %d and %q", http.StatusOK, resp.StatusCode, resp.Header.Get("ETag"))
+	}
+
+	// If-Match: * overwrites whatever version the account is at
+	resp = put("*", 300)
+
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("ETag") != etag.Format(4) {
+		t.Errorf("expected status %d and the ETag of version 4 for *, got %d and %q", http.StatusOK, resp.StatusCode, resp.Header.Get("ETag"))
+	}
+
+	if err := db.NewSelect().Model(account).WherePK().Scan(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if account.Balance != 300 {
+		t.Errorf("expected balance 300, got %v", account.Balance)
+	}
+}
@@ -0,0 +1,209 @@
+package message_listener
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"saga-pattern/internal/client"
+	"saga-pattern/internal/database"
+	"saga-pattern/internal/database/models"
+	"saga-pattern/internal/events"
+	"saga-pattern/internal/router"
+
+	"github.com/uptrace/bun"
+	"go.uber.org/fx"
+	"go.uber.org/zap"
+)
+
+const (
+	ChargePaymentType = events.ChargePaymentType
+	RefundPaymentType = events.RefundPaymentType
+
+	// SagaTopic carries the commands of the saga orchestrator
+	SagaTopic = "saga"
+
+	// PaymentTopic receives the replies of the payment service to the saga commands
+	PaymentTopic = "payment"
+)
+
+// NewRouter registers the handlers of the saga commands consumed by the payment service
+func NewRouter(db *bun.DB, logger *zap.Logger) *router.Router {
+	r := router.New(logger, router.WithUnknownPolicy(router.IgnoreUnknown))
+
+	r.Use(
+		router.Logging(logger),
+		router.Retry(client.NewRetrier(logger, db, nil)),
+		router.Deduplicate(db, logger),
+		router.Recover(logger),
+	)
+
+	router.Handle(r, func(ctx context.Context, command events.ChargePayment) error {
+		return handleChargePayment(ctx, db, logger, router.Envelope(ctx), command)
+	})
+
+	router.Handle(r, func(ctx context.Context, command events.RefundPayment) error {
+		return handleRefundPayment(ctx, db, logger, router.Envelope(ctx), command)
+	})
+
+	return r
+}
+
+func StartKafkaListener(lc fx.Lifecycle, db *bun.DB, logger *zap.Logger, api client.API, ctx context.Context) {
+	router.Start(ctx, lc, logger, db, api, NewRouter(db, logger))
+}
+
+func handleChargePayment(ctx context.Context, db *bun.DB, logger *zap.Logger, envelope events.Envelope, command events.ChargePayment) error {
+	logger.Info("Processing ChargePayment command",
+		zap.String("orderID", command.OrderID),
+		zap.Int64("userID", command.UserID),
+		zap.Float64("amount", command.Amount))
+
+	account := &models.Account{}
+
+	inboxKey := database.InboxKey(command.OrderID, ChargePaymentType)
+
+	err := db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		if err := database.MarkProcessed(ctx, tx, inboxKey); err != nil {
+			return err
+		}
+
+		// The check and the charge are a single statement, so parallel charges of the same
+		// user never take the balance below zero
+		res, err := database.BumpVersion(tx.NewUpdate().Model((*models.Account)(nil))).
+			Set("balance = balance - ?", command.Amount).
+			Where("user_id = ?", command.UserID).
+			Where("balance >= ?", command.Amount).
+			Exec(ctx)
+
+		if err != nil {
+			return err
+		}
+
+		charged, err := res.RowsAffected()
+
+		if err != nil {
+			return err
+		}
+
+		err = tx.NewSelect().Model(account).Where("user_id = ?", command.UserID).Scan(ctx)
+
+		if errors.Is(err, sql.ErrNoRows) {
+			logger.Warn("Rejecting payment, user has no account", zap.Int64("userID", command.UserID))
+
+			return enqueuePaymentFailed(ctx, tx, envelope, command, "account not found")
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if charged == 0 {
+			logger.Warn("Rejecting payment, insufficient balance for order",
+				zap.String("orderID", command.OrderID),
+				zap.Int64("userID", command.UserID),
+				zap.Float64("amount", command.Amount),
+				zap.Float64("balance", account.Balance))
+
+			return enqueuePaymentFailed(ctx, tx, envelope, command, "insufficient balance")
+		}
+
+		payment := &models.Payment{
+			OrderID: command.OrderID,
+			UserID:  command.UserID,
+			Amount:  command.Amount,
+			Status:  models.PaymentStatusCharged,
+		}
+
+		if _, err := tx.NewInsert().Model(payment).Exec(ctx); err != nil {
+			return err
+		}
+
+		event := events.PaymentSucceeded{OrderID: command.OrderID, UserID: command.UserID, Amount: command.Amount}
+
+		return database.EnqueueEvent(ctx, tx, PaymentTopic, events.NewFrom(envelope, events.SourcePayment, event), event)
+	})
+
+	if errors.Is(err, database.ErrDuplicateMessage) {
+		logger.Info("Skipping duplicate ChargePayment command", zap.String("orderID", command.OrderID))
+		return nil
+	}
+
+	// Unexpected errors are retried and dead lettered, only a refused charge is answered
+	if err != nil {
+		logger.Error("Failed to charge payment", zap.String("orderID", command.OrderID), zap.Error(err))
+		return err
+	}
+
+	logger.Info("Successfully charged payment for order",
+		zap.String("orderID", command.OrderID),
+		zap.Int64("userID", command.UserID),
+		zap.Float64("amount", command.Amount),
+		zap.Float64("balance", account.Balance))
+
+	return nil
+}
+
+// handleRefundPayment gives back the charge of the order. An order that was never charged,
+// or already refunded, is answered right away so the compensation can go on.
+func handleRefundPayment(ctx context.Context, db *bun.DB, logger *zap.Logger, envelope events.Envelope, command events.RefundPayment) error {
+	logger.Info("Processing RefundPayment command",
+		zap.String("orderID", command.OrderID),
+		zap.String("reason", command.Reason))
+
+	err := db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		if err := database.MarkProcessed(ctx, tx, database.InboxKey(command.OrderID, RefundPaymentType)); err != nil {
+			return err
+		}
+
+		event := events.PaymentRefunded{OrderID: command.OrderID, UserID: command.UserID}
+
+		payment := &models.Payment{}
+
+		err := tx.NewSelect().Model(payment).Where("order_id = ?", command.OrderID).Scan(ctx)
+
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			logger.Info("Nothing to refund, the order was not charged", zap.String("orderID", command.OrderID))
+
+		case err != nil:
+			return err
+
+		case payment.Status == models.PaymentStatusRefunded:
+			logger.Info("Nothing to refund, the order was already refunded", zap.String("orderID", command.OrderID))
+
+		default:
+			_, err := database.BumpVersion(tx.NewUpdate().Model((*models.Account)(nil))).
+				Set("balance = balance + ?", payment.Amount).
+				Where("user_id = ?", payment.UserID).
+				Exec(ctx)
+
+			if err != nil {
+				return err
+			}
+
+			payment.Status = models.PaymentStatusRefunded
+
+			if _, err := tx.NewUpdate().Model(payment).Column("status").WherePK().Exec(ctx); err != nil {
+				return err
+			}
+
+			event.Amount = payment.Amount
+		}
+
+		return database.EnqueueEvent(ctx, tx, PaymentTopic, events.NewFrom(envelope, events.SourcePayment, event), event)
+	})
+
+	if errors.Is(err, database.ErrDuplicateMessage) {
+		logger.Info("Skipping duplicate RefundPayment command", zap.String("orderID", command.OrderID))
+		return nil
+	}
+
+	return err
+}
+
+// enqueuePaymentFailed writes the PaymentFailed reply to command to the outbox
+func enqueuePaymentFailed(ctx context.Context, db bun.IDB, cause events.Envelope, command events.ChargePayment, reason string) error {
+	event := events.PaymentFailed{OrderID: command.OrderID, UserID: command.UserID, Reason: reason}
+
+	return database.EnqueueEvent(ctx, db, PaymentTopic, events.NewFrom(cause, events.SourcePayment, event), event)
+}
@@ -0,0 +1,187 @@
+package message_listener
+
+import (
+	"context"
+	"testing"
+
+	"github.com/uptrace/bun"
+	"go.uber.org/zap"
+
+	"saga-pattern/internal/client"
+	"saga-pattern/internal/database"
+	"saga-pattern/internal/database/models"
+	"saga-pattern/internal/events"
+	"saga-pattern/internal/router"
+)
+
+func setupRouter(t *testing.T, balance float64) (*bun.DB, *router.Router) {
+	db := database.NewMockDatabase(t, &models.Account{}, &models.Payment{}, &models.OutboxMessage{}, &models.InboxMessage{})
+	logger, _ := zap.NewDevelopment()
+
+	if _, err := db.NewInsert().Model(&models.Account{UserID: 1, Balance: balance}).Exec(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	return db, NewRouter(db, logger)
+}
+
+func dispatch(t *testing.T, r *router.Router, orderID string, command events.Event) {
+	t.Helper()
+
+	headers, value, err := events.Encode(events.New(events.SourceOrchestrator, orderID, command), command)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := r.Dispatch(context.Background(), client.Message{Topic: SagaTopic, Key: []byte(orderID), Value: value, Headers: headers}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func balance(t *testing.T, db *bun.DB) float64 {
+	t.Helper()
+
+	account := &models.Account{}
+
+	if err := db.NewSelect().Model(account).Where("user_id = ?", 1).Scan(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	return account.Balance
+}
+
+func replies(t *testing.T, db *bun.DB) []string {
+	t.Helper()
+
+	var messages []models.OutboxMessage
+
+	if err := db.NewSelect().Model(&messages).Order("id").Scan(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	types := make([]string, len(messages))
+
+	for i, message := range messages {
+		if message.Topic != PaymentTopic {
+			t.Errorf("expected the replies on %s, got %s", PaymentTopic, message.Topic)
+		}
+
+		types[i] = message.Headers[events.HeaderType]
+	}
+
+	return types
+}
+
+func TestHandleChargePaymentIsIdempotent(t *testing.T) {
+	db, r := setupRouter(t, 100)
+
+	command := events.ChargePayment{OrderID: "order-1", UserID: 1, Amount: 30}
+
+	dispatch(t, r, command.OrderID, command)
+	dispatch(t, r, command.OrderID, command)
+
+	if got := balance(t, db); got != 70 {
+		t.Errorf("expected balance 70 after a duplicate delivery, got %v", got)
+	}
+
+	account := &models.Account{}
+
+	if err := db.NewSelect().Model(account).Where("user_id = ?", 1).Scan(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if account.Version != 2 {
+		t.Errorf("expected the charge to bump the version once, got version %d", account.Version)
+	}
+
+	if sent := replies(t, db); len(sent) != 1 || sent[0] != events.PaymentSucceededType {
+		t.Errorf("expected a single PaymentSucceeded, got %v", sent)
+	}
+}
+
+func TestHandleChargePaymentFailsOnInsufficientBalance(t *testing.T) {
+	db, r := setupRouter(t, 50)
+
+	dispatch(t, r, "order-1", events.ChargePayment{OrderID: "order-1", UserID: 1, Amount: 30})
+	dispatch(t, r, "order-2", events.ChargePayment{OrderID: "order-2", UserID: 1, Amount: 30})
+	dispatch(t, r, "order-3", events.ChargePayment{OrderID: "order-3", UserID: 2, Amount: 10})
+
+	if got := balance(t, db); got != 20 {
+		t.Errorf("expected balance 20, got %v", got)
+	}
+
+	expected := []string{events.PaymentSucceededType, events.PaymentFailedType, events.PaymentFailedType}
+
+	sent := replies(t, db)
+
+	if len(sent) != len(expected) {
+		t.Fatalf("expected replies %v, got %v", expected, sent)
+	}
+
+	for i := range expected {
+		if sent[i] != expected[i] {
+			t.Errorf("expected replies %v, got %v", expected, sent)
+			break
+		}
+	}
+}
+
+func TestHandleChargePaymentRetriesDatabaseErrors(t *testing.T) {
+	// Without the payments table the charge fails like on a transient database error
+	db := database.NewMockDatabase(t, &models.Account{}, &models.OutboxMessage{}, &models.InboxMessage{})
+	logger, _ := zap.NewDevelopment()
+	ctx := context.Background()
+
+	if _, err := db.NewInsert().Model(&models.Account{UserID: 1, Balance: 100}).Exec(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	command := events.ChargePayment{OrderID: "order-1", UserID: 1, Amount: 30}
+
+	if err := handleChargePayment(ctx, db, logger, events.New(events.SourceOrchestrator, command.OrderID, command), command); err == nil {
+		t.Fatal("expected the error to be returned for a retry")
+	}
+
+	if got := balance(t, db); got != 100 {
+		t.Errorf("expected the charge to be rolled back, got balance %v", got)
+	}
+
+	if sent := replies(t, db); len(sent) != 0 {
+		t.Errorf("expected no reply to a failed attempt, got %v", sent)
+	}
+}
+
+func TestHandleRefundPaymentRefundsOnce(t *testing.T) {
+	db, r := setupRouter(t, 100)
+
+	dispatch(t, r, "order-1", events.ChargePayment{OrderID: "order-1", UserID: 1, Amount: 30})
+
+	refund := events.RefundPayment{OrderID: "order-1", UserID: 1, Reason: "shipping failed"}
+
+	dispatch(t, r, refund.OrderID, refund)
+	dispatch(t, r, refund.OrderID, refund)
+
+	// An order that was never charged is answered without touching the balance
+	dispatch(t, r, "order-2", events.RefundPayment{OrderID: "order-2", UserID: 1})
+
+	if got := balance(t, db); got != 100 {
+		t.Errorf("expected the balance to be refunded once, got %v", got)
+	}
+
+	payment := &models.Payment{}
+
+	if err := db.NewSelect().Model(payment).Where("order_id = ?", "order-1").Scan(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if payment.Status != models.PaymentStatusRefunded {
+		t.Errorf("expected the payment to be refunded, got %s", payment.Status)
+	}
+
+	expected := []string{events.PaymentSucceededType, events.PaymentRefundedType, events.PaymentRefundedType}
+
+	if sent := replies(t, db); len(sent) != len(expected) || sent[1] != expected[1] || sent[2] != expected[2] {
+		t.Errorf("expected replies %v, got %v", expected, sent)
+	}
+}
@@ -0,0 +1,90 @@
+package handler
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/uptrace/bun"
+	"go.uber.org/fx"
+	"go.uber.org/zap"
+)
+
+func StartServer(lc fx.Lifecycle, db *bun.DB, logger *zap.Logger) {
+	lc.Append(fx.Hook{
+		OnStart: func(ctx context.Context) error {
+			go func() {
+				logger.Info("Starting server on port 8080")
+				if err := http.ListenAndServe(":8080", NewHandler(logger, db)); err != nil {
+					logger.Error("Failed to start server", zap.Error(err))
+				}
+			}()
+			return nil
+		},
+	})
+}
+
+func NewHandler(logger *zap.Logger, db *bun.DB) http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("Saga orchestrator is running"))
+	})
+
+	mux.HandleFunc("GET /sagas", func(w http.ResponseWriter, r *http.Request) {
+		sagas, err := GetSagas(r.Context(), db, r.URL.Query().Get("status"))
+
+		if err != nil {
+			logger.Error("Failed to get sagas", zap.Error(err))
+			if errors.Is(err, ErrUnknownStatus) {
+				w.WriteHeader(http.StatusBadRequest)
+				json.NewEncoder(w).Encode(map[string]string{"error": "Unknown saga status"})
+				return
+			}
+
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("Failed to get sagas"))
+			return
+		}
+
+		if len(*sagas) == 0 {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(sagas)
+	})
+
+	mux.HandleFunc("GET /sagas/{id}", func(w http.ResponseWriter, r *http.Request) {
+		id := r.PathValue("id")
+
+		saga, err := GetSaga(r.Context(), db, id)
+
+		if err != nil {
+			logger.Error("Failed to get saga", zap.Error(err), zap.String("id", id))
+			if errors.Is(err, sql.ErrNoRows) {
+				w.WriteHeader(http.StatusNotFound)
+				json.NewEncoder(w).Encode(map[string]string{"error": "Saga not found"})
+				return
+			}
+
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(saga)
+	})
+
+	return mux
+}
+
+var Module = fx.Module("saga-orchestrator",
+	fx.Invoke(StartServer),
+)
@@ -0,0 +1,160 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/uptrace/bun"
+	"go.uber.org/zap"
+
+	"saga-pattern/internal/database"
+	"saga-pattern/internal/database/models"
+)
+
+func setupHandler(t *testing.T) (http.Handler, *bun.DB) {
+	db := database.NewMockDatabase(t, &models.SagaInstance{}, &models.SagaStep{})
+	logger, _ := zap.NewDevelopment()
+	return NewHandler(logger, db), db
+}
+
+func insertSagas(t *testing.T, db *bun.DB) {
+	ctx := context.Background()
+	now := time.Now()
+
+	sagas := []*models.SagaInstance{
+		{SagaID: "stuck", Status: models.SagaStatusRunning, CurrentStep: 1, Payload: []byte(`{}`), UpdatedAt: now.Add(-time.Hour)},
+		{SagaID: "running", Status: models.SagaStatusRunning, CurrentStep: 1, Payload: []byte(`{}`), UpdatedAt: now},
+		{SagaID: "shipping", Status: models.SagaStatusRunning, CurrentStep: 3, Payload: []byte(`{}`), UpdatedAt: now.Add(-time.Hour)},
+		{SagaID: "completed", Status: models.SagaStatusCompleted, CurrentStep: 1, Payload: []byte(`{}`), UpdatedAt: now.Add(-time.Hour)},
+	}
+
+	for _, saga := range sagas {
+		if _, err := db.NewInsert().Model(saga).Exec(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	steps := []*models.SagaStep{
+		{SagaID: "stuck", Name: "create_order", Position: 0, Status: models.SagaStepStatusSucceeded, Reply: "OrderCreated", ReplyPayload: []byte(`{"order_id":"stuck"}`)},
+		{SagaID: "stuck", Name: "reserve_inventory", Position: 1, Status: models.SagaStepStatusPending, Command: "ReserveInventory", Payload: []byte(`{"order_id":"stuck"}`), Attempts: 1},
+		{SagaID: "shipping", Name: "ship_order", Position: 3, Status: models.SagaStepStatusPending, Command: "CreateShipment", Payload: []byte(`{"order_id":"shipping"}`), Attempts: 1, Deadline: now.Add(time.Hour)},
+	}
+
+	for _, step := range steps {
+		if _, err := db.NewInsert().Model(step).Exec(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestGetSaga(t *testing.T) {
+	handler, db := setupHandler(t)
+	insertSagas(t, db)
+
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/sagas/stuck")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, resp.StatusCode)
+	}
+
+	var saga models.SagaInstance
+	if err := json.NewDecoder(resp.Body).Decode(&saga); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(saga.Steps) != 2 || saga.Steps[0].Name != "create_order" || saga.Steps[1].Command != "ReserveInventory" {
+		t.Errorf("Expected the history of the saga, got %+v", saga.Steps)
+	}
+
+	resp, err = http.Get(server.URL + "/sagas/unknown")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected status %d, got %d", http.StatusNotFound, resp.StatusCode)
+	}
+}
+
+func TestGetSagasByStatus(t *testing.T) {
+	handler, db := setupHandler(t)
+	insertSagas(t, db)
+
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	tests := []struct {
+		name           string
+		status         string
+		expectedStatus int
+		expectedSagas  []string
+	}{
+		{
+			name:           "Stuck sagas are running without progress, sagas waiting within a deadline are not",
+			status:         "stuck",
+			expectedStatus: http.StatusOK,
+			expectedSagas:  []string{"stuck"},
+		},
+		{
+			name:           "Filter by saga status",
+			status:         "running",
+			expectedStatus: http.StatusOK,
+			expectedSagas:  []string{"shipping", "running", "stuck"},
+		},
+		{
+			name:           "No saga with the status",
+			status:         "compensated",
+			expectedStatus: http.StatusNoContent,
+		},
+		{
+			name:           "Unknown status",
+			status:         "lost",
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := http.Get(server.URL + "/sagas?status=" + tt.status)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+
+			if resp.StatusCode != tt.expectedStatus {
+				t.Fatalf("Expected status %d, got %d", tt.expectedStatus, resp.StatusCode)
+			}
+
+			if tt.expectedStatus != http.StatusOK {
+				return
+			}
+
+			var sagas []models.SagaInstance
+			if err := json.NewDecoder(resp.Body).Decode(&sagas); err != nil {
+				t.Fatal(err)
+			}
+
+			if len(sagas) != len(tt.expectedSagas) {
+				t.Fatalf("Expected %d sagas, got %d", len(tt.expectedSagas), len(sagas))
+			}
+
+			for i, saga := range sagas {
+				if saga.SagaID != tt.expectedSagas[i] {
+					t.Errorf("Expected saga %s, got %s", tt.expectedSagas[i], saga.SagaID)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,101 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/uptrace/bun"
+
+	"saga-pattern/internal/database/models"
+)
+
+// StatusStuck selects the running or compensating sagas without progress for StuckAfter.
+// A saga whose pending step is still within its deadline, like a shipment on its way, is
+// waiting rather than stuck.
+const StatusStuck = "stuck"
+
+const defaultStuckAfter = 5 * time.Minute
+
+var ErrUnknownStatus = errors.New("unknown saga status")
+
+var saga_stuck_after = os.Getenv("SAGA_STUCK_AFTER")
+
+// StuckAfter reads from SAGA_STUCK_AFTER how long a saga can wait for a reply before it
+// is reported as stuck
+func StuckAfter() time.Duration {
+	if stuckAfter, err := time.ParseDuration(saga_stuck_after); err == nil && stuckAfter > 0 {
+		return stuckAfter
+	}
+
+	return defaultStuckAfter
+}
+
+// GetSagas returns the latest sagas, filtered by status when it is not empty
+func GetSagas(ctx context.Context, db *bun.DB, status string) (*[]models.SagaInstance, error) {
+	sagas := new([]models.SagaInstance)
+	query := db.NewSelect().Model(sagas).Order("id DESC").Limit(20)
+
+	switch {
+	case status == "":
+	case strings.EqualFold(status, StatusStuck):
+		query = query.
+			Where("status IN (?)", bun.In([]models.SagaStatus{models.SagaStatusRunning, models.SagaStatusCompensating})).
+			Where("updated_at < ?", time.Now().Add(-StuckAfter())).
+			Where("NOT EXISTS (?)", db.NewSelect().Model((*models.SagaStep)(nil)).
+				ColumnExpr("1").
+				Where("ss.saga_id = si.saga_id").
+				Where("ss.status = ?", models.SagaStepStatusPending).
+				Where("ss.deadline > ?", time.Now()))
+	default:
+		sagaStatus, err := parseStatus(status)
+
+		if err != nil {
+			return nil, err
+		}
+
+		query = query.Where("status = ?", sagaStatus)
+	}
+
+	if err := query.Scan(ctx); err != nil {
+		return nil, err
+	}
+
+	return sagas, nil
+}
+
+// GetSaga returns a saga with its step history
+func GetSaga(ctx context.Context, db *bun.DB, sagaID string) (*models.SagaInstance, error) {
+	saga := new(models.SagaInstance)
+
+	err := db.NewSelect().Model(saga).
+		Relation("Steps", func(q *bun.SelectQuery) *bun.SelectQuery {
+			return q.Order("ss.id")
+		}).
+		Where("si.saga_id = ?", sagaID).
+		Scan(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return saga, nil
+}
+
+func parseStatus(status string) (models.SagaStatus, error) {
+	for _, sagaStatus := range []models.SagaStatus{
+		models.SagaStatusRunning,
+		models.SagaStatusCompensating,
+		models.SagaStatusCompleted,
+		models.SagaStatusCompensated,
+		models.SagaStatusFailed,
+	} {
+		if strings.EqualFold(status, sagaStatus.String()) {
+			return sagaStatus, nil
+		}
+	}
+
+	return 0, ErrUnknownStatus
+}
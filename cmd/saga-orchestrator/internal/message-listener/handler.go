@@ -0,0 +1,17 @@
+package message_listener
+
+import (
+	"context"
+	"saga-pattern/cmd/saga-orchestrator/internal/orchestrator"
+	"saga-pattern/internal/client"
+	"saga-pattern/internal/router"
+
+	"github.com/uptrace/bun"
+	"go.uber.org/fx"
+	"go.uber.org/zap"
+)
+
+// StartKafkaListener runs the order saga on the events of the orders and inventory services
+func StartKafkaListener(lc fx.Lifecycle, db *bun.DB, logger *zap.Logger, api client.API, engine *orchestrator.Engine, ctx context.Context) {
+	router.Start(ctx, lc, logger, db, api, engine.Router())
+}
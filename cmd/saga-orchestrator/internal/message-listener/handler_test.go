@@ -0,0 +1,439 @@
+package message_listener
+
+import (
+	"context"
+	"testing"
+
+	"github.com/uptrace/bun"
+	"go.uber.org/zap"
+
+	"saga-pattern/cmd/saga-orchestrator/internal/orchestrator"
+	"saga-pattern/internal/client"
+	"saga-pattern/internal/database"
+	"saga-pattern/internal/database/models"
+	"saga-pattern/internal/events"
+	"saga-pattern/internal/router"
+	"saga-pattern/pkg/saga"
+)
+
+func setupRouter(t *testing.T) (*bun.DB, *router.Router) {
+	db := database.NewMockDatabase(t, &models.SagaInstance{}, &models.SagaStep{}, &models.OutboxMessage{}, &models.InboxMessage{})
+	logger, _ := zap.NewDevelopment()
+
+	return db, orchestrator.NewEngine(logger, db).Router()
+}
+
+func dispatch(t *testing.T, r *router.Router, envelope events.Envelope, event events.Event) {
+	t.Helper()
+
+	headers, value, err := events.Encode(envelope, event)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	message := client.Message{Key: []byte(envelope.SagaID), Value: value, Headers: headers}
+
+	if err := r.Dispatch(context.Background(), message); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// commands returns the types of the commands sent by the orchestrator, in order
+func commands(t *testing.T, db *bun.DB) []string {
+	t.Helper()
+
+	var messages []models.OutboxMessage
+
+	if err := db.NewSelect().Model(&messages).Where("topic = ?", saga.DefaultTopic).Order("id").Scan(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	types := make([]string, len(messages))
+
+	for i, message := range messages {
+		types[i] = message.Headers[events.HeaderType]
+	}
+
+	return types
+}
+
+func sagaInstance(t *testing.T, db *bun.DB, sagaID string) *models.SagaInstance {
+	t.Helper()
+
+	instance := &models.SagaInstance{}
+
+	if err := db.NewSelect().Model(instance).Where("saga_id = ?", sagaID).Scan(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	return instance
+}
+
+func TestOrderSagaCompletes(t *testing.T) {
+	db, r := setupRouter(t)
+
+	order := events.OrderCreated{OrderID: "order-1", UserID: 1, Lines: []events.OrderLine{{Product: "1", Quantity: 2, Price: 10}, {Product: "2", Quantity: 1, Price: 10}}}
+	created := events.New(events.SourceOrders, order.OrderID, order)
+
+	// OrderCreated is delivered twice, the saga starts once
+	dispatch(t, r, created, order)
+	dispatch(t, r, created, order)
+
+	reserved := events.InventoryReserved{OrderID: order.OrderID, Lines: order.Lines}
+	dispatch(t, r, events.NewFrom(created, events.SourceInventory, reserved), reserved)
+
+	paid := events.PaymentSucceeded{OrderID: order.OrderID, UserID: order.UserID, Amount: 30}
+	dispatch(t, r, events.NewFrom(created, events.SourcePayment, paid), paid)
+
+	delivered := events.ShipmentDelivered{OrderID: order.OrderID}
+	dispatch(t, r, events.NewFrom(created, events.SourceShipping, delivered), delivered)
+
+	expected := []string{events.ReserveInventoryType, events.ChargePaymentType, events.CreateShipmentType}
+
+	if sent := commands(t, db); len(sent) != len(expected) || sent[0] != expected[0] || sent[1] != expected[1] || sent[2] != expected[2] {
+		t.Errorf("expected commands %v, got %v", expected, sent)
+	}
+
+	if instance := sagaInstance(t, db, order.OrderID); instance.Status != models.SagaStatusCompleted {
+		t.Errorf("expected the saga to be completed, got %s", instance.Status)
+	}
+}
+
+func TestOrderSagaCompensatesFailedReservation(t *testing.T) {
+	db, r := setupRouter(t)
+
+	order := events.OrderCreated{OrderID: "order-1", Lines: []events.OrderLine{{Product: "1", Quantity: 3}}}
+	created := events.New(events.SourceOrders, order.OrderID, order)
+
+	dispatch(t, r, created, order)
+
+	failed := events.InventoryReservationFailed{OrderID: order.OrderID, Reason: "insufficient stock", Lines: []events.FailedLine{{Product: "1", Quantity: 3, Reason: "insufficient stock"}}}
+	dispatch(t, r, events.NewFrom(created, events.SourceInventory, failed), failed)
+
+	instance := sagaInstance(t, db, order.OrderID)
+
+	if instance.Status != models.SagaStatusCompensating || instance.FailureReason != failed.Reason {
+		t.Fatalf("expected the saga to compensate %q, got %s with %q", failed.Reason, instance.Status, instance.FailureReason)
+	}
+
+	reverted := events.OrderReverted{OrderID: order.OrderID}
+	dispatch(t, r, events.NewFrom(created, events.SourceOrders, reverted), reverted)
+
+	sent := commands(t, db)
+
+	if len(sent) != 2 || sent[0] != events.ReserveInventoryType || sent[1] != events.RevertOrderType {
+		t.Errorf("expected ReserveInventory then RevertOrder, got %v", sent)
+	}
+
+	if instance := sagaInstance(t, db, order.OrderID); instance.Status != models.SagaStatusCompensated {
+		t.Errorf("expected the saga to be compensated, got %s", instance.Status)
+	}
+
+	var steps []models.SagaStep
+
+	if err := db.NewSelect().Model(&steps).Where("saga_id = ?", order.OrderID).Order("id").Scan(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []struct {
+		name         string
+		compensation bool
+		status       models.SagaStepStatus
+		reply        string
+	}{
+		{"create_order", false, models.SagaStepStatusSucceeded, events.OrderCreatedType},
+		{"reserve_inventory", false, models.SagaStepStatusFailed, events.InventoryReservationFailedType},
+		{"create_order", true, models.SagaStepStatusSucceeded, events.OrderRevertedType},
+	}
+
+	if len(steps) != len(expected) {
+		t.Fatalf("expected %d steps in the history, got %d", len(expected), len(steps))
+	}
+
+	for i, step := range steps {
+		if step.Name != expected[i].name || step.Compensation != expected[i].compensation || step.Status != expected[i].status || step.Reply != expected[i].reply {
+			t.Errorf("step %d: expected %+v, got %s compensation=%t %s %s", i, expected[i], step.Name, step.Compensation, step.Status, step.Reply)
+		}
+
+		if step.FinishedAt.IsZero() {
+			t.Errorf("step %d: expected a finish time", i)
+		}
+	}
+}
+
+func TestOrderSagaCompensatesFailedPayment(t *testing.T) {
+	db, r := setupRouter(t)
+
+	order := events.OrderCreated{OrderID: "order-1", UserID: 1, Lines: []events.OrderLine{{Product: "1", Quantity: 2, Price: 10}, {Product: "2", Quantity: 1, Price: 10}}}
+	created := events.New(events.SourceOrders, order.OrderID, order)
+
+	dispatch(t, r, created, order)
+
+	reserved := events.InventoryReserved{OrderID: order.OrderID, Lines: order.Lines}
+	dispatch(t, r, events.NewFrom(created, events.SourceInventory, reserved), reserved)
+
+	failed := events.PaymentFailed{OrderID: order.OrderID, UserID: order.UserID, Reason: "insufficient balance"}
+	dispatch(t, r, events.NewFrom(created, events.SourcePayment, failed), failed)
+
+	// The payment step has nothing to undo, the stock is released then the order reverted
+	released := events.InventoryReleased{OrderID: order.OrderID, Lines: order.Lines}
+	dispatch(t, r, events.NewFrom(created, events.SourceInventory, released), released)
+
+	reverted := events.OrderReverted{OrderID: order.OrderID}
+	dispatch(t, r, events.NewFrom(created, events.SourceOrders, reverted), reverted)
+
+	expected := []string{events.ReserveInventoryType, events.ChargePaymentType, events.ReleaseInventoryType, events.RevertOrderType}
+
+	sent := commands(t, db)
+
+	if len(sent) != len(expected) {
+		t.Fatalf("expected commands %v, got %v", expected, sent)
+	}
+
+	for i := range expected {
+		if sent[i] != expected[i] {
+			t.Errorf("expected commands %v, got %v", expected, sent)
+			break
+		}
+	}
+
+	instance := sagaInstance(t, db, order.OrderID)
+
+	if instance.Status != models.SagaStatusCompensated || instance.FailureReason != failed.Reason {
+		t.Errorf("expected the saga to be compensated after %q, got %s with %q", failed.Reason, instance.Status, instance.FailureReason)
+	}
+}
+
+func TestOrderSagaCompensatesCanceledShipment(t *testing.T) {
+	db, r := setupRouter(t)
+
+	order := events.OrderCreated{OrderID: "order-1", UserID: 1, Lines: []events.OrderLine{{Product: "1", Quantity: 2, Price: 10}, {Product: "2", Quantity: 1, Price: 10}}}
+	created := events.New(events.SourceOrders, order.OrderID, order)
+
+	replies := []events.Event{
+		events.InventoryReserved{OrderID: order.OrderID, Lines: order.Lines},
+		events.PaymentSucceeded{OrderID: order.OrderID, UserID: order.UserID, Amount: 30},
+		events.ShipmentCanceled{OrderID: order.OrderID, Reason: "address not found"},
+		events.PaymentRefunded{OrderID: order.OrderID, UserID: order.UserID, Amount: 30},
+		events.InventoryReleased{OrderID: order.OrderID, Lines: order.Lines},
+		events.OrderReverted{OrderID: order.OrderID},
+	}
+
+	dispatch(t, r, created, order)
+
+	for _, reply := range replies {
+		dispatch(t, r, events.NewFrom(created, events.SourceOrchestrator, reply), reply)
+	}
+
+	expected := []string{
+		events.ReserveInventoryType,
+		events.ChargePaymentType,
+		events.CreateShipmentType,
+		events.RefundPaymentType,
+		events.ReleaseInventoryType,
+		events.RevertOrderType,
+	}
+
+	sent := commands(t, db)
+
+	if len(sent) != len(expected) {
+		t.Fatalf("expected commands %v, got %v", expected, sent)
+	}
+
+	for i := range expected {
+		if sent[i] != expected[i] {
+			t.Errorf("expected commands %v, got %v", expected, sent)
+			break
+		}
+	}
+
+	instance := sagaInstance(t, db, order.OrderID)
+
+	if instance.Status != models.SagaStatusCompensated || instance.FailureReason != "address not found" {
+		t.Errorf("expected the saga to be compensated after the shipment was canceled, got %s with %q", instance.Status, instance.FailureReason)
+	}
+}
+
+func TestOrderSagaCompensatesCanceledOrder(t *testing.T) {
+	db, r := setupRouter(t)
+
+	order := events.OrderCreated{OrderID: "order-1", UserID: 1, Lines: []events.OrderLine{{Product: "1", Quantity: 2, Price: 10}, {Product: "2", Quantity: 1, Price: 10}}}
+	created := events.New(events.SourceOrders, order.OrderID, order)
+
+	dispatch(t, r, created, order)
+
+	// The order is canceled while its shipment is pending
+	replies := []events.Event{
+		events.InventoryReserved{OrderID: order.OrderID, Lines: order.Lines},
+		events.PaymentSucceeded{OrderID: order.OrderID, UserID: order.UserID, Amount: 30},
+		events.OrderCanceled{OrderID: order.OrderID, Reason: "changed my mind"},
+		events.ShipmentCanceled{OrderID: order.OrderID, Reason: "changed my mind"},
+		events.PaymentRefunded{OrderID: order.OrderID, UserID: order.UserID, Amount: 30},
+		events.InventoryReleased{OrderID: order.OrderID, Lines: order.Lines},
+		events.OrderReverted{OrderID: order.OrderID},
+	}
+
+	for _, event := range replies {
+		dispatch(t, r, events.NewFrom(created, events.SourceOrchestrator, event), event)
+	}
+
+	expected := []string{
+		events.ReserveInventoryType,
+		events.ChargePaymentType,
+		events.CreateShipmentType,
+		events.CancelShipmentType,
+		events.RefundPaymentType,
+		events.ReleaseInventoryType,
+		events.RevertOrderType,
+	}
+
+	sent := commands(t, db)
+
+	if len(sent) != len(expected) {
+		t.Fatalf("expected commands %v, got %v", expected, sent)
+	}
+
+	for i := range expected {
+		if sent[i] != expected[i] {
+			t.Errorf("expected commands %v, got %v", expected, sent)
+			break
+		}
+	}
+
+	instance := sagaInstance(t, db, order.OrderID)
+
+	if instance.Status != models.SagaStatusCompensated || instance.FailureReason != "changed my mind" {
+		t.Errorf("expected the saga to be compensated after the cancellation, got %s with %q", instance.Status, instance.FailureReason)
+	}
+}
+
+func TestOrderSagaCompensatesFailedCommit(t *testing.T) {
+	db, r := setupRouter(t)
+
+	order := events.OrderCreated{OrderID: "order-1", UserID: 1, Lines: []events.OrderLine{{Product: "1", Quantity: 2, Price: 10}}}
+	created := events.New(events.SourceOrders, order.OrderID, order)
+
+	dispatch(t, r, created, order)
+
+	// The reservation expired and its stock is gone by the time the order is confirmed
+	replies := []events.Event{
+		events.InventoryReserved{OrderID: order.OrderID, Lines: order.Lines},
+		events.PaymentSucceeded{OrderID: order.OrderID, UserID: order.UserID, Amount: 20},
+		events.InventoryCommitFailed{OrderID: order.OrderID, Reason: "reservation expired and the stock is gone"},
+		events.ShipmentCanceled{OrderID: order.OrderID},
+		events.PaymentRefunded{OrderID: order.OrderID, UserID: order.UserID, Amount: 20},
+		events.InventoryReleased{OrderID: order.OrderID, Lines: order.Lines},
+		events.OrderReverted{OrderID: order.OrderID},
+	}
+
+	for _, event := range replies {
+		dispatch(t, r, events.NewFrom(created, events.SourceOrchestrator, event), event)
+	}
+
+	expected := []string{
+		events.ReserveInventoryType,
+		events.ChargePaymentType,
+		events.CreateShipmentType,
+		events.CancelShipmentType,
+		events.RefundPaymentType,
+		events.ReleaseInventoryType,
+		events.RevertOrderType,
+	}
+
+	sent := commands(t, db)
+
+	if len(sent) != len(expected) {
+		t.Fatalf("expected commands %v, got %v", expected, sent)
+	}
+
+	for i := range expected {
+		if sent[i] != expected[i] {
+			t.Errorf("expected commands %v, got %v", expected, sent)
+			break
+		}
+	}
+
+	instance := sagaInstance(t, db, order.OrderID)
+
+	if instance.Status != models.SagaStatusCompensated || instance.FailureReason != "reservation expired and the stock is gone" {
+		t.Errorf("expected the saga to be compensated after the failed commit, got %s with %q", instance.Status, instance.FailureReason)
+	}
+}
+
+func TestOrderSagaStopsWhenTheShipmentWasDelivered(t *testing.T) {
+	db, r := setupRouter(t)
+
+	order := events.OrderCreated{OrderID: "order-1", UserID: 1, Lines: []events.OrderLine{{Product: "1", Quantity: 2, Price: 10}}}
+	created := events.New(events.SourceOrders, order.OrderID, order)
+
+	dispatch(t, r, created, order)
+
+	// The order is canceled while the shipment is on its way and delivered in the meantime
+	replies := []events.Event{
+		events.InventoryReserved{OrderID: order.OrderID, Lines: order.Lines},
+		events.PaymentSucceeded{OrderID: order.OrderID, UserID: order.UserID, Amount: 20},
+		events.OrderCanceled{OrderID: order.OrderID, Reason: "changed my mind"},
+		events.ShipmentCancelFailed{OrderID: order.OrderID, Reason: "shipment already delivered"},
+	}
+
+	for _, event := range replies {
+		dispatch(t, r, events.NewFrom(created, events.SourceOrchestrator, event), event)
+	}
+
+	// The user is not refunded and the stock not released for goods they already have
+	expected := []string{events.ReserveInventoryType, events.ChargePaymentType, events.CreateShipmentType, events.CancelShipmentType}
+
+	sent := commands(t, db)
+
+	if len(sent) != len(expected) {
+		t.Fatalf("expected commands %v, got %v", expected, sent)
+	}
+
+	for i := range expected {
+		if sent[i] != expected[i] {
+			t.Errorf("expected commands %v, got %v", expected, sent)
+			break
+		}
+	}
+
+	if instance := sagaInstance(t, db, order.OrderID); instance.Status != models.SagaStatusFailed {
+		t.Errorf("expected the saga to fail for a manual action, got %s with %q", instance.Status, instance.FailureReason)
+	}
+
+	var step models.SagaStep
+
+	if err := db.NewSelect().Model(&step).Where("saga_id = ?", order.OrderID).Order("id DESC").Limit(1).Scan(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if !step.Compensation || step.Status != models.SagaStepStatusFailed || step.Reply != events.ShipmentCancelFailedType {
+		t.Errorf("expected the cancellation of the shipment to fail, got compensation=%t %s %s", step.Compensation, step.Status, step.Reply)
+	}
+}
+
+func TestOrderSagaStopsWhenTheOrderWasCompleted(t *testing.T) {
+	db, r := setupRouter(t)
+
+	order := events.OrderCreated{OrderID: "order-1", Lines: []events.OrderLine{{Product: "1", Quantity: 3}}}
+	created := events.New(events.SourceOrders, order.OrderID, order)
+
+	dispatch(t, r, created, order)
+
+	replies := []events.Event{
+		events.InventoryReservationFailed{OrderID: order.OrderID, Reason: "insufficient stock"},
+		events.OrderRevertFailed{OrderID: order.OrderID, Reason: "order already completed"},
+	}
+
+	for _, event := range replies {
+		dispatch(t, r, events.NewFrom(created, events.SourceOrchestrator, event), event)
+	}
+
+	if sent := commands(t, db); len(sent) != 2 || sent[1] != events.RevertOrderType {
+		t.Fatalf("expected ReserveInventory then RevertOrder, got %v", sent)
+	}
+
+	if instance := sagaInstance(t, db, order.OrderID); instance.Status != models.SagaStatusFailed {
+		t.Errorf("expected the saga to fail for a manual action, got %s with %q", instance.Status, instance.FailureReason)
+	}
+}
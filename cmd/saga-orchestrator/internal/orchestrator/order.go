@@ -0,0 +1,103 @@
+package orchestrator
+
+import (
+	"time"
+
+	"github.com/uptrace/bun"
+	"go.uber.org/fx"
+	"go.uber.org/zap"
+
+	"saga-pattern/internal/client"
+	"saga-pattern/internal/events"
+	"saga-pattern/pkg/saga"
+)
+
+const (
+	// ShippingTimeout is how long a shipment may take to be delivered before the shipping
+	// service is asked again
+	ShippingTimeout = 24 * time.Hour
+
+	// OrderSagaTimeout leaves room for every attempt of the shipping step
+	OrderSagaTimeout = 7 * 24 * time.Hour
+)
+
+// OrderSaga creates an order, reserves its stock, charges its user and ships it. The order is
+// created by the orders service before the saga starts and the saga completes once the order
+// is delivered. When a step fails, the shipment or the order is canceled, the user is
+// refunded, the stock released and the order reverted. A confirmed order whose stock is gone
+// by the time it is committed cancels the saga the same way. A shipment that was already
+// delivered cannot be canceled, nor can an order completed by its delivery: the saga then
+// stops as failed for a manual action instead of compensating goods the user has.
+var OrderSaga = saga.Definition[events.OrderCreated]{
+	Name:       "order",
+	Timeout:    OrderSagaTimeout,
+	CanceledBy: []string{events.OrderCanceledType, events.InventoryCommitFailedType},
+	Steps: []saga.Step[events.OrderCreated]{
+		{
+			Name: "create_order",
+			Compensation: func(order events.OrderCreated, reason string) events.Event {
+				return events.RevertOrder{OrderID: order.OrderID, Reason: reason}
+			},
+			CompensatedBy: []string{events.OrderRevertedType},
+			AbortedBy:     []string{events.OrderRevertFailedType},
+		},
+		{
+			Name: "reserve_inventory",
+			Action: func(order events.OrderCreated) events.Event {
+				return events.ReserveInventory{OrderID: order.OrderID, Lines: order.Lines}
+			},
+			Compensation: func(order events.OrderCreated, reason string) events.Event {
+				return events.ReleaseInventory{OrderID: order.OrderID, Lines: order.Lines, Reason: reason}
+			},
+			CompletedBy:   []string{events.InventoryReservedType},
+			FailedBy:      []string{events.InventoryReservationFailedType},
+			CompensatedBy: []string{events.InventoryReleasedType},
+			Timeout:       saga.DefaultStepTimeout,
+			Retry:         client.DefaultRetryPolicy,
+		},
+		{
+			Name: "charge_payment",
+			Action: func(order events.OrderCreated) events.Event {
+				return events.ChargePayment{OrderID: order.OrderID, UserID: order.UserID, Amount: order.Total()}
+			},
+			Compensation: func(order events.OrderCreated, reason string) events.Event {
+				return events.RefundPayment{OrderID: order.OrderID, UserID: order.UserID, Reason: reason}
+			},
+			CompletedBy:   []string{events.PaymentSucceededType},
+			FailedBy:      []string{events.PaymentFailedType},
+			CompensatedBy: []string{events.PaymentRefundedType},
+			Timeout:       saga.DefaultStepTimeout,
+			Retry:         client.DefaultRetryPolicy,
+		},
+		{
+			Name: "ship_order",
+			Action: func(order events.OrderCreated) events.Event {
+				return events.CreateShipment{OrderID: order.OrderID, UserID: order.UserID, Lines: order.Lines}
+			},
+			Compensation: func(order events.OrderCreated, reason string) events.Event {
+				return events.CancelShipment{OrderID: order.OrderID, Reason: reason}
+			},
+			CompletedBy:   []string{events.ShipmentDeliveredType},
+			FailedBy:      []string{events.ShipmentCanceledType},
+			CompensatedBy: []string{events.ShipmentCanceledType},
+			AbortedBy:     []string{events.ShipmentCancelFailedType},
+			Timeout:       ShippingTimeout,
+			Retry:         client.DefaultRetryPolicy,
+		},
+	},
+}
+
+// Engine runs the order saga
+type Engine = saga.Engine[events.OrderCreated]
+
+func NewEngine(logger *zap.Logger, db *bun.DB) *Engine {
+	return saga.NewEngine(logger, db, OrderSaga)
+}
+
+var Module = fx.Module("orchestrator",
+	fx.Provide(NewEngine),
+	fx.Provide(func(logger *zap.Logger, db *bun.DB, engine *Engine) *saga.Watchdog {
+		return saga.NewWatchdog(logger, db, engine)
+	}),
+	fx.Invoke(saga.StartWatchdog),
+)
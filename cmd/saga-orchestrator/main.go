@@ -0,0 +1,32 @@
+package main
+
+import (
+	"context"
+	"saga-pattern/cmd/saga-orchestrator/internal/handler"
+	"saga-pattern/cmd/saga-orchestrator/internal/message-listener"
+	"saga-pattern/cmd/saga-orchestrator/internal/orchestrator"
+	"saga-pattern/cmd/saga-orchestrator/migrations"
+	"saga-pattern/internal/client"
+	"saga-pattern/internal/database"
+
+	"go.uber.org/fx"
+	"go.uber.org/zap"
+)
+
+var ctx, cancel = context.WithCancel(context.Background())
+
+var options = fx.Options(
+	fx.Provide(func() context.Context { return ctx }),
+	fx.Provide(zap.NewExample),
+	client.Module,
+	database.Module(migrations.Schema),
+	handler.Module,
+	orchestrator.Module,
+	message_listener.Module,
+)
+
+func main() {
+	defer cancel()
+
+	fx.New(options).Run()
+}
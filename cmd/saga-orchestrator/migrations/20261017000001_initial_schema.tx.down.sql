@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS "inbox";
+DROP TABLE IF EXISTS "outbox";
+DROP TABLE IF EXISTS "leases";
+DROP TABLE IF EXISTS "saga_steps";
+DROP TABLE IF EXISTS "saga_instances";
@@ -0,0 +1,61 @@
+CREATE TABLE IF NOT EXISTS "saga_instances" (
+	"id" BIGSERIAL NOT NULL,
+	"saga_id" VARCHAR NOT NULL,
+	"name" VARCHAR,
+	"status" BIGINT,
+	"current_step" BIGINT,
+	"payload" JSONB,
+	"failure_reason" VARCHAR,
+	"deadline" TIMESTAMPTZ,
+	"created_at" TIMESTAMPTZ NOT NULL DEFAULT current_timestamp,
+	"updated_at" TIMESTAMPTZ NOT NULL DEFAULT current_timestamp,
+	PRIMARY KEY ("id"),
+	UNIQUE ("saga_id")
+);
+
+CREATE TABLE IF NOT EXISTS "saga_steps" (
+	"id" BIGSERIAL NOT NULL,
+	"saga_id" VARCHAR NOT NULL,
+	"name" VARCHAR,
+	"position" BIGINT,
+	"compensation" BOOLEAN,
+	"status" BIGINT,
+	"command" VARCHAR,
+	"payload" JSONB,
+	"attempts" BIGINT,
+	"deadline" TIMESTAMPTZ,
+	"reply" VARCHAR,
+	"reply_payload" JSONB,
+	"started_at" TIMESTAMPTZ NOT NULL DEFAULT current_timestamp,
+	"finished_at" TIMESTAMPTZ,
+	PRIMARY KEY ("id")
+);
+
+CREATE TABLE IF NOT EXISTS "leases" (
+	"name" VARCHAR NOT NULL,
+	"holder" VARCHAR,
+	"expires_at" TIMESTAMPTZ,
+	PRIMARY KEY ("name")
+);
+
+CREATE TABLE IF NOT EXISTS "outbox" (
+	"id" BIGSERIAL NOT NULL,
+	"topic" VARCHAR,
+	"key" VARCHAR,
+	"value" BYTEA,
+	"headers" JSONB,
+	"status" BIGINT,
+	"attempts" BIGINT,
+	"last_error" VARCHAR,
+	"created_at" TIMESTAMPTZ NOT NULL DEFAULT current_timestamp,
+	"delivered_at" TIMESTAMPTZ,
+	PRIMARY KEY ("id")
+);
+
+CREATE TABLE IF NOT EXISTS "inbox" (
+	"id" BIGSERIAL NOT NULL,
+	"message_key" VARCHAR NOT NULL,
+	"processed_at" TIMESTAMPTZ NOT NULL DEFAULT current_timestamp,
+	PRIMARY KEY ("id"),
+	UNIQUE ("message_key")
+);
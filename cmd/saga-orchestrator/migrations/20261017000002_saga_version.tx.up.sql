@@ -0,0 +1 @@
+ALTER TABLE "saga_instances" ADD COLUMN "version" BIGINT NOT NULL DEFAULT 1;
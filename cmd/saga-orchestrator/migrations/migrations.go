@@ -0,0 +1,20 @@
+package migrations
+
+import (
+	"embed"
+
+	"saga-pattern/internal/database"
+	"saga-pattern/internal/database/models"
+)
+
+//go:embed *.sql
+var files embed.FS
+
+// Schema is the part of the database owned by the saga orchestrator service
+var Schema = database.NewSchema(files, "cmd/saga-orchestrator/migrations",
+	(*models.SagaInstance)(nil),
+	(*models.SagaStep)(nil),
+	(*models.Lease)(nil),
+	(*models.OutboxMessage)(nil),
+	(*models.InboxMessage)(nil),
+)
@@ -0,0 +1,117 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"saga-pattern/internal/database"
+	"saga-pattern/internal/database/models"
+	"saga-pattern/internal/events"
+	"time"
+
+	"github.com/uptrace/bun"
+)
+
+const (
+	ShippingTopic = "shipping"
+)
+
+// ErrInvalidTransition is returned when a shipment cannot move to the requested status,
+// like delivering a shipment that was not dispatched
+var ErrInvalidTransition = errors.New("invalid shipment status transition")
+
+type CancelPayload struct {
+	Reason string `json:"reason"`
+}
+
+func GetShipments(ctx context.Context, db *bun.DB) (*[]models.Shipment, error) {
+	shipments := new([]models.Shipment)
+	err := db.NewSelect().Model(shipments).Order("id DESC").Limit(20).Scan(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return shipments, nil
+}
+
+func GetShipment(ctx context.Context, db *bun.DB, orderID string) (*models.Shipment, error) {
+	shipment := new(models.Shipment)
+
+	err := db.NewSelect().Model(shipment).Where("order_id = ?", orderID).Scan(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return shipment, nil
+}
+
+// DispatchShipment marks a pending shipment as dispatched
+func DispatchShipment(ctx context.Context, db *bun.DB, orderID string) (*models.Shipment, error) {
+	return transition(ctx, db, orderID, models.ShipmentStatusDispatched,
+		[]models.ShipmentStatus{models.ShipmentStatusPending},
+		events.ShipmentDispatched{OrderID: orderID})
+}
+
+// DeliverShipment marks a dispatched shipment as delivered, which completes the saga of the order
+func DeliverShipment(ctx context.Context, db *bun.DB, orderID string) (*models.Shipment, error) {
+	return transition(ctx, db, orderID, models.ShipmentStatusDelivered,
+		[]models.ShipmentStatus{models.ShipmentStatusDispatched},
+		events.ShipmentDelivered{OrderID: orderID})
+}
+
+// CancelShipment cancels a shipment that was not delivered yet, the saga of the order then
+// compensates its earlier steps
+func CancelShipment(ctx context.Context, db *bun.DB, orderID string, reason string) (*models.Shipment, error) {
+	if reason == "" {
+		reason = "shipment canceled"
+	}
+
+	return transition(ctx, db, orderID, models.ShipmentStatusCanceled,
+		[]models.ShipmentStatus{models.ShipmentStatusPending, models.ShipmentStatusDispatched},
+		events.ShipmentCanceled{OrderID: orderID, Reason: reason})
+}
+
+// transition moves the shipment of the order to status and writes event to the outbox in
+// the same transaction. The status is checked by the update itself, so of a deliver and a
+// cancel racing on a dispatched shipment only one succeeds.
+func transition(ctx context.Context, db *bun.DB, orderID string, status models.ShipmentStatus, from []models.ShipmentStatus, event events.Event) (*models.Shipment, error) {
+	shipment := new(models.Shipment)
+
+	err := db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		res, err := tx.NewUpdate().Model((*models.Shipment)(nil)).
+			Set("status = ?", status).
+			Set("updated_at = ?", time.Now()).
+			Where("order_id = ?", orderID).
+			Where("status IN (?)", bun.In(from)).
+			Exec(ctx)
+
+		if err != nil {
+			return err
+		}
+
+		affected, err := res.RowsAffected()
+
+		if err != nil {
+			return err
+		}
+
+		if err := tx.NewSelect().Model(shipment).Where("order_id = ?", orderID).Scan(ctx); err != nil {
+			return err
+		}
+
+		if affected == 0 {
+			return ErrInvalidTransition
+		}
+
+		envelope := events.New(events.SourceShipping, orderID, event)
+
+		return database.EnqueueEvent(ctx, tx, ShippingTopic, envelope, event)
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return shipment, nil
+}
@@ -0,0 +1,140 @@
+package handler
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"saga-pattern/internal/database/models"
+
+	"github.com/uptrace/bun"
+	"go.uber.org/fx"
+	"go.uber.org/zap"
+)
+
+func StartServer(lc fx.Lifecycle, db *bun.DB, logger *zap.Logger) {
+	lc.Append(fx.Hook{
+		OnStart: func(ctx context.Context) error {
+			go func() {
+				logger.Info("Starting server on port 8080")
+				if err := http.ListenAndServe(":8080", NewHandler(logger, db)); err != nil {
+					logger.Error("Failed to start server", zap.Error(err))
+				}
+			}()
+			return nil
+		},
+	})
+}
+
+func NewHandler(logger *zap.Logger, db *bun.DB) http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("Shipping service is running"))
+	})
+
+	mux.HandleFunc("GET /shipments", func(w http.ResponseWriter, r *http.Request) {
+		shipments, err := GetShipments(r.Context(), db)
+		if err != nil {
+			logger.Error("Failed to get shipments", zap.Error(err))
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		if len(*shipments) == 0 {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(shipments)
+	})
+
+	mux.HandleFunc("GET /shipments/{orderID}", func(w http.ResponseWriter, r *http.Request) {
+		orderID := r.PathValue("orderID")
+		shipment, err := GetShipment(r.Context(), db, orderID)
+
+		if err != nil {
+			writeError(w, logger, "Failed to get shipment", orderID, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(shipment)
+	})
+
+	mux.HandleFunc("POST /shipments/{orderID}/dispatch", func(w http.ResponseWriter, r *http.Request) {
+		orderID := r.PathValue("orderID")
+		shipment, err := DispatchShipment(r.Context(), db, orderID)
+
+		if err != nil {
+			writeError(w, logger, "Failed to dispatch shipment", orderID, err)
+			return
+		}
+
+		writeShipment(w, shipment)
+	})
+
+	mux.HandleFunc("POST /shipments/{orderID}/deliver", func(w http.ResponseWriter, r *http.Request) {
+		orderID := r.PathValue("orderID")
+		shipment, err := DeliverShipment(r.Context(), db, orderID)
+
+		if err != nil {
+			writeError(w, logger, "Failed to deliver shipment", orderID, err)
+			return
+		}
+
+		writeShipment(w, shipment)
+	})
+
+	mux.HandleFunc("POST /shipments/{orderID}/cancel", func(w http.ResponseWriter, r *http.Request) {
+		orderID := r.PathValue("orderID")
+
+		// The reason is optional, an empty body cancels with the default one
+		var payload CancelPayload
+		_ = json.NewDecoder(r.Body).Decode(&payload)
+
+		shipment, err := CancelShipment(r.Context(), db, orderID, payload.Reason)
+
+		if err != nil {
+			writeError(w, logger, "Failed to cancel shipment", orderID, err)
+			return
+		}
+
+		writeShipment(w, shipment)
+	})
+
+	return mux
+}
+
+func writeShipment(w http.ResponseWriter, shipment *models.Shipment) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(shipment)
+}
+
+// writeError answers 404 for an unknown shipment and 409 for a status it cannot leave
+func writeError(w http.ResponseWriter, logger *zap.Logger, message string, orderID string, err error) {
+	logger.Error(message, zap.Error(err), zap.String("orderID", orderID))
+
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Shipment not found"})
+
+	case errors.Is(err, ErrInvalidTransition):
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+
+	default:
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+var Module = fx.Module("shipping-command",
+	fx.Invoke(StartServer),
+)
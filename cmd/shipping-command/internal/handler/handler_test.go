@@ -0,0 +1,111 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/uptrace/bun"
+	"go.uber.org/zap"
+
+	"saga-pattern/internal/database"
+	"saga-pattern/internal/database/models"
+	"saga-pattern/internal/events"
+)
+
+func setupHandler(t *testing.T) (http.Handler, *bun.DB) {
+	db := database.NewMockDatabase(t, &models.Shipment{}, &models.OutboxMessage{})
+	logger, _ := zap.NewDevelopment()
+	handler := NewHandler(logger, db)
+
+	shipment := &models.Shipment{OrderID: "order-1", UserID: 1, Items: []models.ShipmentItem{{Product: "1", Quantity: 3}}, Status: models.ShipmentStatusPending}
+
+	if _, err := db.NewInsert().Model(shipment).Exec(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	return handler, db
+}
+
+func post(t *testing.T, server *httptest.Server, endpointURL string) int {
+	t.Helper()
+
+	resp, err := http.Post(fmt.Sprintf("%s%s", server.URL, endpointURL), "application/json", nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	return resp.StatusCode
+}
+
+func TestShipmentLifecycleEndpoints(t *testing.T) {
+	tests := []struct {
+		name           string
+		endpoints      []string
+		expectedStatus []int
+		expectedEvents []string
+	}{
+		{
+			name:           "a dispatched shipment is delivered",
+			endpoints:      []string{"/shipments/order-1/dispatch", "/shipments/order-1/deliver"},
+			expectedStatus: []int{http.StatusOK, http.StatusOK},
+			expectedEvents: []string{events.ShipmentDispatchedType, events.ShipmentDeliveredType},
+		},
+		{
+			name:           "a shipment is not delivered before being dispatched",
+			endpoints:      []string{"/shipments/order-1/deliver"},
+			expectedStatus: []int{http.StatusConflict},
+		},
+		{
+			name:           "a delivered shipment cannot be canceled",
+			endpoints:      []string{"/shipments/order-1/dispatch", "/shipments/order-1/deliver", "/shipments/order-1/cancel"},
+			expectedStatus: []int{http.StatusOK, http.StatusOK, http.StatusConflict},
+			expectedEvents: []string{events.ShipmentDispatchedType, events.ShipmentDeliveredType},
+		},
+		{
+			name:           "a dispatched shipment is canceled",
+			endpoints:      []string{"/shipments/order-1/dispatch", "/shipments/order-1/cancel"},
+			expectedStatus: []int{http.StatusOK, http.StatusOK},
+			expectedEvents: []string{events.ShipmentDispatchedType, events.ShipmentCanceledType},
+		},
+		{
+			name:           "an unknown shipment is not found",
+			endpoints:      []string{"/shipments/order-2/dispatch"},
+			expectedStatus: []int{http.StatusNotFound},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler, db := setupHandler(t)
+			server := httptest.NewServer(handler)
+			defer server.Close()
+
+			for i, endpoint := range tt.endpoints {
+				if status := post(t, server, endpoint); status != tt.expectedStatus[i] {
+					t.Errorf("%s: expected status %d, got %d", endpoint, tt.expectedStatus[i], status)
+				}
+			}
+
+			var messages []models.OutboxMessage
+
+			if err := db.NewSelect().Model(&messages).Order("id").Scan(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+
+			if len(messages) != len(tt.expectedEvents) {
+				t.Fatalf("expected events %v, got %d events", tt.expectedEvents, len(messages))
+			}
+
+			for i, message := range messages {
+				if message.Topic != ShippingTopic || message.Headers[events.HeaderType] != tt.expectedEvents[i] {
+					t.Errorf("expected %s on %s, got %s on %s", tt.expectedEvents[i], ShippingTopic, message.Headers[events.HeaderType], message.Topic)
+				}
+			}
+		})
+	}
+}
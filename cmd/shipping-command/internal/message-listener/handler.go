@@ -0,0 +1,161 @@
+package message_listener
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"saga-pattern/internal/client"
+	"saga-pattern/internal/database"
+	"saga-pattern/internal/database/models"
+	"saga-pattern/internal/events"
+	"saga-pattern/internal/router"
+	"time"
+
+	"github.com/uptrace/bun"
+	"go.uber.org/fx"
+	"go.uber.org/zap"
+)
+
+const (
+	CreateShipmentType = events.CreateShipmentType
+	CancelShipmentType = events.CancelShipmentType
+
+	// SagaTopic carries the commands of the saga orchestrator
+	SagaTopic = "saga"
+
+	// ShippingTopic receives the events of the shipping service
+	ShippingTopic = "shipping"
+)
+
+// NewRouter registers the handlers of the saga commands consumed by the shipping service
+func NewRouter(db *bun.DB, logger *zap.Logger) *router.Router {
+	r := router.New(logger, router.WithUnknownPolicy(router.IgnoreUnknown))
+
+	r.Use(
+		router.Logging(logger),
+		router.Retry(client.NewRetrier(logger, db, nil)),
+		router.Deduplicate(db, logger),
+		router.Recover(logger),
+	)
+
+	router.Handle(r, func(ctx context.Context, command events.CreateShipment) error {
+		return handleCreateShipment(ctx, db, logger, command)
+	})
+
+	router.Handle(r, func(ctx context.Context, command events.CancelShipment) error {
+		return handleCancelShipment(ctx, db, logger, router.Envelope(ctx), command)
+	})
+
+	return r
+}
+
+func StartKafkaListener(lc fx.Lifecycle, db *bun.DB, logger *zap.Logger, api client.API, ctx context.Context) {
+	router.Start(ctx, lc, logger, db, api, NewRouter(db, logger))
+}
+
+// handleCreateShipment creates the pending shipment of a confirmed order. The saga step is
+// answered later, once the shipment is delivered or canceled from the shipping API.
+func handleCreateShipment(ctx context.Context, db *bun.DB, logger *zap.Logger, command events.CreateShipment) error {
+	logger.Info("Processing CreateShipment command",
+		zap.String("orderID", command.OrderID),
+		zap.Int("lines", len(command.Lines)))
+
+	err := db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		if err := database.MarkProcessed(ctx, tx, database.InboxKey(command.OrderID, CreateShipmentType)); err != nil {
+			return err
+		}
+
+		shipment := &models.Shipment{
+			OrderID: command.OrderID,
+			UserID:  command.UserID,
+			Status:  models.ShipmentStatusPending,
+		}
+
+		for _, line := range command.Lines {
+			shipment.Items = append(shipment.Items, models.ShipmentItem{Product: line.Product, Quantity: line.Quantity})
+		}
+
+		_, err := tx.NewInsert().Model(shipment).On("CONFLICT DO NOTHING").Exec(ctx)
+
+		return err
+	})
+
+	if errors.Is(err, database.ErrDuplicateMessage) {
+		logger.Info("Skipping duplicate CreateShipment command", zap.String("orderID", command.OrderID))
+		return nil
+	}
+
+	if err != nil {
+		logger.Error("Failed to create shipment", zap.Error(err))
+		return err
+	}
+
+	logger.Info("Created shipment for order", zap.String("orderID", command.OrderID))
+
+	return nil
+}
+
+// handleCancelShipment cancels the shipment of the order unless it was already delivered.
+// The command is always answered: ShipmentCancelFailed when the goods reached the user, so
+// the saga does not refund and restock them, ShipmentCanceled otherwise.
+func handleCancelShipment(ctx context.Context, db *bun.DB, logger *zap.Logger, envelope events.Envelope, command events.CancelShipment) error {
+	logger.Info("Processing CancelShipment command",
+		zap.String("orderID", command.OrderID),
+		zap.String("reason", command.Reason))
+
+	err := db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		if err := database.MarkProcessed(ctx, tx, database.InboxKey(command.OrderID, CancelShipmentType)); err != nil {
+			return err
+		}
+
+		res, err := tx.NewUpdate().Model((*models.Shipment)(nil)).
+			Set("status = ?", models.ShipmentStatusCanceled).
+			Set("updated_at = ?", time.Now()).
+			Where("order_id = ?", command.OrderID).
+			Where("status IN (?)", bun.In([]models.ShipmentStatus{models.ShipmentStatusPending, models.ShipmentStatusDispatched})).
+			Exec(ctx)
+
+		if err != nil {
+			return err
+		}
+
+		canceled, err := res.RowsAffected()
+
+		if err != nil {
+			return err
+		}
+
+		var event events.Event = events.ShipmentCanceled{OrderID: command.OrderID, Reason: command.Reason}
+
+		if canceled == 0 {
+			shipment := &models.Shipment{}
+
+			err := tx.NewSelect().Model(shipment).Where("order_id = ?", command.OrderID).Scan(ctx)
+
+			switch {
+			case errors.Is(err, sql.ErrNoRows):
+				logger.Info("Nothing to cancel, the order has no shipment", zap.String("orderID", command.OrderID))
+
+			case err != nil:
+				return err
+
+			case shipment.Status == models.ShipmentStatusDelivered:
+				logger.Warn("Shipment cannot be canceled, it was already delivered", zap.String("orderID", command.OrderID))
+
+				event = events.ShipmentCancelFailed{OrderID: command.OrderID, Reason: "shipment already delivered"}
+
+			default:
+				logger.Info("Shipment was already canceled", zap.String("orderID", command.OrderID))
+			}
+		}
+
+		return database.EnqueueEvent(ctx, tx, ShippingTopic, events.NewFrom(envelope, events.SourceShipping, event), event)
+	})
+
+	if errors.Is(err, database.ErrDuplicateMessage) {
+		logger.Info("Skipping duplicate CancelShipment command", zap.String("orderID", command.OrderID))
+		return nil
+	}
+
+	return err
+}
@@ -0,0 +1,113 @@
+package message_listener
+
+import (
+	"context"
+	"testing"
+
+	"go.uber.org/zap"
+
+	"saga-pattern/internal/client"
+	"saga-pattern/internal/database"
+	"saga-pattern/internal/database/models"
+	"saga-pattern/internal/events"
+)
+
+func TestShipmentIsCreatedThenCanceled(t *testing.T) {
+	db := database.NewMockDatabase(t, &models.Shipment{}, &models.OutboxMessage{}, &models.InboxMessage{})
+	logger, _ := zap.NewDevelopment()
+	ctx := context.Background()
+
+	create := events.CreateShipment{OrderID: "order-1", UserID: 1, Lines: []events.OrderLine{{Product: "1", Quantity: 2}, {Product: "2", Quantity: 1}}}
+	cancel := events.CancelShipment{OrderID: "order-1", Reason: "saga timed out"}
+
+	r := NewRouter(db, logger)
+
+	for _, command := range []events.Event{create, cancel} {
+		envelope := events.New(events.SourceOrchestrator, "order-1", command)
+		headers, value, err := events.Encode(envelope, command)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		message := client.Message{Topic: SagaTopic, Key: []byte(envelope.SagaID), Value: value, Headers: headers}
+
+		// Every command is delivered twice
+		for i := 0; i < 2; i++ {
+			if err := r.Dispatch(ctx, message); err != nil {
+				t.Fatalf("%s delivery %d: %v", command.EventType(), i+1, err)
+			}
+		}
+	}
+
+	var shipments []models.Shipment
+
+	if err := db.NewSelect().Model(&shipments).Scan(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(shipments) != 1 || shipments[0].Status != models.ShipmentStatusCanceled {
+		t.Fatalf("expected a single canceled shipment, got %+v", shipments)
+	}
+
+	if len(shipments[0].Items) != 2 {
+		t.Errorf("expected the shipment to hold every line of the order, got %+v", shipments[0].Items)
+	}
+
+	var replies []models.OutboxMessage
+
+	if err := db.NewSelect().Model(&replies).Scan(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(replies) != 1 || replies[0].Topic != ShippingTopic || replies[0].Headers[events.HeaderType] != events.ShipmentCanceledType {
+		t.Errorf("expected a single ShipmentCanceled reply, got %d replies", len(replies))
+	}
+}
+
+func TestDeliveredShipmentIsNotCanceled(t *testing.T) {
+	db := database.NewMockDatabase(t, &models.Shipment{}, &models.OutboxMessage{}, &models.InboxMessage{})
+	logger, _ := zap.NewDevelopment()
+	ctx := context.Background()
+
+	shipment := &models.Shipment{OrderID: "order-1", UserID: 1, Status: models.ShipmentStatusDelivered}
+
+	if _, err := db.NewInsert().Model(shipment).Exec(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	cancel := events.CancelShipment{OrderID: "order-1", Reason: "changed my mind"}
+
+	if err := handleCancelShipment(ctx, db, logger, events.New(events.SourceOrchestrator, "order-1", cancel), cancel); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.NewSelect().Model(shipment).WherePK().Scan(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if shipment.Status != models.ShipmentStatusDelivered {
+		t.Errorf("expected the shipment to stay delivered, got %s", shipment.Status)
+	}
+
+	var replies []models.OutboxMessage
+
+	if err := db.NewSelect().Model(&replies).Scan(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(replies) != 1 || replies[0].Headers[events.HeaderType] != events.ShipmentCancelFailedType {
+		t.Fatalf("expected a single ShipmentCancelFailed reply, got %d replies", len(replies))
+	}
+
+	// The orchestrator stops the saga instead of refunding and restocking the goods
+	_, failed, err := events.Decode[events.ShipmentCancelFailed](replies[0].Headers, replies[0].Value)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if failed.OrderID != "order-1" || failed.Reason == "" {
+		t.Errorf("expected the reply to tell why order-1 cannot be canceled, got %+v", failed)
+	}
+}
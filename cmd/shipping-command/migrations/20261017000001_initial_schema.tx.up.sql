@@ -0,0 +1,33 @@
+CREATE TABLE IF NOT EXISTS "shipments" (
+	"id" BIGSERIAL NOT NULL,
+	"order_id" VARCHAR NOT NULL,
+	"user_id" BIGINT,
+	"status" BIGINT,
+	"items" JSONB,
+	"created_at" TIMESTAMPTZ NOT NULL DEFAULT current_timestamp,
+	"updated_at" TIMESTAMPTZ NOT NULL DEFAULT current_timestamp,
+	PRIMARY KEY ("id"),
+	UNIQUE ("order_id")
+);
+
+CREATE TABLE IF NOT EXISTS "outbox" (
+	"id" BIGSERIAL NOT NULL,
+	"topic" VARCHAR,
+	"key" VARCHAR,
+	"value" BYTEA,
+	"headers" JSONB,
+	"status" BIGINT,
+	"attempts" BIGINT,
+	"last_error" VARCHAR,
+	"created_at" TIMESTAMPTZ NOT NULL DEFAULT current_timestamp,
+	"delivered_at" TIMESTAMPTZ,
+	PRIMARY KEY ("id")
+);
+
+CREATE TABLE IF NOT EXISTS "inbox" (
+	"id" BIGSERIAL NOT NULL,
+	"message_key" VARCHAR NOT NULL,
+	"processed_at" TIMESTAMPTZ NOT NULL DEFAULT current_timestamp,
+	PRIMARY KEY ("id"),
+	UNIQUE ("message_key")
+);
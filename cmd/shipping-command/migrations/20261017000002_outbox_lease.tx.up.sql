@@ -0,0 +1,6 @@
+CREATE TABLE IF NOT EXISTS "leases" (
+	"name" VARCHAR NOT NULL,
+	"holder" VARCHAR,
+	"expires_at" TIMESTAMPTZ,
+	PRIMARY KEY ("name")
+);
@@ -0,0 +1,19 @@
+package migrations
+
+import (
+	"embed"
+
+	"saga-pattern/internal/database"
+	"saga-pattern/internal/database/models"
+)
+
+//go:embed *.sql
+var files embed.FS
+
+// Schema is the part of the database owned by the shipping service
+var Schema = database.NewSchema(files, "cmd/shipping-command/migrations",
+	(*models.Shipment)(nil),
+	(*models.OutboxMessage)(nil),
+	(*models.InboxMessage)(nil),
+	(*models.Lease)(nil),
+)
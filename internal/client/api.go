@@ -3,43 +3,52 @@ package client
 import (
 	"context"
 
-	"github.com/segmentio/kafka-go"
 	"go.uber.org/zap"
 )
 
-type MessageChan chan kafka.Message
+type MessageChan chan Message
 
 type API interface {
-	SendMessage(ctx context.Context, message kafka.Message) error
-	ReadMessage(ctx context.Context) (kafka.Message, error)
+	ReadMessage(ctx context.Context) (Message, error)
+
+	// Ack commits the offset of a message once its handler succeeded
+	Ack(ctx context.Context, message Message) error
 }
 
 type api struct {
-	inputChan  MessageChan
 	outputChan MessageChan
+	subscriber Subscriber
 	logger     *zap.Logger
 }
 
-func NewAPI(logger *zap.Logger, inputChan MessageChan, outputChan MessageChan) API {
+func NewAPI(logger *zap.Logger, outputChan MessageChan, subscriber Subscriber) API {
 	return &api{
-		inputChan:  inputChan,
 		outputChan: outputChan,
+		subscriber: subscriber,
 		logger:     logger,
 	}
 }
 
-func (a *api) SendMessage(ctx context.Context, message kafka.Message) error {
-	a.logger.Info("Sending message", zap.Any("message", message))
+func (a *api) ReadMessage(ctx context.Context) (Message, error) {
+	a.logger.Info("Reading message")
+
 	select {
-	case a.inputChan <- message:
-		a.logger.Info("Message sent", zap.Any("message", message))
-		return nil
+	case message := <-a.outputChan:
+		return message, nil
 	case <-ctx.Done():
-		return ctx.Err()
+		return Message{}, ctx.Err()
 	}
 }
 
-func (a *api) ReadMessage(ctx context.Context) (kafka.Message, error) {
-	a.logger.Info("Reading message")
-	return <-a.outputChan, nil
+func (a *api) Ack(ctx context.Context, message Message) error {
+	if err := a.subscriber.Commit(ctx, message); err != nil {
+		a.logger.Error("Failed to commit message offset",
+			zap.String("topic", message.Topic),
+			zap.Int("partition", message.Partition),
+			zap.Int64("offset", message.Offset),
+			zap.Error(err))
+		return err
+	}
+
+	return nil
 }
@@ -0,0 +1,36 @@
+package client
+
+import (
+	"context"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func TestAckCommitsTheNextOffset(t *testing.T) {
+	logger, _ := zap.NewDevelopment()
+	broker := NewMemoryBroker(1)
+	outputChan := make(MessageChan, 1)
+	api := NewAPI(logger, outputChan, broker.Subscribe("orders-service", "orders"))
+	ctx := context.Background()
+
+	outputChan <- Message{Topic: "orders", Offset: 1}
+
+	message, err := api.ReadMessage(ctx)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if committed := broker.Committed("orders-service", "orders", 0); committed != 0 {
+		t.Fatalf("expected no committed offset before the ack, got %d", committed)
+	}
+
+	if err := api.Ack(ctx, message); err != nil {
+		t.Fatal(err)
+	}
+
+	if committed := broker.Committed("orders-service", "orders", 0); committed != 2 {
+		t.Errorf("expected next committed offset 2, got %d", committed)
+	}
+}
@@ -0,0 +1,126 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+func kafkaURL() string {
+	return fmt.Sprintf("%s:%s", kafka_host, kafka_port)
+}
+
+// writerBatchTimeout bounds how long a write waits for more messages before it is sent.
+// The writes are synchronous, so the default of a second would delay every one of them.
+const writerBatchTimeout = 10 * time.Millisecond
+
+// NewWriter creates the *kafka.Writer of the service. Messages are hashed by key
+// so all the events of an aggregate go to the same partition.
+func NewWriter() *kafka.Writer {
+	return kafka.NewWriter(kafka.WriterConfig{
+		Brokers:      []string{kafkaURL()},
+		Balancer:     &kafka.Hash{},
+		BatchTimeout: writerBatchTimeout,
+	})
+}
+
+// NewReader creates a consumer group *kafka.Reader. Offsets are only committed
+// explicitly through API.Ack, so CommitInterval is left at zero (synchronous commits).
+func NewReader() *kafka.Reader {
+	return kafka.NewReader(kafka.ReaderConfig{
+		Brokers:     []string{kafkaURL()},
+		GroupID:     groupID(),
+		GroupTopics: topicsRead(),
+	})
+}
+
+// KafkaPublisher adapts a *kafka.Writer to the Publisher interface
+type KafkaPublisher struct {
+	writer *kafka.Writer
+}
+
+func NewKafkaPublisher(writer *kafka.Writer) *KafkaPublisher {
+	return &KafkaPublisher{writer: writer}
+}
+
+func (p *KafkaPublisher) Publish(ctx context.Context, messages ...Message) error {
+	kafkaMessages := make([]kafka.Message, len(messages))
+
+	for i, message := range messages {
+		kafkaMessages[i] = toKafkaMessage(message)
+	}
+
+	return p.writer.WriteMessages(ctx, kafkaMessages...)
+}
+
+func (p *KafkaPublisher) Close() error {
+	return p.writer.Close()
+}
+
+// KafkaSubscriber adapts a consumer group *kafka.Reader to the Subscriber interface
+type KafkaSubscriber struct {
+	reader *kafka.Reader
+}
+
+func NewKafkaSubscriber(reader *kafka.Reader) *KafkaSubscriber {
+	return &KafkaSubscriber{reader: reader}
+}
+
+func (s *KafkaSubscriber) Fetch(ctx context.Context) (Message, error) {
+	message, err := s.reader.FetchMessage(ctx)
+
+	if err != nil {
+		return Message{}, err
+	}
+
+	return fromKafkaMessage(message), nil
+}
+
+func (s *KafkaSubscriber) Commit(ctx context.Context, messages ...Message) error {
+	kafkaMessages := make([]kafka.Message, len(messages))
+
+	for i, message := range messages {
+		kafkaMessages[i] = toKafkaMessage(message)
+	}
+
+	return s.reader.CommitMessages(ctx, kafkaMessages...)
+}
+
+func (s *KafkaSubscriber) Close() error {
+	return s.reader.Close()
+}
+
+func toKafkaMessage(message Message) kafka.Message {
+	kafkaMessage := kafka.Message{
+		Topic:     message.Topic,
+		Partition: message.Partition,
+		Offset:    message.Offset,
+		Key:       message.Key,
+		Value:     message.Value,
+	}
+
+	for key, value := range message.Headers {
+		kafkaMessage.Headers = append(kafkaMessage.Headers, kafka.Header{Key: key, Value: []byte(value)})
+	}
+
+	return kafkaMessage
+}
+
+func fromKafkaMessage(kafkaMessage kafka.Message) Message {
+	message := Message{
+		Topic:     kafkaMessage.Topic,
+		Partition: kafkaMessage.Partition,
+		Offset:    kafkaMessage.Offset,
+		Key:       kafkaMessage.Key,
+		Value:     kafkaMessage.Value,
+		Headers:   make(map[string]string, len(kafkaMessage.Headers)),
+	}
+
+	for _, header := range kafkaMessage.Headers {
+		message.Headers[header.Key] = string(header.Value)
+	}
+
+	return message
+}
@@ -2,102 +2,157 @@ package client
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
+	"strings"
+	"sync"
 
-	"github.com/segmentio/kafka-go"
+	"github.com/uptrace/bun"
 	"go.uber.org/fx"
 	"go.uber.org/zap"
 )
 
-type KafkaClient struct {
-	writer     *kafka.Writer
-	reader     *kafka.Reader
-	inputChan  MessageChan
+// Client moves the messages read from the broker to the API channel. Messages are
+// published by the outbox relay, never by the client.
+type Client struct {
+	subscriber Subscriber
 	outputChan MessageChan
 	ctx        context.Context
-	topic      string
+	cancel     context.CancelFunc
+	topics     []string
+	logger     *zap.Logger
+	wg         sync.WaitGroup
 }
 
 var topic_read = os.Getenv("SERVICE_TOPIC_READ")
+var group_id = os.Getenv("SERVICE_GROUP_ID")
+var message_broker = os.Getenv("MESSAGE_BROKER")
 var kafka_host = os.Getenv("KAFKA_HOST")
 var kafka_port = os.Getenv("KAFKA_PORT")
 
-func NewClient(lc fx.Lifecycle, logger *zap.Logger, inputChan MessageChan, outputChan MessageChan) error {
-	kafka_url := fmt.Sprintf("%s:%s", kafka_host, kafka_port)
+// topicsRead returns the topics consumed by the service, SERVICE_TOPIC_READ is a comma separated list
+func topicsRead() []string {
+	var topics []string
 
-	writer := kafka.NewWriter(kafka.WriterConfig{
-		Brokers:  []string{kafka_url},
-		Balancer: &kafka.LeastBytes{},
-	})
+	for _, topic := range strings.Split(topic_read, ",") {
+		if topic = strings.TrimSpace(topic); topic != "" {
+			topics = append(topics, topic)
+		}
+	}
+
+	return topics
+}
+
+// groupID returns the consumer group of the service, defaulting to one group per set of read topics
+func groupID() string {
+	if group_id != "" {
+		return group_id
+	}
+
+	return fmt.Sprintf("%s-consumer", strings.Join(topicsRead(), "-"))
+}
+
+// NewBroker creates the Publisher and Subscriber of the service. MESSAGE_BROKER selects
+// the transport: "kafka" (default) or "memory" to run without Kafka.
+func NewBroker(lc fx.Lifecycle, logger *zap.Logger) (Publisher, Subscriber) {
+	var publisher Publisher
+	var subscriber Subscriber
+
+	switch message_broker {
+	case "memory":
+		logger.Info("Using in-memory message broker")
+		broker := NewMemoryBroker(defaultMemoryPartitions)
+		publisher = broker.Publisher()
+		subscriber = broker.Subscribe(groupID(), topicsRead()...)
+	default:
+		publisher = NewKafkaPublisher(NewWriter())
+		subscriber = NewKafkaSubscriber(NewReader())
+	}
 
-	reader := kafka.NewReader(kafka.ReaderConfig{
-		Brokers: []string{kafka_url},
-		Topic:   topic_read,
+	lc.Append(fx.Hook{
+		OnStop: func(ctx context.Context) error {
+			return errors.Join(subscriber.Close(), publisher.Close())
+		},
 	})
 
-	client := &KafkaClient{
-		writer:     writer,
-		reader:     reader,
-		inputChan:  inputChan,
+	return publisher, subscriber
+}
+
+func NewClient(lc fx.Lifecycle, logger *zap.Logger, subscriber Subscriber, outputChan MessageChan) error {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	client := &Client{
+		subscriber: subscriber,
 		outputChan: outputChan,
-		ctx:        context.Background(),
-		topic:      topic_read,
+		ctx:        ctx,
+		cancel:     cancel,
+		topics:     topicsRead(),
+		logger:     logger,
 	}
 
 	lc.Append(fx.Hook{
-		OnStart: func(ctx context.Context) error {
-			go func() {
-				logger.Info("Starting to write messages to Kafka")
-				for {
-					select {
-					case message := <-client.inputChan:
-						logger.Info("Writing message to Kafka", zap.Any("message", message), zap.String("topic", message.Topic))
-						if err := client.writer.WriteMessages(context.Background(), message); err != nil {
-							logger.Error("Failed to write message to Kafka", zap.Error(err), zap.String("topic", message.Topic))
-						} else {
-							logger.Info("Successfully wrote message to Kafka", zap.String("topic", message.Topic))
-						}
-					case <-client.ctx.Done():
-						return
-					}
-				}
-			}()
-
-			go func() {
-				logger.Info("Starting to read messages from Kafka", zap.String("topic", topic_read))
-				for {
-					message, err := client.reader.ReadMessage(context.Background())
-					if err != nil {
-						logger.Error("Failed to read message", zap.Error(err), zap.String("topic", topic_read))
-						continue
-					}
-					logger.Info("Read message from Kafka", zap.Any("message", message), zap.String("topic", topic_read))
-					client.outputChan <- message
-				}
-			}()
+		OnStart: func(context.Context) error {
+			client.wg.Add(1)
+			go client.read()
 
 			return nil
 		},
-		OnStop: func(ctx context.Context) error {
-			client.writer.Close()
-			client.reader.Close()
-			return nil
-		},
+		OnStop: client.Stop,
 	})
 
 	return nil
 }
 
+func (c *Client) read() {
+	defer c.wg.Done()
+
+	c.logger.Info("Starting to read messages from the broker", zap.Strings("topics", c.topics), zap.String("group", groupID()))
+	for {
+		message, err := c.subscriber.Fetch(c.ctx)
+		if err != nil {
+			if c.ctx.Err() != nil {
+				return
+			}
+
+			c.logger.Error("Failed to read message", zap.Error(err), zap.Strings("topics", c.topics))
+			continue
+		}
+		c.logger.Info("Read message from the broker", zap.Any("message", message), zap.String("topic", message.Topic))
+
+		// Messages that were fetched but not handed over are not committed, the
+		// consumer group redelivers them after the restart.
+		select {
+		case c.outputChan <- message:
+		case <-c.ctx.Done():
+			return
+		}
+	}
+}
+
+// Stop stops consuming and waits for the reader to return. It gives up once ctx is
+// done, returning its error.
+func (c *Client) Stop(ctx context.Context) error {
+	c.cancel()
+
+	done := make(chan struct{})
+
+	go func() {
+		c.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		c.logger.Info("Broker client stopped")
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
 var Module = fx.Options(
-	fx.Provide(
-		fx.Annotate(
-			func() MessageChan {
-				return make(MessageChan)
-			},
-			fx.ResultTags(`name:"inputChan"`),
-		),
-	),
+	fx.Provide(NewBroker),
 	fx.Provide(
 		fx.Annotate(
 			func() MessageChan {
@@ -111,10 +166,10 @@ var Module = fx.Options(
 			func(params struct {
 				fx.In
 				Logger     *zap.Logger
-				InputChan  MessageChan `name:"inputChan"`
+				Subscriber Subscriber
 				OutputChan MessageChan `name:"outputChan"`
 			}) API {
-				return NewAPI(params.Logger, params.InputChan, params.OutputChan)
+				return NewAPI(params.Logger, params.OutputChan, params.Subscriber)
 			},
 		),
 	),
@@ -123,10 +178,15 @@ var Module = fx.Options(
 			fx.In
 			Lc         fx.Lifecycle
 			Logger     *zap.Logger
-			InputChan  MessageChan `name:"inputChan"`
+			Subscriber Subscriber
 			OutputChan MessageChan `name:"outputChan"`
 		}) error {
-			return NewClient(params.Lc, params.Logger, params.InputChan, params.OutputChan)
+			return NewClient(params.Lc, params.Logger, params.Subscriber, params.OutputChan)
+		},
+	),
+	fx.Invoke(
+		func(lc fx.Lifecycle, logger *zap.Logger, db *bun.DB, publisher Publisher) {
+			StartOutboxRelay(lc, NewOutboxRelay(logger, db, publisher))
 		},
 	),
 )
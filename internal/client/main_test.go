@@ -0,0 +1,38 @@
+package client
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"go.uber.org/fx/fxtest"
+	"go.uber.org/zap"
+)
+
+func TestClientStopsWhileNoOneConsumes(t *testing.T) {
+	logger, _ := zap.NewDevelopment()
+	broker := NewMemoryBroker(1)
+	lc := fxtest.NewLifecycle(t)
+
+	if err := NewClient(lc, logger, broker.Subscribe("test", "orders"), make(MessageChan)); err != nil {
+		t.Fatal(err)
+	}
+
+	lc.RequireStart()
+
+	// Blocks the reader until it is stopped, the client must not wait for a consumer
+	if err := broker.Publish(context.Background(), Message{Topic: "orders", Key: []byte("1")}); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	if err := lc.Stop(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if committed := broker.Committed("test", "orders", 0); committed != 0 {
+		t.Errorf("expected the message handed to no one to stay uncommitted, got offset %d", committed)
+	}
+}
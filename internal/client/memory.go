@@ -0,0 +1,251 @@
+package client
+
+import (
+	"context"
+	"hash/fnv"
+	"maps"
+	"sync"
+)
+
+const defaultMemoryPartitions = 3
+
+// MemoryBroker is an in-process broker with topics, partitions, consumer groups and
+// committed offsets. It lets the services and their tests run without Kafka.
+type MemoryBroker struct {
+	mu         sync.Mutex
+	partitions int
+	topics     map[string]*memoryTopic
+
+	// notify is closed and replaced on every publish to wake up waiting subscribers
+	notify chan struct{}
+}
+
+type memoryTopic struct {
+	partitions [][]Message
+	groups     map[string]*memoryGroup
+}
+
+type memoryGroup struct {
+	// committed and position hold the next offset of each partition
+	committed []int64
+	position  []int64
+	members   int
+	next      int
+}
+
+func NewMemoryBroker(partitions int) *MemoryBroker {
+	if partitions <= 0 {
+		partitions = defaultMemoryPartitions
+	}
+
+	return &MemoryBroker{
+		partitions: partitions,
+		topics:     make(map[string]*memoryTopic),
+		notify:     make(chan struct{}),
+	}
+}
+
+// topic returns the topic with the given name, creating it on first use. Callers hold b.mu.
+func (b *MemoryBroker) topic(name string) *memoryTopic {
+	topic, ok := b.topics[name]
+
+	if !ok {
+		topic = &memoryTopic{
+			partitions: make([][]Message, b.partitions),
+			groups:     make(map[string]*memoryGroup),
+		}
+		b.topics[name] = topic
+	}
+
+	return topic
+}
+
+func (t *memoryTopic) group(name string) *memoryGroup {
+	group, ok := t.groups[name]
+
+	if !ok {
+		group = &memoryGroup{
+			committed: make([]int64, len(t.partitions)),
+			position:  make([]int64, len(t.partitions)),
+		}
+		t.groups[name] = group
+	}
+
+	return group
+}
+
+func (b *MemoryBroker) partition(key []byte) int {
+	hash := fnv.New32a()
+	hash.Write(key)
+
+	return int(hash.Sum32() % uint32(b.partitions))
+}
+
+func (b *MemoryBroker) Publish(ctx context.Context, messages ...Message) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, message := range messages {
+		topic := b.topic(message.Topic)
+		partition := b.partition(message.Key)
+
+		message.Partition = partition
+		message.Offset = int64(len(topic.partitions[partition]))
+		message.Headers = maps.Clone(message.Headers)
+
+		topic.partitions[partition] = append(topic.partitions[partition], message)
+	}
+
+	close(b.notify)
+	b.notify = make(chan struct{})
+
+	return nil
+}
+
+// Messages returns every message published to a topic, partition by partition
+func (b *MemoryBroker) Messages(topic string) []Message {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var messages []Message
+
+	for _, partition := range b.topic(topic).partitions {
+		messages = append(messages, partition...)
+	}
+
+	return messages
+}
+
+// Committed returns the next offset the group will read from a partition after a restart
+func (b *MemoryBroker) Committed(group string, topic string, partition int) int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.topic(topic).group(group).committed[partition]
+}
+
+// Publisher returns a Publisher writing to the broker
+func (b *MemoryBroker) Publisher() Publisher {
+	return &memoryPublisher{broker: b}
+}
+
+// Subscribe joins the consumer group of the topics. When the group has no other member
+// it resumes from the committed offsets, so uncommitted messages are redelivered.
+func (b *MemoryBroker) Subscribe(group string, topics ...string) Subscriber {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, topic := range topics {
+		memoryGroup := b.topic(topic).group(group)
+
+		if memoryGroup.members == 0 {
+			copy(memoryGroup.position, memoryGroup.committed)
+		}
+
+		memoryGroup.members++
+	}
+
+	return &memorySubscriber{broker: b, group: group, topics: topics}
+}
+
+type memoryPublisher struct {
+	broker *MemoryBroker
+}
+
+func (p *memoryPublisher) Publish(ctx context.Context, messages ...Message) error {
+	return p.broker.Publish(ctx, messages...)
+}
+
+func (p *memoryPublisher) Close() error {
+	return nil
+}
+
+type memorySubscriber struct {
+	broker *MemoryBroker
+	group  string
+	topics []string
+	next   int
+	closed bool
+}
+
+func (s *memorySubscriber) Fetch(ctx context.Context) (Message, error) {
+	for {
+		s.broker.mu.Lock()
+
+		// Topics are visited round robin as well, like the partitions of a topic
+		for i := range s.topics {
+			index := (s.next + i) % len(s.topics)
+			topic := s.broker.topic(s.topics[index])
+
+			if message, ok := topic.group(s.group).fetch(topic); ok {
+				s.next = index + 1
+				s.broker.mu.Unlock()
+
+				return message, nil
+			}
+		}
+
+		notify := s.broker.notify
+		s.broker.mu.Unlock()
+
+		select {
+		case <-notify:
+		case <-ctx.Done():
+			return Message{}, ctx.Err()
+		}
+	}
+}
+
+// fetch returns the next message of the topic for the group. Callers hold the broker lock.
+func (g *memoryGroup) fetch(topic *memoryTopic) (Message, bool) {
+	// Partitions are visited round robin so a busy one does not starve the others
+	for i := 0; i < len(topic.partitions); i++ {
+		partition := (g.next + i) % len(topic.partitions)
+
+		if g.position[partition] < int64(len(topic.partitions[partition])) {
+			message := topic.partitions[partition][g.position[partition]]
+			message.Headers = maps.Clone(message.Headers)
+
+			g.position[partition]++
+			g.next = partition + 1
+
+			return message, true
+		}
+	}
+
+	return Message{}, false
+}
+
+func (s *memorySubscriber) Commit(ctx context.Context, messages ...Message) error {
+	s.broker.mu.Lock()
+	defer s.broker.mu.Unlock()
+
+	for _, message := range messages {
+		group := s.broker.topic(message.Topic).group(s.group)
+
+		if next := message.Offset + 1; next > group.committed[message.Partition] {
+			group.committed[message.Partition] = next
+		}
+	}
+
+	return nil
+}
+
+func (s *memorySubscriber) Close() error {
+	s.broker.mu.Lock()
+	defer s.broker.mu.Unlock()
+
+	if !s.closed {
+		s.closed = true
+
+		for _, topic := range s.topics {
+			s.broker.topic(topic).group(s.group).members--
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,125 @@
+package client
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestMemoryBrokerConsumerGroups(t *testing.T) {
+	broker := NewMemoryBroker(1)
+	ctx := context.Background()
+
+	for _, value := range []string{"first", "second"} {
+		message := Message{Topic: "orders", Key: []byte("order-1"), Value: []byte(value)}
+
+		if err := broker.Publish(ctx, message); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	inventory := broker.Subscribe("inventory-service", "orders")
+	audit := broker.Subscribe("audit-service", "orders")
+
+	// Every group reads every message
+	for _, subscriber := range []Subscriber{inventory, audit} {
+		message, err := subscriber.Fetch(ctx)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if string(message.Value) != "first" || message.Offset != 0 {
+			t.Errorf("expected the first message at offset 0, got %q at %d", message.Value, message.Offset)
+		}
+
+		if err := subscriber.Commit(ctx, message); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// An uncommitted message is redelivered once the group restarts
+	second, err := inventory.Fetch(ctx)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	inventory.Close()
+	inventory = broker.Subscribe("inventory-service", "orders")
+
+	redelivered, err := inventory.Fetch(ctx)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if redelivered.Offset != second.Offset {
+		t.Errorf("expected offset %d to be redelivered, got %d", second.Offset, redelivered.Offset)
+	}
+
+	// Fetch blocks until a message is published or the context is done
+	timeoutCtx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+
+	if _, err := inventory.Fetch(timeoutCtx); err == nil {
+		t.Error("expected Fetch to fail once the context is done")
+	}
+}
+
+func TestMemoryBrokerPartitionsByKey(t *testing.T) {
+	broker := NewMemoryBroker(3)
+	ctx := context.Background()
+
+	for i := 0; i < 5; i++ {
+		if err := broker.Publish(ctx, Message{Topic: "orders", Key: []byte("order-1")}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	messages := broker.Messages("orders")
+
+	for i, message := range messages {
+		if message.Partition != messages[0].Partition || message.Offset != int64(i) {
+			t.Errorf("expected messages of a key in one partition in order, got partition %d offset %d", message.Partition, message.Offset)
+		}
+	}
+}
+
+func TestMemoryBrokerSubscribesToSeveralTopics(t *testing.T) {
+	broker := NewMemoryBroker(1)
+	ctx := context.Background()
+
+	for _, topic := range []string{"orders", "inventory"} {
+		if err := broker.Publish(ctx, Message{Topic: topic, Key: []byte("order-1")}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	subscriber := broker.Subscribe("saga-orchestrator", "orders", "inventory")
+	topics := make(map[string]bool)
+
+	for range 2 {
+		message, err := subscriber.Fetch(ctx)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		topics[message.Topic] = true
+
+		if err := subscriber.Commit(ctx, message); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if !topics["orders"] || !topics["inventory"] {
+		t.Errorf("expected a message from each topic, got %v", topics)
+	}
+
+	for _, topic := range []string{"orders", "inventory"} {
+		if committed := broker.Committed("saga-orchestrator", topic, 0); committed != 1 {
+			t.Errorf("expected offset 1 to be committed on %s, got %d", topic, committed)
+		}
+	}
+}
@@ -0,0 +1,29 @@
+package client
+
+import "context"
+
+// Message is the transport neutral representation of a message exchanged between services
+type Message struct {
+	Topic   string
+	Key     []byte
+	Value   []byte
+	Headers map[string]string
+
+	// Position of the message, set by the subscriber that fetched it
+	Partition int
+	Offset    int64
+}
+
+// Publisher writes messages to the broker, returning once they are stored
+type Publisher interface {
+	Publish(ctx context.Context, messages ...Message) error
+	Close() error
+}
+
+// Subscriber reads the messages of a topic as a member of a consumer group. Fetched
+// messages are redelivered to the group until their offset is committed.
+type Subscriber interface {
+	Fetch(ctx context.Context) (Message, error)
+	Commit(ctx context.Context, messages ...Message) error
+	Close() error
+}
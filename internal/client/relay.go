@@ -0,0 +1,162 @@
+package client
+
+import (
+	"context"
+	"os"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/uptrace/bun"
+	"go.uber.org/fx"
+	"go.uber.org/zap"
+
+	"saga-pattern/internal/database"
+	"saga-pattern/internal/database/models"
+)
+
+const (
+	// OutboxLease is the name of the lease electing the replica running the outbox relay
+	OutboxLease = "outbox-relay"
+
+	outboxPollInterval = time.Second
+	outboxBatchSize    = 50
+)
+
+// OutboxRelay publishes the rows stored in the outbox table and marks them as delivered.
+// Every replica runs one, the replica holding the lease publishes and the others stand by,
+// so a row is not published by several replicas and the rows keep their order.
+type OutboxRelay struct {
+	db        *bun.DB
+	publisher Publisher
+	logger    *zap.Logger
+	holder    string
+	interval  time.Duration
+	batchSize int
+}
+
+func NewOutboxRelay(logger *zap.Logger, db *bun.DB, publisher Publisher) *OutboxRelay {
+	hostname, _ := os.Hostname()
+
+	return &OutboxRelay{
+		db:        db,
+		publisher: publisher,
+		logger:    logger,
+		holder:    hostname + "-" + uuid.New().String(),
+		interval:  outboxPollInterval,
+		batchSize: outboxBatchSize,
+	}
+}
+
+// Flush publishes a batch of pending outbox rows in insertion order, in a single write to
+// the broker, and returns how many were delivered. When the write fails every row of the
+// batch is retried on the next call. Flush does nothing while another replica holds the lease.
+func (r *OutboxRelay) Flush(ctx context.Context) (int, error) {
+	// The lease outlives a few intervals so a slow flush does not hand it over
+	leader, err := database.AcquireLease(ctx, r.db, OutboxLease, r.holder, 3*r.interval)
+
+	if err != nil || !leader {
+		return 0, err
+	}
+
+	var pending []models.OutboxMessage
+
+	err = r.db.NewSelect().Model(&pending).
+		Where("status = ?", models.OutboxStatusPending).
+		Order("id ASC").
+		Limit(r.batchSize).
+		Scan(ctx)
+
+	if err != nil || len(pending) == 0 {
+		return 0, err
+	}
+
+	ids := make([]int64, len(pending))
+	messages := make([]Message, len(pending))
+
+	for i, row := range pending {
+		ids[i] = row.ID
+		messages[i] = Message{
+			Topic:   row.Topic,
+			Key:     []byte(row.Key),
+			Value:   row.Value,
+			Headers: row.Headers,
+		}
+	}
+
+	if err := r.publisher.Publish(ctx, messages...); err != nil {
+		r.logger.Warn("Failed to publish outbox messages, will retry",
+			zap.Int64("first_id", ids[0]),
+			zap.Int("count", len(ids)),
+			zap.Int("attempts", pending[0].Attempts+1),
+			zap.Error(err))
+
+		_, updateErr := r.db.NewUpdate().Model((*models.OutboxMessage)(nil)).
+			Set("attempts = attempts + 1").
+			Set("last_error = ?", err.Error()).
+			Where("id IN (?)", bun.In(ids)).
+			Exec(ctx)
+
+		if updateErr != nil {
+			return 0, updateErr
+		}
+
+		return 0, err
+	}
+
+	_, err = r.db.NewUpdate().Model((*models.OutboxMessage)(nil)).
+		Set("status = ?", models.OutboxStatusDelivered).
+		Set("delivered_at = ?", time.Now()).
+		Where("id IN (?)", bun.In(ids)).
+		Exec(ctx)
+
+	if err != nil {
+		return 0, err
+	}
+
+	return len(ids), nil
+}
+
+func StartOutboxRelay(lc fx.Lifecycle, relay *OutboxRelay) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+
+	lc.Append(fx.Hook{
+		OnStart: func(context.Context) error {
+			go func() {
+				defer close(done)
+
+				relay.logger.Info("Starting outbox relay")
+
+				ticker := time.NewTicker(relay.interval)
+				defer ticker.Stop()
+
+				for {
+					if _, err := relay.Flush(ctx); err != nil && ctx.Err() == nil {
+						relay.logger.Error("Failed to flush outbox", zap.Error(err))
+					}
+
+					select {
+					case <-ticker.C:
+					case <-ctx.Done():
+						return
+					}
+				}
+			}()
+			return nil
+		},
+		OnStop: func(stopCtx context.Context) error {
+			cancel()
+
+			select {
+			case <-done:
+			case <-stopCtx.Done():
+				return stopCtx.Err()
+			}
+
+			// Publish what was written to the outbox since the last tick before the broker closes
+			_, err := relay.Flush(stopCtx)
+
+			return err
+		},
+	})
+}
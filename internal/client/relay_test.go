@@ -0,0 +1,130 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"go.uber.org/zap"
+
+	"saga-pattern/internal/database"
+	"saga-pattern/internal/database/models"
+)
+
+type fakePublisher struct {
+	messages []Message
+	writes   int
+	err      error
+}
+
+func (p *fakePublisher) Publish(ctx context.Context, messages ...Message) error {
+	if p.err != nil {
+		return p.err
+	}
+
+	p.writes++
+
+	p.messages = append(p.messages, messages...)
+
+	return nil
+}
+
+func (p *fakePublisher) Close() error {
+	return nil
+}
+
+func TestOutboxRelayFlush(t *testing.T) {
+	db := database.NewMockDatabase(t, &models.OutboxMessage{}, &models.Lease{})
+	logger, _ := zap.NewDevelopment()
+	ctx := context.Background()
+
+	for _, key := range []string{"first", "second"} {
+		if err := database.EnqueueOutbox(ctx, db, "orders", key, []byte(key), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	publisher := &fakePublisher{err: errors.New("broker unavailable")}
+	relay := NewOutboxRelay(logger, db, publisher)
+
+	delivered, err := relay.Flush(ctx)
+
+	if err == nil {
+		t.Fatal("expected an error when the writer fails")
+	}
+
+	if delivered != 0 {
+		t.Errorf("expected 0 delivered messages, got %d", delivered)
+	}
+
+	failed := new(models.OutboxMessage)
+
+	if err := db.NewSelect().Model(failed).Where("key = ?", "first").Scan(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if failed.Attempts != 1 || failed.Status != models.OutboxStatusPending {
+		t.Errorf("expected a pending message with 1 attempt, got %+v", failed)
+	}
+
+	publisher.err = nil
+
+	delivered, err = relay.Flush(ctx)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if delivered != 2 {
+		t.Errorf("expected 2 delivered messages, got %d", delivered)
+	}
+
+	if len(publisher.messages) != 2 || string(publisher.messages[0].Key) != "first" {
+		t.Errorf("expected messages to be published in order, got %v", publisher.messages)
+	}
+
+	if publisher.writes != 1 {
+		t.Errorf("expected the batch to be published in a single write, got %d", publisher.writes)
+	}
+
+	pending, err := db.NewSelect().Model((*models.OutboxMessage)(nil)).
+		Where("status = ?", models.OutboxStatusPending).
+		Count(ctx)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if pending != 0 {
+		t.Errorf("expected no pending messages, got %d", pending)
+	}
+}
+
+func TestOutboxRelayPublishesFromTheLeaseHolderOnly(t *testing.T) {
+	db := database.NewMockDatabase(t, &models.OutboxMessage{}, &models.Lease{})
+	logger := zap.NewNop()
+	ctx := context.Background()
+
+	if err := database.EnqueueOutbox(ctx, db, "orders", "order-1", []byte("order-1"), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	leader := &fakePublisher{}
+	standby := &fakePublisher{}
+
+	if delivered, err := NewOutboxRelay(logger, db, leader).Flush(ctx); err != nil || delivered != 1 {
+		t.Fatalf("expected the first relay to take the lease and deliver 1 message, got %d (%v)", delivered, err)
+	}
+
+	if err := database.EnqueueOutbox(ctx, db, "orders", "order-2", []byte("order-2"), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if delivered, err := NewOutboxRelay(logger, db, standby).Flush(ctx); err != nil || delivered != 0 {
+		t.Fatalf("expected the other relay to stand by, got %d (%v)", delivered, err)
+	}
+
+	if len(standby.messages) != 0 {
+		t.Errorf("expected nothing published without the lease, got %v", standby.messages)
+	}
+}
@@ -0,0 +1,158 @@
+package client
+
+import (
+	"context"
+	"maps"
+	"math/rand/v2"
+	"strconv"
+	"time"
+
+	"github.com/uptrace/bun"
+	"go.uber.org/zap"
+
+	"saga-pattern/internal/database"
+)
+
+const (
+	DeadLetterSuffix = ".dlq"
+
+	HeaderDLQOriginalTopic     = "dlq-original-topic"
+	HeaderDLQOriginalPartition = "dlq-original-partition"
+	HeaderDLQOriginalOffset    = "dlq-original-offset"
+	HeaderDLQError             = "dlq-error"
+	HeaderDLQAttempts          = "dlq-attempts"
+)
+
+// RetryPolicy configures how many times a message is handled before it is dead lettered
+// and how long to wait between attempts.
+type RetryPolicy struct {
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	Multiplier     float64
+
+	// Jitter is the fraction (0 to 1) of the backoff that is randomized
+	Jitter float64
+}
+
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:    5,
+	InitialBackoff: 200 * time.Millisecond,
+	MaxBackoff:     10 * time.Second,
+	Multiplier:     2,
+	Jitter:         0.2,
+}
+
+// Backoff returns the wait before the given retry (1 being the first retry)
+func (p RetryPolicy) Backoff(retry int) time.Duration {
+	backoff := float64(p.InitialBackoff)
+
+	for i := 1; i < retry; i++ {
+		backoff *= p.Multiplier
+
+		if backoff >= float64(p.MaxBackoff) {
+			backoff = float64(p.MaxBackoff)
+			break
+		}
+	}
+
+	if p.Jitter > 0 {
+		backoff -= backoff * p.Jitter * rand.Float64()
+	}
+
+	return time.Duration(backoff)
+}
+
+// RetryPolicies holds the retry policy of each event type
+type RetryPolicies map[string]RetryPolicy
+
+func (p RetryPolicies) For(eventType string) RetryPolicy {
+	if policy, ok := p[eventType]; ok {
+		return policy
+	}
+
+	return DefaultRetryPolicy
+}
+
+// Retrier runs message handlers with the retry policy of their event type. Messages
+// that still fail after the last attempt are written to the <topic>.dlq topic through
+// the outbox and reported as handled, so their offset is committed and the partition
+// keeps moving.
+type Retrier struct {
+	db       *bun.DB
+	logger   *zap.Logger
+	policies RetryPolicies
+}
+
+// NewRetrier returns a retrier using policies, the event types missing from it and every
+// type when it is nil use DefaultRetryPolicy.
+func NewRetrier(logger *zap.Logger, db *bun.DB, policies RetryPolicies) *Retrier {
+	return &Retrier{
+		db:       db,
+		logger:   logger,
+		policies: policies,
+	}
+}
+
+// Process returns nil once the message was handled or dead lettered, and an error when
+// it must be redelivered because ctx is done or the dead letter could not be stored.
+func (r *Retrier) Process(ctx context.Context, eventType string, message Message, handle func(ctx context.Context) error) error {
+	policy := r.policies.For(eventType)
+
+	var err error
+	attempts := 0
+
+	for attempts < policy.MaxAttempts {
+		if attempts > 0 {
+			select {
+			case <-time.After(policy.Backoff(attempts)):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+
+		attempts++
+
+		if err = handle(ctx); err == nil {
+			return nil
+		}
+
+		r.logger.Warn("Failed to handle message",
+			zap.String("type", eventType),
+			zap.Int("attempt", attempts),
+			zap.Int("max_attempts", policy.MaxAttempts),
+			zap.Error(err))
+	}
+
+	if dlqErr := r.deadLetter(ctx, message, err, attempts); dlqErr != nil {
+		r.logger.Error("Failed to dead letter message", zap.String("type", eventType), zap.Error(dlqErr))
+		return dlqErr
+	}
+
+	r.logger.Error("Message dead lettered after exhausting retries",
+		zap.String("type", eventType),
+		zap.String("topic", message.Topic+DeadLetterSuffix),
+		zap.Int("attempts", attempts),
+		zap.Error(err))
+
+	return nil
+}
+
+func (r *Retrier) deadLetter(ctx context.Context, message Message, cause error, attempts int) error {
+	headers := maps.Clone(message.Headers)
+
+	if headers == nil {
+		headers = make(map[string]string)
+	}
+
+	headers[HeaderDLQOriginalTopic] = message.Topic
+	headers[HeaderDLQOriginalPartition] = strconv.Itoa(message.Partition)
+	headers[HeaderDLQOriginalOffset] = strconv.FormatInt(message.Offset, 10)
+	headers[HeaderDLQAttempts] = strconv.Itoa(attempts)
+
+	if cause != nil {
+		headers[HeaderDLQError] = cause.Error()
+	}
+
+	return database.EnqueueOutbox(ctx, r.db, message.Topic+DeadLetterSuffix, string(message.Key), message.Value, headers)
+}
@@ -0,0 +1,88 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"saga-pattern/internal/database"
+	"saga-pattern/internal/database/models"
+)
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	policy := RetryPolicy{
+		MaxAttempts:    5,
+		InitialBackoff: 100 * time.Millisecond,
+		MaxBackoff:     300 * time.Millisecond,
+		Multiplier:     2,
+		Jitter:         0.5,
+	}
+
+	tests := []struct {
+		retry int
+		max   time.Duration
+	}{
+		{retry: 1, max: 100 * time.Millisecond},
+		{retry: 2, max: 200 * time.Millisecond},
+		{retry: 3, max: 300 * time.Millisecond},
+		{retry: 10, max: 300 * time.Millisecond},
+	}
+
+	for _, tt := range tests {
+		backoff := policy.Backoff(tt.retry)
+
+		if backoff > tt.max || backoff < tt.max/2 {
+			t.Errorf("retry %d: expected backoff between %v and %v, got %v", tt.retry, tt.max/2, tt.max, backoff)
+		}
+	}
+}
+
+func TestRetrierDeadLettersAfterMaxAttempts(t *testing.T) {
+	db := database.NewMockDatabase(t, &models.OutboxMessage{})
+	logger, _ := zap.NewDevelopment()
+	ctx := context.Background()
+
+	retrier := NewRetrier(logger, db, RetryPolicies{
+		"OrderCreated": {MaxAttempts: 3, Multiplier: 2},
+	})
+
+	message := Message{Topic: "orders", Partition: 2, Offset: 42, Key: []byte("OrderCreated"), Value: []byte("{}")}
+
+	calls := 0
+
+	err := retrier.Process(ctx, "OrderCreated", message, func(ctx context.Context) error {
+		calls++
+		return errors.New("database unavailable")
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if calls != 3 {
+		t.Errorf("expected 3 attempts, got %d", calls)
+	}
+
+	deadLetter := new(models.OutboxMessage)
+
+	if err := db.NewSelect().Model(deadLetter).Where("topic = ?", "orders.dlq").Scan(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	expectedHeaders := map[string]string{
+		HeaderDLQOriginalTopic:     "orders",
+		HeaderDLQOriginalPartition: "2",
+		HeaderDLQOriginalOffset:    "42",
+		HeaderDLQError:             "database unavailable",
+		HeaderDLQAttempts:          "3",
+	}
+
+	for key, value := range expectedHeaders {
+		if deadLetter.Headers[key] != value {
+			t.Errorf("expected header %s=%q, got %q", key, value, deadLetter.Headers[key])
+		}
+	}
+}
@@ -0,0 +1,23 @@
+package database
+
+import (
+	"context"
+
+	"github.com/uptrace/bun"
+
+	"saga-pattern/internal/events"
+)
+
+// EnqueueEvent encodes the event in its envelope and stores it in the outbox, keyed
+// with the aggregate ID picked by events.DefaultPartitionStrategy
+func EnqueueEvent(ctx context.Context, db bun.IDB, topic string, envelope events.Envelope, event events.Event) error {
+	key := events.DefaultPartitionStrategy.Key(envelope, event)
+
+	headers, value, err := events.Encode(envelope, event)
+
+	if err != nil {
+		return err
+	}
+
+	return EnqueueOutbox(ctx, db, topic, key, value, headers)
+}
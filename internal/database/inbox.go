@@ -0,0 +1,48 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/uptrace/bun"
+
+	"saga-pattern/internal/database/models"
+)
+
+// ErrDuplicateMessage is returned by MarkProcessed when the message was already handled
+var ErrDuplicateMessage = errors.New("message already processed")
+
+// InboxKey builds the inbox key of the message that drives the given saga step for an order
+func InboxKey(orderID string, step string) string {
+	return fmt.Sprintf("%s:%s", step, orderID)
+}
+
+// MarkProcessed records the message in the inbox table. It must run in the same bun.Tx
+// as the handler side effects, so a message is only marked once they are committed.
+func MarkProcessed(ctx context.Context, db bun.IDB, key string) error {
+	message := &models.InboxMessage{MessageKey: key}
+
+	res, err := db.NewInsert().Model(message).On("CONFLICT DO NOTHING").Exec(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	affected, err := res.RowsAffected()
+
+	if err != nil {
+		return err
+	}
+
+	if affected == 0 {
+		return ErrDuplicateMessage
+	}
+
+	return nil
+}
+
+// IsProcessed reports whether the message with the given inbox key was already handled
+func IsProcessed(ctx context.Context, db bun.IDB, key string) (bool, error) {
+	return db.NewSelect().Model((*models.InboxMessage)(nil)).Where("message_key = ?", key).Exists(ctx)
+}
@@ -0,0 +1,43 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"github.com/uptrace/bun"
+
+	"saga-pattern/internal/database/models"
+)
+
+// AcquireLease takes or renews the lease with the given name for holder. It reports false
+// while another holder owns an unexpired lease, so a job guarded by it runs on one
+// replica at a time and moves to another one when the holder stops renewing it.
+func AcquireLease(ctx context.Context, db bun.IDB, name string, holder string, ttl time.Duration) (bool, error) {
+	now := time.Now()
+
+	lease := &models.Lease{Name: name, Holder: holder, ExpiresAt: now.Add(ttl)}
+
+	if _, err := db.NewInsert().Model(lease).On("CONFLICT DO NOTHING").Exec(ctx); err != nil {
+		return false, err
+	}
+
+	res, err := db.NewUpdate().Model(lease).
+		Column("holder", "expires_at").
+		WherePK().
+		WhereGroup(" AND ", func(q *bun.UpdateQuery) *bun.UpdateQuery {
+			return q.Where("holder = ?", holder).WhereOr("expires_at < ?", now)
+		}).
+		Exec(ctx)
+
+	if err != nil {
+		return false, err
+	}
+
+	affected, err := res.RowsAffected()
+
+	if err != nil {
+		return false, err
+	}
+
+	return affected == 1, nil
+}
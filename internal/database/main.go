@@ -15,8 +15,6 @@ import (
 	"github.com/uptrace/bun/dialect/pgdialect"
 	"github.com/uptrace/bun/driver/pgdriver"
 
-	"saga-pattern/internal/database/models"
-
 	"github.com/joho/godotenv"
 )
 
@@ -87,30 +85,20 @@ func NewDatabase(log *zap.Logger) (*bun.DB, error) {
 	return db, nil
 }
 
-func runMigrations(ctx context.Context, db *bun.DB, log *zap.Logger) error {
-	log.Info("Running migrations")
-
-	_, err := db.NewCreateTable().Model((*models.Order)(nil)).IfNotExists().Exec(ctx)
-
-	if err != nil {
-		return fmt.Errorf("failed to create Orders table: %w", err)
-	}
-
-	_, err = db.NewCreateTable().Model((*models.Inventory)(nil)).IfNotExists().Exec(ctx)
-
-	if err != nil {
-		return fmt.Errorf("failed to create Inventory table: %w", err)
-	}
-
-	log.Info("Migrations completed")
-
-	return nil
+// Module provides the *bun.DB instance for use in other fx components. It brings the
+// schema the service owns up to date before any of them start and leaves the tables of
+// other services to their own databases.
+func Module(schema Schema) fx.Option {
+	return fx.Module("database",
+		fx.Provide(NewDatabase),
+		fx.Invoke(func(db *bun.DB, log *zap.Logger) error {
+			ctx := context.Background()
+
+			if err := Migrate(ctx, db, schema.Migrations, log); err != nil {
+				return err
+			}
+
+			return schema.Check(ctx, db)
+		}),
+	)
 }
-
-// Module provides the *bun.DB instance for use in other fx components
-var Module = fx.Module("database",
-	fx.Provide(NewDatabase),
-	fx.Invoke(func(db *bun.DB, log *zap.Logger) error {
-		return runMigrations(context.Background(), db, log)
-	}),
-)
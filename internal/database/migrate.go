@@ -0,0 +1,86 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/uptrace/bun"
+	"github.com/uptrace/bun/migrate"
+	"go.uber.org/zap"
+)
+
+const (
+	migrationsTable     = "schema_migrations"
+	migrationLocksTable = "schema_migration_locks"
+)
+
+// NewMigrator returns a migrator that records applied migrations in the schema_migrations
+// table. A migration is only marked as applied or rolled back once its step succeeded.
+func NewMigrator(db *bun.DB, migrations *migrate.Migrations) *migrate.Migrator {
+	return migrate.NewMigrator(db, migrations,
+		migrate.WithTableName(migrationsTable),
+		migrate.WithLocksTableName(migrationLocksTable),
+		migrate.WithMarkAppliedOnSuccess(true),
+	)
+}
+
+// Lock takes the migration lock, waiting while another process holds it. Replicas starting
+// together queue up behind the first one instead of applying the same migration twice.
+func Lock(ctx context.Context, migrator *migrate.Migrator, log *zap.Logger) error {
+	if err := migrator.Init(ctx); err != nil {
+		return fmt.Errorf("failed to create migration tables: %w", err)
+	}
+
+	maxAttempts := 30
+
+	for i := 0; ; i++ {
+		err := migrator.Lock(ctx)
+
+		if err == nil {
+			return nil
+		}
+
+		if i == maxAttempts-1 {
+			return fmt.Errorf("failed to take the migration lock after %d attempts: %w", maxAttempts, err)
+		}
+
+		log.Warn("Migrations are locked, retrying...", zap.Error(err), zap.Int("attempt", i+1))
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(2 * time.Second):
+		}
+	}
+}
+
+// Migrate applies every migration that is not recorded yet while holding the migration lock
+func Migrate(ctx context.Context, db *bun.DB, migrations *migrate.Migrations, log *zap.Logger) error {
+	migrator := NewMigrator(db, migrations)
+
+	if err := Lock(ctx, migrator, log); err != nil {
+		return err
+	}
+
+	defer func() {
+		if err := migrator.Unlock(ctx); err != nil {
+			log.Error("Failed to release the migration lock", zap.Error(err))
+		}
+	}()
+
+	group, err := migrator.Migrate(ctx)
+
+	if err != nil {
+		return fmt.Errorf("failed to apply migrations: %w", err)
+	}
+
+	if group.IsZero() {
+		log.Info("Database schema is up to date")
+		return nil
+	}
+
+	log.Info("Applied migrations", zap.Int64("group", group.ID), zap.String("migrations", group.Migrations.String()))
+
+	return nil
+}
@@ -0,0 +1,71 @@
+package database
+
+import (
+	"context"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"saga-pattern/internal/database/models"
+)
+
+var testSchema = NewSchema(fstest.MapFS{
+	"1_leases.tx.up.sql":   {Data: []byte(`CREATE TABLE "leases" ("name" VARCHAR NOT NULL, "holder" VARCHAR, "expires_at" TIMESTAMPTZ, PRIMARY KEY ("name"));`)},
+	"1_leases.tx.down.sql": {Data: []byte(`DROP TABLE "leases";`)},
+}, "testdata", (*models.Lease)(nil))
+
+func TestMigrateUpAndDown(t *testing.T) {
+	ctx := context.Background()
+	db := NewMockDatabase(t)
+
+	require.Error(t, testSchema.Check(ctx, db), "leases is only created by the migrations")
+
+	require.NoError(t, Migrate(ctx, db, testSchema.Migrations, zap.NewNop()))
+	require.NoError(t, Migrate(ctx, db, testSchema.Migrations, zap.NewNop()), "a second run has nothing to apply")
+	require.NoError(t, testSchema.Check(ctx, db))
+
+	migrator := NewMigrator(db, testSchema.Migrations)
+
+	status, err := migrator.MigrationsWithStatus(ctx)
+	require.NoError(t, err)
+	require.Len(t, status, 1)
+	require.Empty(t, status.Unapplied())
+
+	_, err = migrator.Rollback(ctx)
+	require.NoError(t, err)
+
+	status, err = migrator.MigrationsWithStatus(ctx)
+	require.NoError(t, err)
+	require.Empty(t, status.Applied())
+	require.Error(t, testSchema.Check(ctx, db), "leases is dropped by the down step")
+}
+
+func TestSchemaCheckFindsMissingColumns(t *testing.T) {
+	ctx := context.Background()
+	db := NewMockDatabase(t)
+
+	_, err := db.ExecContext(ctx, `CREATE TABLE "leases" ("name" VARCHAR NOT NULL, PRIMARY KEY ("name"))`)
+	require.NoError(t, err)
+
+	require.Error(t, testSchema.Check(ctx, db))
+}
+
+func TestMigrateWaitsForTheLock(t *testing.T) {
+	db := NewMockDatabase(t)
+
+	migrator := NewMigrator(db, testSchema.Migrations)
+
+	require.NoError(t, Lock(context.Background(), migrator, zap.NewNop()))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	require.ErrorIs(t, Migrate(ctx, db, testSchema.Migrations, zap.NewNop()), context.DeadlineExceeded)
+
+	status, err := migrator.MigrationsWithStatus(context.Background())
+	require.NoError(t, err)
+	require.Empty(t, status.Applied())
+}
@@ -0,0 +1,18 @@
+package models
+
+import (
+	"time"
+
+	"github.com/uptrace/bun"
+)
+
+type InboxMessage struct {
+	bun.BaseModel `bun:"table:inbox,alias:ib"`
+
+	ID int64 `bun:",pk,autoincrement"`
+
+	// Identifies a consumed message, built from the order ID and the saga step
+	MessageKey string `bun:",unique,notnull"`
+
+	ProcessedAt time.Time `bun:",nullzero,notnull,default:current_timestamp"`
+}
@@ -2,10 +2,21 @@ package models
 
 import "github.com/uptrace/bun"
 
+// Inventory is the stock of a product. OnHand is what is in the warehouse and Reserved the
+// part of it held by the orders still in flight, only the rest is available to new orders.
 type Inventory struct {
 	bun.BaseModel `bun:"table:inventory,alias:i"`
 
 	ID        int64 `bun:",pk,autoincrement"`
 	ProductID string
-	Quantity  int64
+	OnHand    int64 `bun:",notnull,default:0"`
+	Reserved  int64 `bun:",notnull,default:0"`
+
+	// Version is bumped by every write, it is served as the ETag of the inventory
+	Version int64 `bun:",nullzero,notnull,default:1"`
+}
+
+// Available returns the stock that can still be reserved
+func (i *Inventory) Available() int64 {
+	return i.OnHand - i.Reserved
 }
@@ -0,0 +1,16 @@
+package models
+
+import (
+	"time"
+
+	"github.com/uptrace/bun"
+)
+
+// Lease elects a single holder among the replicas of a service for a background job
+type Lease struct {
+	bun.BaseModel `bun:"table:leases,alias:l"`
+
+	Name      string `bun:",pk"`
+	Holder    string
+	ExpiresAt time.Time
+}
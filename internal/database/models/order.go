@@ -9,10 +9,14 @@ const (
 	OrderStatusConfirmed
 	OrderStatusCanceled
 	OrderStatusCompleted
+
+	// OrderStatusCancelling is an order canceled by its user, waiting for the participants
+	// to undo their work
+	OrderStatusCancelling
 )
 
 func (o OrderStatus) String() string {
-	return [...]string{"Pending", "Confirmed", "Canceled", "Completed"}[o-1]
+	return [...]string{"Pending", "Confirmed", "Canceled", "Completed", "Cancelling"}[o]
 }
 
 func (o OrderStatus) EnumIndex() int {
@@ -24,16 +28,29 @@ type Order struct {
 
 	ID      int64 `bun:",pk,autoincrement"`
 	OrderID string
-	Price   float64
-
-	// Notice how we avoid the M2M table making an string with the ID of the product
-	ProductID string
-
-	// Quantity of the product
-	Quantity int64
 
 	Status OrderStatus
 
-	// Same for the user, we avoid the One-To-Many making an string with the ID of the user
+	// We avoid the One-To-Many with the users making a field with the ID of the user
 	UserID int64
+
+	// Items are the lines of the order, one per product
+	Items []OrderItem `bun:"rel:has-many,join:order_id=order_id"`
+
+	// Version is bumped by every write, it is served as the ETag of the order
+	Version int64 `bun:",nullzero,notnull,default:1"`
+}
+
+// OrderItem is a line of an order, a quantity of a product and the price of a single unit
+type OrderItem struct {
+	bun.BaseModel `bun:"table:order_items,alias:oi"`
+
+	ID      int64  `bun:",pk,autoincrement"`
+	OrderID string `bun:",notnull,unique:order_product"`
+
+	// Notice how we avoid the M2M table making an string with the ID of the product
+	ProductID string `bun:",notnull,unique:order_product"`
+
+	Quantity int64
+	Price    float64
 }
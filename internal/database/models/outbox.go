@@ -0,0 +1,35 @@
+package models
+
+import (
+	"time"
+
+	"github.com/uptrace/bun"
+)
+
+type OutboxStatus int
+
+const (
+	OutboxStatusPending OutboxStatus = iota
+	OutboxStatusDelivered
+)
+
+type OutboxMessage struct {
+	bun.BaseModel `bun:"table:outbox,alias:ob"`
+
+	ID    int64 `bun:",pk,autoincrement"`
+	Topic string
+	Key   string
+	Value []byte
+
+	// Kafka headers of the message, stored as JSON
+	Headers map[string]string
+
+	Status OutboxStatus
+
+	// Number of failed publish attempts and the error of the last one
+	Attempts  int
+	LastError string
+
+	CreatedAt   time.Time `bun:",nullzero,notnull,default:current_timestamp"`
+	DeliveredAt time.Time `bun:",nullzero"`
+}
@@ -0,0 +1,43 @@
+package models
+
+import (
+	"time"
+
+	"github.com/uptrace/bun"
+)
+
+// Account holds the balance charged for the orders of a user
+type Account struct {
+	bun.BaseModel `bun:"table:accounts,alias:a"`
+
+	ID      int64 `bun:",pk,autoincrement"`
+	UserID  int64 `bun:",unique,notnull"`
+	Balance float64
+
+	// Bumped by every write, charges and refunds included
+	Version int64 `bun:",nullzero,notnull,default:1"`
+}
+
+type PaymentStatus int
+
+const (
+	PaymentStatusCharged PaymentStatus = iota
+	PaymentStatusRefunded
+)
+
+func (s PaymentStatus) String() string {
+	return [...]string{"Charged", "Refunded"}[s]
+}
+
+// Payment is the charge of an order, at most one per order
+type Payment struct {
+	bun.BaseModel `bun:"table:payments,alias:p"`
+
+	ID      int64  `bun:",pk,autoincrement"`
+	OrderID string `bun:",unique,notnull"`
+	UserID  int64
+	Amount  float64
+	Status  PaymentStatus
+
+	CreatedAt time.Time `bun:",nullzero,notnull,default:current_timestamp"`
+}
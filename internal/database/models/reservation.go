@@ -0,0 +1,40 @@
+package models
+
+import (
+	"time"
+
+	"github.com/uptrace/bun"
+)
+
+type ReservationStatus int
+
+const (
+	ReservationStatusReserved ReservationStatus = iota
+	ReservationStatusReleased
+	ReservationStatusCommitted
+	ReservationStatusExpired
+)
+
+func (s ReservationStatus) String() string {
+	return [...]string{"Reserved", "Released", "Committed", "Expired"}[s]
+}
+
+// Reservation is the stock of a product taken by an order, at most one per line of the
+// order. A reservation holds the stock until the order is confirmed, which commits it, or
+// until it is released by a compensation or once it expires. It records when the stock was
+// given back so it is never released twice. An expired reservation no longer holds stock,
+// it is taken again if the order is confirmed after all.
+type Reservation struct {
+	bun.BaseModel `bun:"table:reservations,alias:rs"`
+
+	ID        int64  `bun:",pk,autoincrement"`
+	OrderID   string `bun:",notnull,unique:order_product"`
+	ProductID string `bun:",notnull,unique:order_product"`
+	Quantity  int64
+	Status    ReservationStatus
+
+	ReservedAt  time.Time `bun:",nullzero,notnull,default:current_timestamp"`
+	ExpiresAt   time.Time `bun:",nullzero"`
+	CommittedAt time.Time `bun:",nullzero"`
+	ReleasedAt  time.Time `bun:",nullzero"`
+}
@@ -0,0 +1,103 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/uptrace/bun"
+)
+
+type SagaStatus int
+
+const (
+	SagaStatusRunning SagaStatus = iota
+	SagaStatusCompensating
+	SagaStatusCompleted
+
+	// The saga failed and every completed step was compensated
+	SagaStatusCompensated
+
+	// A step could not be compensated, the saga stopped and needs a manual action
+	SagaStatusFailed
+)
+
+func (s SagaStatus) String() string {
+	return [...]string{"Running", "Compensating", "Completed", "Compensated", "Failed"}[s]
+}
+
+// SagaInstance is the persisted state machine of a saga run by the orchestrator
+type SagaInstance struct {
+	bun.BaseModel `bun:"table:saga_instances,alias:si"`
+
+	ID int64 `bun:",pk,autoincrement"`
+
+	// Same as the ce_sagaid of its events, the order ID for the order saga
+	SagaID string `bun:",unique,notnull"`
+	Name   string
+
+	Status SagaStatus
+
+	// Index of the step waiting for a reply, the step being compensated while compensating
+	CurrentStep int
+
+	// JSON of the event that started the saga, the commands are built from it
+	Payload json.RawMessage
+
+	// Reason given by the participant whose step failed
+	FailureReason string
+
+	// The saga is compensated when it is still running past its deadline
+	Deadline time.Time `bun:",nullzero"`
+
+	// Bumped by every write, so the watchdog and the replies never overwrite each other
+	Version int64 `bun:",nullzero,notnull,default:1"`
+
+	CreatedAt time.Time `bun:",nullzero,notnull,default:current_timestamp"`
+	UpdatedAt time.Time `bun:",nullzero,notnull,default:current_timestamp"`
+
+	// History of the saga, oldest first
+	Steps []*SagaStep `bun:"rel:has-many,join:saga_id=saga_id"`
+}
+
+type SagaStepStatus int
+
+const (
+	SagaStepStatusPending SagaStepStatus = iota
+	SagaStepStatusSucceeded
+	SagaStepStatusFailed
+)
+
+func (s SagaStepStatus) String() string {
+	return [...]string{"Pending", "Succeeded", "Failed"}[s]
+}
+
+// SagaStep records a command sent by the orchestrator and the reply of the participant.
+// A compensation gets its own entry next to the action of the step it undoes.
+type SagaStep struct {
+	bun.BaseModel `bun:"table:saga_steps,alias:ss"`
+
+	ID     int64  `bun:",pk,autoincrement"`
+	SagaID string `bun:",notnull"`
+
+	// Name and position of the step in the saga definition
+	Name         string
+	Position     int
+	Compensation bool
+
+	Status SagaStepStatus
+
+	// Type and JSON of the command, empty for a step done by the event starting the saga
+	Command string
+	Payload json.RawMessage
+
+	// Number of times the command was sent, and when it is sent again without a reply
+	Attempts int
+	Deadline time.Time `bun:",nullzero"`
+
+	// Type and JSON of the reply of the participant
+	Reply        string
+	ReplyPayload json.RawMessage
+
+	StartedAt  time.Time `bun:",nullzero,notnull,default:current_timestamp"`
+	FinishedAt time.Time `bun:",nullzero"`
+}
@@ -0,0 +1,42 @@
+package models
+
+import (
+	"time"
+
+	"github.com/uptrace/bun"
+)
+
+type ShipmentStatus int
+
+const (
+	ShipmentStatusPending ShipmentStatus = iota
+	ShipmentStatusDispatched
+	ShipmentStatusDelivered
+	ShipmentStatusCanceled
+)
+
+func (s ShipmentStatus) String() string {
+	return [...]string{"Pending", "Dispatched", "Delivered", "Canceled"}[s]
+}
+
+// ShipmentItem is a product of a shipment with its quantity
+type ShipmentItem struct {
+	Product  string `json:"product"`
+	Quantity int64  `json:"quantity"`
+}
+
+// Shipment is the delivery of a confirmed order, at most one per order
+type Shipment struct {
+	bun.BaseModel `bun:"table:shipments,alias:sh"`
+
+	ID      int64  `bun:",pk,autoincrement"`
+	OrderID string `bun:",unique,notnull"`
+	UserID  int64
+	Status  ShipmentStatus
+
+	// Items are the products of the order and their quantity, stored as JSON
+	Items []ShipmentItem
+
+	CreatedAt time.Time `bun:",nullzero,notnull,default:current_timestamp"`
+	UpdatedAt time.Time `bun:",nullzero,notnull,default:current_timestamp"`
+}
@@ -0,0 +1,25 @@
+package database
+
+import (
+	"context"
+
+	"github.com/uptrace/bun"
+
+	"saga-pattern/internal/database/models"
+)
+
+// EnqueueOutbox stores a message in the outbox table. Callers pass the bun.Tx
+// that writes the business row so both are committed (or rolled back) together.
+func EnqueueOutbox(ctx context.Context, db bun.IDB, topic string, key string, value []byte, headers map[string]string) error {
+	message := &models.OutboxMessage{
+		Topic:   topic,
+		Key:     key,
+		Value:   value,
+		Headers: headers,
+		Status:  models.OutboxStatusPending,
+	}
+
+	_, err := db.NewInsert().Model(message).Exec(ctx)
+
+	return err
+}
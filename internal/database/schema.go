@@ -0,0 +1,65 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+
+	"github.com/uptrace/bun"
+	"github.com/uptrace/bun/dialect"
+	"github.com/uptrace/bun/migrate"
+)
+
+// Schema is the part of the database a service owns: the models it reads and writes and
+// the migrations creating their tables. A service only migrates and checks its own schema.
+type Schema struct {
+	Models     []interface{}
+	Migrations *migrate.Migrations
+}
+
+// NewSchema discovers the numbered migrations of fsys. Directory is where `migrate create`
+// writes the new ones, relative to the repo root.
+func NewSchema(fsys fs.FS, directory string, models ...interface{}) Schema {
+	migrations := migrate.NewMigrations(migrate.WithMigrationsDirectory(directory))
+
+	if err := migrations.Discover(fsys); err != nil {
+		panic(err)
+	}
+
+	return Schema{Models: models, Migrations: migrations}
+}
+
+// Check selects every column of every model, so a model whose table or columns are
+// missing from the migrations fails at startup instead of on its first query.
+func (s Schema) Check(ctx context.Context, db bun.IDB) error {
+	for _, model := range s.Models {
+		if _, err := db.NewSelect().Model(model).Limit(0).Exec(ctx); err != nil {
+			return fmt.Errorf("schema of %T does not match its migrations: %w", model, err)
+		}
+	}
+
+	return nil
+}
+
+// HasColumn reports whether table exists with column, so a migration can tell the schema it
+// starts from. It is false when the table does not exist.
+func HasColumn(ctx context.Context, db bun.IDB, table string, column string) (bool, error) {
+	var query string
+
+	switch db.Dialect().Name() {
+	case dialect.PG:
+		query = "SELECT count(*) FROM information_schema.columns WHERE table_schema = current_schema() AND table_name = ? AND column_name = ?"
+	case dialect.SQLite:
+		query = "SELECT count(*) FROM pragma_table_info(?) WHERE name = ?"
+	default:
+		return false, fmt.Errorf("no column lookup for the %s dialect", db.Dialect().Name())
+	}
+
+	var count int
+
+	if err := db.NewRaw(query, table, column).Scan(ctx, &count); err != nil {
+		return false, err
+	}
+
+	return count > 0, nil
+}
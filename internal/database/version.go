@@ -0,0 +1,39 @@
+package database
+
+import (
+	"context"
+	"errors"
+
+	"github.com/uptrace/bun"
+)
+
+// ErrVersionMismatch is returned when a versioned row changed since it was read
+var ErrVersionMismatch = errors.New("version mismatch")
+
+// BumpVersion increments the version of the rows updated by query. Every write of a
+// versioned model goes through it, so a reader holding an older version notices the change.
+func BumpVersion(query *bun.UpdateQuery) *bun.UpdateQuery {
+	return query.Set("version = version + 1")
+}
+
+// UpdateVersion applies query to its row only while the row is still at version and bumps
+// the version. It returns ErrVersionMismatch when the row was changed in between.
+func UpdateVersion(ctx context.Context, query *bun.UpdateQuery, version int64) error {
+	res, err := BumpVersion(query).Where("version = ?", version).Exec(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	affected, err := res.RowsAffected()
+
+	if err != nil {
+		return err
+	}
+
+	if affected == 0 {
+		return ErrVersionMismatch
+	}
+
+	return nil
+}
@@ -0,0 +1,104 @@
+// Package etag maps the version of a row to the ETag of the resource it is served as
+package etag
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// ErrMissing is returned when a write does not say which version of the resource it changes
+var ErrMissing = errors.New("If-Match header is required")
+
+// ErrMalformed is returned when the If-Match header is neither * nor a list of entity tags
+var ErrMalformed = errors.New("If-Match header must be * or a list of ETags")
+
+// Format returns the strong ETag of a resource at version
+func Format(version int64) string {
+	return strconv.Quote(strconv.FormatInt(version, 10))
+}
+
+// Set writes the ETag of a resource at version to the response
+func Set(w http.ResponseWriter, version int64) {
+	w.Header().Set("ETag", Format(version))
+}
+
+// Precondition is the If-Match header of a request, * or the versions it accepts
+type Precondition struct {
+	any      bool
+	versions []int64
+}
+
+// Matches reports whether a resource at version satisfies the precondition
+func (p Precondition) Matches(version int64) bool {
+	if p.any {
+		return true
+	}
+
+	for _, v := range p.versions {
+		if v == version {
+			return true
+		}
+	}
+
+	return false
+}
+
+// IfMatch parses the If-Match header of the request as RFC 9110 defines it: * or a comma
+// separated list of entity tags. Tags are compared strongly, so a weak tag or one that is
+// not an ETag of this package is valid but never matches. Only a header that cannot be
+// parsed returns ErrMalformed.
+func IfMatch(r *http.Request) (Precondition, error) {
+	header := strings.TrimSpace(strings.Join(r.Header.Values("If-Match"), ","))
+
+	if header == "" {
+		return Precondition{}, ErrMissing
+	}
+
+	if header == "*" {
+		return Precondition{any: true}, nil
+	}
+
+	var precondition Precondition
+
+	for rest := header; ; {
+		rest = strings.TrimLeft(rest, " \t,")
+
+		if rest == "" {
+			break
+		}
+
+		weak := strings.HasPrefix(rest, "W/")
+		rest = strings.TrimPrefix(rest, "W/")
+
+		if !strings.HasPrefix(rest, `"`) {
+			return Precondition{}, ErrMalformed
+		}
+
+		end := strings.IndexByte(rest[1:], '"')
+
+		if end < 0 {
+			return Precondition{}, ErrMalformed
+		}
+
+		opaque := rest[1 : end+1]
+		rest = strings.TrimLeft(rest[end+2:], " \t")
+
+		if rest != "" && rest[0] != ',' {
+			return Precondition{}, ErrMalformed
+		}
+
+		for i := 0; i < len(opaque); i++ {
+			if c := opaque[i]; c < 0x21 || c == 0x7f {
+				return Precondition{}, ErrMalformed
+			}
+		}
+
+		if version, err := strconv.ParseInt(opaque, 10, 64); err == nil && !weak && opaque == strconv.FormatInt(version, 10) {
+			precondition.versions = append(precondition.versions, version)
+		}
+	}
+
+	return precondition, nil
+}
@@ -0,0 +1,57 @@
+package etag
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+)
+
+func TestIfMatch(t *testing.T) {
+	tests := []struct {
+		name    string
+		header  []string
+		matches []int64
+		misses  []int64
+		err     error
+	}{
+		{name: "missing", err: ErrMissing},
+		{name: "any", header: []string{"*"}, matches: []int64{1, 7}},
+		{name: "strong", header: []string{`"3"`}, matches: []int64{3}, misses: []int64{2}},
+		{name: "list", header: []string{` "1" ,"4",W/"5"`}, matches: []int64{1, 4}, misses: []int64{5}},
+		{name: "several headers", header: []string{`"1"`, `"2"`}, matches: []int64{1, 2}},
+		{name: "weak never matches", header: []string{`W/"3"`}, misses: []int64{3}},
+		{name: "foreign tag never matches", header: []string{`"v3", "03", "a,b"`}, misses: []int64{3}},
+		{name: "unquoted", header: []string{"3"}, err: ErrMalformed},
+		{name: "unterminated", header: []string{`"3`}, err: ErrMalformed},
+		{name: "missing comma", header: []string{`"1" "2"`}, err: ErrMalformed},
+		{name: "any in a list", header: []string{`*, "1"`}, err: ErrMalformed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, _ := http.NewRequest(http.MethodPut, "/", nil)
+
+			for _, value := range tt.header {
+				r.Header.Add("If-Match", value)
+			}
+
+			precondition, err := IfMatch(r)
+
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("expected error %v, got %v", tt.err, err)
+			}
+
+			for _, version := range tt.matches {
+				if !precondition.Matches(version) {
+					t.Errorf("expected version %d to match", version)
+				}
+			}
+
+			for _, version := range tt.misses {
+				if precondition.Matches(version) {
+					t.Errorf("expected version %d not to match", version)
+				}
+			}
+		})
+	}
+}
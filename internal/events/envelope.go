@@ -0,0 +1,172 @@
+package events
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Events travel in CloudEvents binary mode: the attributes are Kafka headers
+// prefixed with ce_ and the message value is the JSON encoded event.
+const (
+	SpecVersion = "1.0"
+	ContentType = "application/json"
+
+	HeaderSpecVersion   = "ce_specversion"
+	HeaderID            = "ce_id"
+	HeaderType          = "ce_type"
+	HeaderSource        = "ce_source"
+	HeaderTime          = "ce_time"
+	HeaderSagaID        = "ce_sagaid"
+	HeaderCorrelationID = "ce_correlationid"
+	HeaderSchemaVersion = "ce_schemaversion"
+	HeaderContentType   = "content-type"
+)
+
+// Sources of the events, one per service
+const (
+	SourceOrders       = "orders-command"
+	SourceInventory    = "inventory-command"
+	SourcePayment      = "payment-command"
+	SourceShipping     = "shipping-command"
+	SourceOrchestrator = "saga-orchestrator"
+)
+
+var (
+	ErrMissingHeader      = errors.New("missing event header")
+	ErrUnexpectedType     = errors.New("unexpected event type")
+	ErrUnsupportedVersion = errors.New("unsupported event schema version")
+)
+
+// Event is implemented by every payload exchanged between the services
+type Event interface {
+	EventType() string
+	SchemaVersion() int
+}
+
+// Envelope holds the attributes shared by every event
+type Envelope struct {
+	ID     string
+	Type   string
+	Source string
+
+	// SagaID groups every event of a saga, it is the order ID for the order saga
+	SagaID string
+
+	// CorrelationID is the ID of the event this one was produced in response to
+	CorrelationID string
+
+	OccurredAt    time.Time
+	SchemaVersion int
+}
+
+// New creates the envelope of an event that starts a saga
+func New(source string, sagaID string, event Event) Envelope {
+	return Envelope{
+		ID:            uuid.New().String(),
+		Type:          event.EventType(),
+		Source:        source,
+		SagaID:        sagaID,
+		OccurredAt:    time.Now().UTC(),
+		SchemaVersion: event.SchemaVersion(),
+	}
+}
+
+// NewFrom creates the envelope of an event produced while handling cause
+func NewFrom(cause Envelope, source string, event Event) Envelope {
+	envelope := New(source, cause.SagaID, event)
+	envelope.CorrelationID = cause.ID
+
+	return envelope
+}
+
+// Encode returns the headers and value of the message carrying the event
+func Encode(envelope Envelope, event Event) (map[string]string, []byte, error) {
+	if envelope.Type != event.EventType() {
+		return nil, nil, fmt.Errorf("%w: envelope is %q, event is %q", ErrUnexpectedType, envelope.Type, event.EventType())
+	}
+
+	value, err := json.Marshal(event)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	headers := map[string]string{
+		HeaderSpecVersion:   SpecVersion,
+		HeaderID:            envelope.ID,
+		HeaderType:          envelope.Type,
+		HeaderSource:        envelope.Source,
+		HeaderTime:          envelope.OccurredAt.Format(time.RFC3339Nano),
+		HeaderSagaID:        envelope.SagaID,
+		HeaderSchemaVersion: strconv.Itoa(envelope.SchemaVersion),
+		HeaderContentType:   ContentType,
+	}
+
+	if envelope.CorrelationID != "" {
+		headers[HeaderCorrelationID] = envelope.CorrelationID
+	}
+
+	return headers, value, nil
+}
+
+// DecodeEnvelope reads the event attributes from the message headers
+func DecodeEnvelope(headers map[string]string) (Envelope, error) {
+	for _, key := range []string{HeaderID, HeaderType, HeaderSource, HeaderTime, HeaderSchemaVersion} {
+		if headers[key] == "" {
+			return Envelope{}, fmt.Errorf("%w: %s", ErrMissingHeader, key)
+		}
+	}
+
+	occurredAt, err := time.Parse(time.RFC3339Nano, headers[HeaderTime])
+
+	if err != nil {
+		return Envelope{}, fmt.Errorf("invalid %s header: %w", HeaderTime, err)
+	}
+
+	schemaVersion, err := strconv.Atoi(headers[HeaderSchemaVersion])
+
+	if err != nil {
+		return Envelope{}, fmt.Errorf("invalid %s header: %w", HeaderSchemaVersion, err)
+	}
+
+	return Envelope{
+		ID:            headers[HeaderID],
+		Type:          headers[HeaderType],
+		Source:        headers[HeaderSource],
+		SagaID:        headers[HeaderSagaID],
+		CorrelationID: headers[HeaderCorrelationID],
+		OccurredAt:    occurredAt,
+		SchemaVersion: schemaVersion,
+	}, nil
+}
+
+// Decode reads a message carrying an event of type T. It fails when the message holds
+// another event type or a schema version newer than the one this service understands.
+func Decode[T Event](headers map[string]string, value []byte) (Envelope, T, error) {
+	var event T
+
+	envelope, err := DecodeEnvelope(headers)
+
+	if err != nil {
+		return envelope, event, err
+	}
+
+	if envelope.Type != event.EventType() {
+		return envelope, event, fmt.Errorf("%w: expected %q, got %q", ErrUnexpectedType, event.EventType(), envelope.Type)
+	}
+
+	if envelope.SchemaVersion > event.SchemaVersion() {
+		return envelope, event, fmt.Errorf("%w: %s v%d", ErrUnsupportedVersion, envelope.Type, envelope.SchemaVersion)
+	}
+
+	if err := json.Unmarshal(value, &event); err != nil {
+		return envelope, event, err
+	}
+
+	return envelope, event, nil
+}
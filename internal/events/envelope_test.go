@@ -0,0 +1,59 @@
+package events
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestEncodeDecode(t *testing.T) {
+	created := OrderCreated{ID: 1, OrderID: "order-1", UserID: 2, Lines: []OrderLine{{Product: "3", Quantity: 4, Price: 5}, {Product: "4", Quantity: 1, Price: 2}}}
+	cause := New(SourceOrders, created.OrderID, created)
+
+	headers, value, err := Encode(cause, created)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	envelope, decoded, err := Decode[OrderCreated](headers, value)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(decoded, created) {
+		t.Errorf("expected %+v, got %+v", created, decoded)
+	}
+
+	if total := decoded.Total(); total != 22 {
+		t.Errorf("expected a total of 22, got %v", total)
+	}
+
+	if envelope.ID != cause.ID || envelope.SagaID != "order-1" || !envelope.OccurredAt.Equal(cause.OccurredAt) {
+		t.Errorf("expected envelope %+v, got %+v", cause, envelope)
+	}
+
+	revert := RevertOrder{OrderID: created.OrderID}
+	reply := NewFrom(envelope, SourceInventory, revert)
+
+	if reply.SagaID != envelope.SagaID || reply.CorrelationID != envelope.ID {
+		t.Errorf("expected reply to keep the saga and correlate to %s, got %+v", envelope.ID, reply)
+	}
+
+	if _, _, err := Decode[RevertOrder](headers, value); !errors.Is(err, ErrUnexpectedType) {
+		t.Errorf("expected ErrUnexpectedType, got %v", err)
+	}
+
+	headers[HeaderSchemaVersion] = "3"
+
+	if _, _, err := Decode[OrderCreated](headers, value); !errors.Is(err, ErrUnsupportedVersion) {
+		t.Errorf("expected ErrUnsupportedVersion, got %v", err)
+	}
+
+	delete(headers, HeaderID)
+
+	if _, _, err := Decode[OrderCreated](headers, value); !errors.Is(err, ErrMissingHeader) {
+		t.Errorf("expected ErrMissingHeader, got %v", err)
+	}
+}
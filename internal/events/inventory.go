@@ -0,0 +1,123 @@
+package events
+
+const (
+	InventoryCreatedType           = "InventoryCreated"
+	ReserveInventoryType           = "ReserveInventory"
+	InventoryReservedType          = "InventoryReserved"
+	InventoryReservationFailedType = "InventoryReservationFailed"
+	ReleaseInventoryType           = "ReleaseInventory"
+	InventoryReleasedType          = "InventoryReleased"
+	InventoryCommitFailedType      = "InventoryCommitFailed"
+)
+
+type InventoryCreated struct {
+	ID       int64  `json:"id"`
+	Product  string `json:"product"`
+	Quantity int64  `json:"quantity"`
+}
+
+func (InventoryCreated) EventType() string  { return InventoryCreatedType }
+func (InventoryCreated) SchemaVersion() int { return 1 }
+
+func (e InventoryCreated) ProductKey() string { return e.Product }
+
+// ReserveInventory asks the inventory service to take the stock of every line of an order,
+// all of them or none
+type ReserveInventory struct {
+	OrderID string      `json:"order_id"`
+	Lines   []OrderLine `json:"lines"`
+}
+
+func (ReserveInventory) EventType() string  { return ReserveInventoryType }
+func (ReserveInventory) SchemaVersion() int { return 2 }
+
+func (e ReserveInventory) OrderKey() string   { return e.OrderID }
+func (e ReserveInventory) ProductKey() string { return firstProduct(e.Lines) }
+
+// InventoryReserved answers ReserveInventory once the stock of every line was taken
+type InventoryReserved struct {
+	OrderID string      `json:"order_id"`
+	Lines   []OrderLine `json:"lines"`
+}
+
+func (InventoryReserved) EventType() string  { return InventoryReservedType }
+func (InventoryReserved) SchemaVersion() int { return 2 }
+
+func (e InventoryReserved) OrderKey() string   { return e.OrderID }
+func (e InventoryReserved) ProductKey() string { return firstProduct(e.Lines) }
+
+// FailedLine is a line of an order whose stock cannot be taken
+type FailedLine struct {
+	Product  string `json:"product"`
+	Quantity int64  `json:"quantity"`
+	Reason   string `json:"reason"`
+}
+
+// InventoryReservationFailed answers ReserveInventory when the stock of a line cannot be
+// taken, nothing is reserved then. Lines lists the lines that failed.
+type InventoryReservationFailed struct {
+	OrderID string       `json:"order_id"`
+	Reason  string       `json:"reason,omitempty"`
+	Lines   []FailedLine `json:"lines,omitempty"`
+}
+
+func (InventoryReservationFailed) EventType() string  { return InventoryReservationFailedType }
+func (InventoryReservationFailed) SchemaVersion() int { return 2 }
+
+func (e InventoryReservationFailed) OrderKey() string { return e.OrderID }
+
+func (e InventoryReservationFailed) ProductKey() string {
+	if len(e.Lines) == 0 {
+		return ""
+	}
+
+	return e.Lines[0].Product
+}
+
+// ReleaseInventory asks the inventory service to give back the stock of an order, it is
+// the compensation of ReserveInventory
+type ReleaseInventory struct {
+	OrderID string      `json:"order_id"`
+	Lines   []OrderLine `json:"lines"`
+	Reason  string      `json:"reason,omitempty"`
+}
+
+func (ReleaseInventory) EventType() string  { return ReleaseInventoryType }
+func (ReleaseInventory) SchemaVersion() int { return 2 }
+
+func (e ReleaseInventory) OrderKey() string   { return e.OrderID }
+func (e ReleaseInventory) ProductKey() string { return firstProduct(e.Lines) }
+
+// InventoryReleased answers ReleaseInventory with the lines whose stock was given back
+type InventoryReleased struct {
+	OrderID string      `json:"order_id"`
+	Lines   []OrderLine `json:"lines"`
+}
+
+func (InventoryReleased) EventType() string  { return InventoryReleasedType }
+func (InventoryReleased) SchemaVersion() int { return 2 }
+
+func (e InventoryReleased) OrderKey() string   { return e.OrderID }
+func (e InventoryReleased) ProductKey() string { return firstProduct(e.Lines) }
+
+// InventoryCommitFailed is published when a confirmed order cannot take its stock, because
+// its reservation expired and the stock went to other orders. Lines lists the lines that
+// failed. It cancels the saga of the order.
+type InventoryCommitFailed struct {
+	OrderID string       `json:"order_id"`
+	Reason  string       `json:"reason,omitempty"`
+	Lines   []FailedLine `json:"lines,omitempty"`
+}
+
+func (InventoryCommitFailed) EventType() string  { return InventoryCommitFailedType }
+func (InventoryCommitFailed) SchemaVersion() int { return 1 }
+
+func (e InventoryCommitFailed) OrderKey() string { return e.OrderID }
+
+func (e InventoryCommitFailed) ProductKey() string {
+	if len(e.Lines) == 0 {
+		return ""
+	}
+
+	return e.Lines[0].Product
+}
@@ -0,0 +1,109 @@
+package events
+
+const (
+	OrderCreatedType      = "OrderCreated"
+	RevertOrderType       = "RevertOrder"
+	OrderRevertedType     = "OrderReverted"
+	OrderRevertFailedType = "OrderRevertFailed"
+	OrderCanceledType     = "OrderCanceled"
+	OrderConfirmedType    = "OrderConfirmed"
+)
+
+// OrderLine is a product of an order, with its quantity and the price of a single unit
+type OrderLine struct {
+	Product  string  `json:"product"`
+	Quantity int64   `json:"quantity"`
+	Price    float64 `json:"price,omitempty"`
+}
+
+// firstProduct keys an event by the product of its first line, so every event of an order
+// of a single product shares its key. It is empty when the event has no lines.
+func firstProduct(lines []OrderLine) string {
+	if len(lines) == 0 {
+		return ""
+	}
+
+	return lines[0].Product
+}
+
+// OrderCreated is published with every line of a new order, it starts its saga
+type OrderCreated struct {
+	ID      int64       `json:"id"`
+	OrderID string      `json:"order_id"`
+	UserID  int64       `json:"user_id"`
+	Lines   []OrderLine `json:"lines"`
+}
+
+func (OrderCreated) EventType() string  { return OrderCreatedType }
+func (OrderCreated) SchemaVersion() int { return 2 }
+
+func (e OrderCreated) OrderKey() string   { return e.OrderID }
+func (e OrderCreated) ProductKey() string { return firstProduct(e.Lines) }
+
+// Total returns the price of every unit of every line of the order
+func (e OrderCreated) Total() float64 {
+	total := 0.0
+
+	for _, line := range e.Lines {
+		total += line.Price * float64(line.Quantity)
+	}
+
+	return total
+}
+
+// RevertOrder asks the orders service to cancel an order that cannot be fulfilled,
+// it is the compensation of the order creation
+type RevertOrder struct {
+	OrderID string `json:"order_id"`
+	Reason  string `json:"reason,omitempty"`
+}
+
+func (RevertOrder) EventType() string  { return RevertOrderType }
+func (RevertOrder) SchemaVersion() int { return 1 }
+
+func (e RevertOrder) OrderKey() string { return e.OrderID }
+
+// OrderReverted answers RevertOrder once the order is canceled
+type OrderReverted struct {
+	OrderID string `json:"order_id"`
+}
+
+func (OrderReverted) EventType() string  { return OrderRevertedType }
+func (OrderReverted) SchemaVersion() int { return 1 }
+
+func (e OrderReverted) OrderKey() string { return e.OrderID }
+
+// OrderRevertFailed answers RevertOrder when the order was completed in the meantime, its
+// goods were delivered and it cannot be canceled anymore
+type OrderRevertFailed struct {
+	OrderID string `json:"order_id"`
+	Reason  string `json:"reason,omitempty"`
+}
+
+func (OrderRevertFailed) EventType() string  { return OrderRevertFailedType }
+func (OrderRevertFailed) SchemaVersion() int { return 1 }
+
+func (e OrderRevertFailed) OrderKey() string { return e.OrderID }
+
+// OrderCanceled is published when a user cancels an order, the participants undo their work
+// and the order is canceled once the last compensation is done
+type OrderCanceled struct {
+	OrderID string `json:"order_id"`
+	Reason  string `json:"reason,omitempty"`
+}
+
+func (OrderCanceled) EventType() string  { return OrderCanceledType }
+func (OrderCanceled) SchemaVersion() int { return 1 }
+
+func (e OrderCanceled) OrderKey() string { return e.OrderID }
+
+// OrderConfirmed is published once the user of an order is charged, the stock reserved for
+// the order is then sold
+type OrderConfirmed struct {
+	OrderID string `json:"order_id"`
+}
+
+func (OrderConfirmed) EventType() string  { return OrderConfirmedType }
+func (OrderConfirmed) SchemaVersion() int { return 1 }
+
+func (e OrderConfirmed) OrderKey() string { return e.OrderID }
@@ -0,0 +1,59 @@
+package events
+
+import "os"
+
+// PartitionStrategy decides which aggregate ID is used as the Kafka message key, so
+// every event of the same aggregate lands in the same partition and keeps its order.
+type PartitionStrategy string
+
+const (
+	PartitionByOrderID   PartitionStrategy = "order_id"
+	PartitionByProductID PartitionStrategy = "product_id"
+)
+
+// DefaultPartitionStrategy is read from PARTITION_STRATEGY and defaults to the order ID
+var DefaultPartitionStrategy = partitionStrategyFromEnv()
+
+func partitionStrategyFromEnv() PartitionStrategy {
+	if strategy := PartitionStrategy(os.Getenv("PARTITION_STRATEGY")); strategy == PartitionByProductID {
+		return strategy
+	}
+
+	return PartitionByOrderID
+}
+
+// OrderAggregate is implemented by events that belong to an order
+type OrderAggregate interface {
+	OrderKey() string
+}
+
+// ProductAggregate is implemented by events that belong to a product. The events of an order
+// return the product of its first line and an empty key when they carry no line.
+type ProductAggregate interface {
+	ProductKey() string
+}
+
+// Key returns the message key of the event. Events that do not carry the aggregate ID
+// of the strategy fall back to the other one, and finally to the saga ID.
+func (s PartitionStrategy) Key(envelope Envelope, event Event) string {
+	var orderKey, productKey string
+
+	if order, ok := event.(OrderAggregate); ok {
+		orderKey = order.OrderKey()
+	}
+
+	if product, ok := event.(ProductAggregate); ok {
+		productKey = product.ProductKey()
+	}
+
+	switch {
+	case s == PartitionByProductID && productKey != "":
+		return productKey
+	case orderKey != "":
+		return orderKey
+	case productKey != "":
+		return productKey
+	default:
+		return envelope.SagaID
+	}
+}
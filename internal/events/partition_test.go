@@ -0,0 +1,89 @@
+package events
+
+import "testing"
+
+func TestPartitionStrategyKey(t *testing.T) {
+	created := OrderCreated{OrderID: "order-1", Lines: []OrderLine{{Product: "product-1", Quantity: 1}}}
+	revert := RevertOrder{OrderID: "order-1"}
+	inventory := InventoryCreated{Product: "product-2"}
+
+	tests := []struct {
+		name     string
+		strategy PartitionStrategy
+		event    Event
+		expected string
+	}{
+		{name: "order strategy uses the order ID", strategy: PartitionByOrderID, event: created, expected: "order-1"},
+		{name: "product strategy uses the product ID", strategy: PartitionByProductID, event: inventory, expected: "product-2"},
+		{name: "product strategy keys the orders by their first product", strategy: PartitionByProductID, event: created, expected: "product-1"},
+		{name: "product strategy falls back to the order ID", strategy: PartitionByProductID, event: revert, expected: "order-1"},
+		{name: "product strategy falls back to the order ID without lines", strategy: PartitionByProductID, event: InventoryReleased{OrderID: "order-1"}, expected: "order-1"},
+		{name: "order strategy falls back to the product ID", strategy: PartitionByOrderID, event: inventory, expected: "product-2"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key := tt.strategy.Key(New(SourceOrders, "saga-1", tt.event), tt.event)
+
+			if key != tt.expected {
+				t.Errorf("expected key %q, got %q", tt.expected, key)
+			}
+		})
+	}
+}
+
+func TestPartitionStrategyKeyOfEveryEvent(t *testing.T) {
+	lines := []OrderLine{{Product: "product-1", Quantity: 1}, {Product: "product-2", Quantity: 2}}
+
+	// product is the key of the event under the product strategy, the order ID when the
+	// event belongs to no product
+	tests := []struct {
+		event   Event
+		product string
+	}{
+		{event: InventoryCreated{Product: "product-1"}, product: "product-1"},
+		{event: ReserveInventory{OrderID: "order-1", Lines: lines}, product: "product-1"},
+		{event: InventoryReserved{OrderID: "order-1", Lines: lines}, product: "product-1"},
+		{event: InventoryReservationFailed{OrderID: "order-1", Lines: []FailedLine{{Product: "product-1", Quantity: 1}}}, product: "product-1"},
+		{event: ReleaseInventory{OrderID: "order-1", Lines: lines}, product: "product-1"},
+		{event: InventoryReleased{OrderID: "order-1", Lines: lines}, product: "product-1"},
+		{event: InventoryCommitFailed{OrderID: "order-1", Lines: []FailedLine{{Product: "product-1", Quantity: 1}}}, product: "product-1"},
+		{event: OrderCreated{OrderID: "order-1", Lines: lines}, product: "product-1"},
+		{event: RevertOrder{OrderID: "order-1"}, product: "order-1"},
+		{event: OrderReverted{OrderID: "order-1"}, product: "order-1"},
+		{event: OrderRevertFailed{OrderID: "order-1"}, product: "order-1"},
+		{event: OrderCanceled{OrderID: "order-1"}, product: "order-1"},
+		{event: OrderConfirmed{OrderID: "order-1"}, product: "order-1"},
+		{event: ChargePayment{OrderID: "order-1"}, product: "order-1"},
+		{event: PaymentSucceeded{OrderID: "order-1"}, product: "order-1"},
+		{event: PaymentFailed{OrderID: "order-1"}, product: "order-1"},
+		{event: RefundPayment{OrderID: "order-1"}, product: "order-1"},
+		{event: PaymentRefunded{OrderID: "order-1"}, product: "order-1"},
+		{event: CreateShipment{OrderID: "order-1", Lines: lines}, product: "product-1"},
+		{event: ShipmentDispatched{OrderID: "order-1"}, product: "order-1"},
+		{event: ShipmentDelivered{OrderID: "order-1"}, product: "order-1"},
+		{event: CancelShipment{OrderID: "order-1"}, product: "order-1"},
+		{event: ShipmentCanceled{OrderID: "order-1"}, product: "order-1"},
+		{event: ShipmentCancelFailed{OrderID: "order-1"}, product: "order-1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.event.EventType(), func(t *testing.T) {
+			envelope := New(SourceOrders, "saga-1", tt.event)
+
+			order := "order-1"
+
+			if _, ok := tt.event.(OrderAggregate); !ok {
+				order = tt.product
+			}
+
+			if key := PartitionByOrderID.Key(envelope, tt.event); key != order {
+				t.Errorf("expected key %q by order, got %q", order, key)
+			}
+
+			if key := PartitionByProductID.Key(envelope, tt.event); key != tt.product {
+				t.Errorf("expected key %q by product, got %q", tt.product, key)
+			}
+		})
+	}
+}
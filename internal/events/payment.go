@@ -0,0 +1,70 @@
+package events
+
+const (
+	ChargePaymentType    = "ChargePayment"
+	PaymentSucceededType = "PaymentSucceeded"
+	PaymentFailedType    = "PaymentFailed"
+	RefundPaymentType    = "RefundPayment"
+	PaymentRefundedType  = "PaymentRefunded"
+)
+
+// ChargePayment asks the payment service to charge the user of an order
+type ChargePayment struct {
+	OrderID string  `json:"order_id"`
+	UserID  int64   `json:"user_id"`
+	Amount  float64 `json:"amount"`
+}
+
+func (ChargePayment) EventType() string  { return ChargePaymentType }
+func (ChargePayment) SchemaVersion() int { return 1 }
+
+func (e ChargePayment) OrderKey() string { return e.OrderID }
+
+// PaymentSucceeded answers ChargePayment once the user was charged
+type PaymentSucceeded struct {
+	OrderID string  `json:"order_id"`
+	UserID  int64   `json:"user_id"`
+	Amount  float64 `json:"amount"`
+}
+
+func (PaymentSucceeded) EventType() string  { return PaymentSucceededType }
+func (PaymentSucceeded) SchemaVersion() int { return 1 }
+
+func (e PaymentSucceeded) OrderKey() string { return e.OrderID }
+
+// PaymentFailed answers ChargePayment when the user cannot be charged
+type PaymentFailed struct {
+	OrderID string `json:"order_id"`
+	UserID  int64  `json:"user_id"`
+	Reason  string `json:"reason,omitempty"`
+}
+
+func (PaymentFailed) EventType() string  { return PaymentFailedType }
+func (PaymentFailed) SchemaVersion() int { return 1 }
+
+func (e PaymentFailed) OrderKey() string { return e.OrderID }
+
+// RefundPayment asks the payment service to give back the charge of an order, it is the
+// compensation of ChargePayment
+type RefundPayment struct {
+	OrderID string `json:"order_id"`
+	UserID  int64  `json:"user_id"`
+	Reason  string `json:"reason,omitempty"`
+}
+
+func (RefundPayment) EventType() string  { return RefundPaymentType }
+func (RefundPayment) SchemaVersion() int { return 1 }
+
+func (e RefundPayment) OrderKey() string { return e.OrderID }
+
+// PaymentRefunded answers RefundPayment once the order is not charged anymore
+type PaymentRefunded struct {
+	OrderID string  `json:"order_id"`
+	UserID  int64   `json:"user_id"`
+	Amount  float64 `json:"amount"`
+}
+
+func (PaymentRefunded) EventType() string  { return PaymentRefundedType }
+func (PaymentRefunded) SchemaVersion() int { return 1 }
+
+func (e PaymentRefunded) OrderKey() string { return e.OrderID }
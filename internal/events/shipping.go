@@ -0,0 +1,79 @@
+package events
+
+const (
+	CreateShipmentType       = "CreateShipment"
+	ShipmentDispatchedType   = "ShipmentDispatched"
+	ShipmentDeliveredType    = "ShipmentDelivered"
+	CancelShipmentType       = "CancelShipment"
+	ShipmentCanceledType     = "ShipmentCanceled"
+	ShipmentCancelFailedType = "ShipmentCancelFailed"
+)
+
+// CreateShipment asks the shipping service to ship a confirmed order
+type CreateShipment struct {
+	OrderID string      `json:"order_id"`
+	UserID  int64       `json:"user_id"`
+	Lines   []OrderLine `json:"lines"`
+}
+
+func (CreateShipment) EventType() string  { return CreateShipmentType }
+func (CreateShipment) SchemaVersion() int { return 2 }
+
+func (e CreateShipment) OrderKey() string   { return e.OrderID }
+func (e CreateShipment) ProductKey() string { return firstProduct(e.Lines) }
+
+// ShipmentDispatched is published once the shipment of an order left the warehouse
+type ShipmentDispatched struct {
+	OrderID string `json:"order_id"`
+}
+
+func (ShipmentDispatched) EventType() string  { return ShipmentDispatchedType }
+func (ShipmentDispatched) SchemaVersion() int { return 1 }
+
+func (e ShipmentDispatched) OrderKey() string { return e.OrderID }
+
+// ShipmentDelivered answers CreateShipment once the order reached its user
+type ShipmentDelivered struct {
+	OrderID string `json:"order_id"`
+}
+
+func (ShipmentDelivered) EventType() string  { return ShipmentDeliveredType }
+func (ShipmentDelivered) SchemaVersion() int { return 1 }
+
+func (e ShipmentDelivered) OrderKey() string { return e.OrderID }
+
+// CancelShipment asks the shipping service to stop the shipment of an order, it is the
+// compensation of CreateShipment
+type CancelShipment struct {
+	OrderID string `json:"order_id"`
+	Reason  string `json:"reason,omitempty"`
+}
+
+func (CancelShipment) EventType() string  { return CancelShipmentType }
+func (CancelShipment) SchemaVersion() int { return 1 }
+
+func (e CancelShipment) OrderKey() string { return e.OrderID }
+
+// ShipmentCanceled is published when the shipment of an order is canceled, either from the
+// shipping API or to answer CancelShipment
+type ShipmentCanceled struct {
+	OrderID string `json:"order_id"`
+	Reason  string `json:"reason,omitempty"`
+}
+
+func (ShipmentCanceled) EventType() string  { return ShipmentCanceledType }
+func (ShipmentCanceled) SchemaVersion() int { return 1 }
+
+func (e ShipmentCanceled) OrderKey() string { return e.OrderID }
+
+// ShipmentCancelFailed answers CancelShipment when the shipment was already delivered, the
+// goods are with the user and the order cannot be compensated without a manual action
+type ShipmentCancelFailed struct {
+	OrderID string `json:"order_id"`
+	Reason  string `json:"reason,omitempty"`
+}
+
+func (ShipmentCancelFailed) EventType() string  { return ShipmentCancelFailedType }
+func (ShipmentCancelFailed) SchemaVersion() int { return 1 }
+
+func (e ShipmentCancelFailed) OrderKey() string { return e.OrderID }
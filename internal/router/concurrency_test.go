@@ -0,0 +1,186 @@
+package router
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"sync"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"saga-pattern/internal/client"
+	"saga-pattern/internal/events"
+)
+
+func TestRunPreservesOrderPerKey(t *testing.T) {
+	logger := zap.NewNop()
+	broker := client.NewMemoryBroker(2)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	const orders = 5
+	const eventsPerOrder = 20
+
+	for i := 0; i < eventsPerOrder; i++ {
+		for order := 0; order < orders; order++ {
+			orderID := fmt.Sprintf("order-%d", order)
+			event := events.OrderCreated{OrderID: orderID, ID: int64(i)}
+			message := newMessage(t, event)
+			message.Key = []byte(orderID)
+
+			if err := broker.Publish(ctx, message); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	subscriber := broker.Subscribe("inventory-service", "orders")
+	outputChan := make(client.MessageChan)
+	api := client.NewAPI(logger, outputChan, subscriber)
+
+	go func() {
+		for {
+			message, err := subscriber.Fetch(ctx)
+
+			if err != nil {
+				return
+			}
+
+			select {
+			case outputChan <- message:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	var mu sync.Mutex
+	seen := make(map[string][]int64)
+	handled := make(chan struct{}, orders*eventsPerOrder)
+
+	r := New(logger, WithConcurrency(3, 2))
+
+	Handle(r, func(ctx context.Context, event events.OrderCreated) error {
+		time.Sleep(time.Duration(rand.IntN(200)) * time.Microsecond)
+
+		mu.Lock()
+		seen[event.OrderID] = append(seen[event.OrderID], event.ID)
+		mu.Unlock()
+
+		handled <- struct{}{}
+		return nil
+	})
+
+	done := make(chan struct{})
+
+	go func() {
+		r.Run(ctx, api)
+		close(done)
+	}()
+
+	for i := 0; i < orders*eventsPerOrder; i++ {
+		select {
+		case <-handled:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out after %d handled messages", i)
+		}
+	}
+
+	cancel()
+	<-done
+
+	for orderID, quantities := range seen {
+		for i, quantity := range quantities {
+			if quantity != int64(i) {
+				t.Fatalf("expected events of %s in order, got %v", orderID, quantities)
+			}
+		}
+	}
+
+	committed := int64(0)
+
+	for partition := 0; partition < 2; partition++ {
+		committed += broker.Committed("inventory-service", "orders", partition)
+	}
+
+	if committed != orders*eventsPerOrder {
+		t.Errorf("expected every offset to be committed, got %d", committed)
+	}
+}
+
+func TestRunRetriesFailedMessagesBeforeTheNextOfTheirKey(t *testing.T) {
+	logger := zap.NewNop()
+	broker := client.NewMemoryBroker(1)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	for i := 0; i < 5; i++ {
+		message := newMessage(t, events.OrderCreated{OrderID: "order-1", ID: int64(i)})
+		message.Key = []byte("order-1")
+
+		if err := broker.Publish(ctx, message); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	subscriber := broker.Subscribe("inventory-service", "orders")
+	outputChan := make(client.MessageChan, 5)
+	api := client.NewAPI(logger, outputChan, subscriber)
+
+	for i := 0; i < 5; i++ {
+		message, err := subscriber.Fetch(ctx)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		outputChan <- message
+	}
+
+	var seen []int64
+	failures := 2
+	handled := make(chan struct{}, 5)
+
+	r := New(logger, WithConcurrency(1, 5), WithRedeliveryBackoff(time.Millisecond))
+
+	Handle(r, func(ctx context.Context, event events.OrderCreated) error {
+		if event.ID == 0 && failures > 0 {
+			failures--
+			return fmt.Errorf("transient failure")
+		}
+
+		seen = append(seen, event.ID)
+		handled <- struct{}{}
+		return nil
+	})
+
+	done := make(chan struct{})
+
+	go func() {
+		r.Run(ctx, api)
+		close(done)
+	}()
+
+	for i := 0; i < 5; i++ {
+		select {
+		case <-handled:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out after %d handled messages", i)
+		}
+	}
+
+	cancel()
+	<-done
+
+	for i, id := range seen {
+		if id != int64(i) {
+			t.Fatalf("expected the failed message to be handled before the next ones, got %v", seen)
+		}
+	}
+
+	if committed := broker.Committed("inventory-service", "orders", 0); committed != 5 {
+		t.Errorf("expected every offset to be committed, got %d", committed)
+	}
+}
@@ -0,0 +1,50 @@
+package router
+
+import (
+	"context"
+
+	"github.com/uptrace/bun"
+	"go.uber.org/fx"
+	"go.uber.org/zap"
+
+	"saga-pattern/internal/client"
+)
+
+// Start runs r on the messages of api for the lifetime of the fx app, once the database
+// answers. Stopping the app stops reading and waits for the messages in flight to be
+// handled and committed, up to the deadline of the stop.
+func Start(ctx context.Context, lc fx.Lifecycle, logger *zap.Logger, db *bun.DB, api client.API, r *Router) {
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+
+	lc.Append(fx.Hook{
+		OnStart: func(context.Context) error {
+			go func() {
+				defer close(done)
+
+				logger.Info("Starting Kafka message listener")
+
+				if err := db.PingContext(ctx); err != nil {
+					logger.Error("Database connection is not healthy", zap.Error(err))
+					return
+				}
+				logger.Info("Database connection verified")
+
+				r.Run(ctx, api)
+
+				logger.Info("Stopping Kafka message listener")
+			}()
+			return nil
+		},
+		OnStop: func(stopCtx context.Context) error {
+			cancel()
+
+			select {
+			case <-done:
+				return nil
+			case <-stopCtx.Done():
+				return stopCtx.Err()
+			}
+		},
+	})
+}
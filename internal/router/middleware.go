@@ -0,0 +1,164 @@
+package router
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/uptrace/bun"
+	"go.uber.org/zap"
+
+	"saga-pattern/internal/client"
+	"saga-pattern/internal/database"
+	"saga-pattern/internal/events"
+)
+
+// Logging logs every message with the outcome and duration of its handler
+func Logging(logger *zap.Logger) Middleware {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, message client.Message) error {
+			start := time.Now()
+
+			logger.Info("Received message",
+				zap.String("topic", message.Topic),
+				zap.String("key", string(message.Key)),
+				zap.String("type", EventType(message)),
+				zap.Int("partition", message.Partition),
+				zap.Int64("offset", message.Offset))
+
+			err := next(ctx, message)
+
+			fields := []zap.Field{
+				zap.String("type", EventType(message)),
+				zap.Duration("duration", time.Since(start)),
+			}
+
+			if err != nil {
+				logger.Error("Message handler failed", append(fields, zap.Error(err))...)
+			} else {
+				logger.Info("Message handled", fields...)
+			}
+
+			return err
+		}
+	}
+}
+
+// Recover turns a panic in the handler into an error
+func Recover(logger *zap.Logger) Middleware {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, message client.Message) (err error) {
+			defer func() {
+				if recovered := recover(); recovered != nil {
+					logger.Error("Recovered from panic in message handler",
+						zap.String("type", EventType(message)),
+						zap.Any("panic", recovered),
+						zap.ByteString("stack", debug.Stack()))
+
+					err = fmt.Errorf("panic handling %s: %v", EventType(message), recovered)
+				}
+			}()
+
+			return next(ctx, message)
+		}
+	}
+}
+
+// Metrics counts the handled and failed messages of each event type
+type Metrics struct {
+	mu       sync.Mutex
+	handled  map[string]int64
+	failed   map[string]int64
+	duration map[string]time.Duration
+}
+
+// MetricsSnapshot is a copy of the counters of an event type
+type MetricsSnapshot struct {
+	Handled  int64
+	Failed   int64
+	Duration time.Duration
+}
+
+func NewMetrics() *Metrics {
+	return &Metrics{
+		handled:  make(map[string]int64),
+		failed:   make(map[string]int64),
+		duration: make(map[string]time.Duration),
+	}
+}
+
+func (m *Metrics) Snapshot(eventType string) MetricsSnapshot {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return MetricsSnapshot{
+		Handled:  m.handled[eventType],
+		Failed:   m.failed[eventType],
+		Duration: m.duration[eventType],
+	}
+}
+
+func (m *Metrics) Middleware() Middleware {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, message client.Message) error {
+			start := time.Now()
+			err := next(ctx, message)
+			eventType := EventType(message)
+
+			m.mu.Lock()
+			defer m.mu.Unlock()
+
+			m.duration[eventType] += time.Since(start)
+
+			if err != nil {
+				m.failed[eventType]++
+			} else {
+				m.handled[eventType]++
+			}
+
+			return err
+		}
+	}
+}
+
+// Retry runs the handler with the retry policy of the event type and dead letters
+// the messages that keep failing
+func Retry(retrier *client.Retrier) Middleware {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, message client.Message) error {
+			return retrier.Process(ctx, EventType(message), message, func(ctx context.Context) error {
+				return next(ctx, message)
+			})
+		}
+	}
+}
+
+// Deduplicate skips the messages whose saga step is already recorded in the inbox.
+// Handlers still call database.MarkProcessed in their transaction, this only avoids
+// running them again for messages that are known duplicates.
+func Deduplicate(db *bun.DB, logger *zap.Logger) Middleware {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, message client.Message) error {
+			sagaID := message.Headers[events.HeaderSagaID]
+
+			if sagaID == "" {
+				return next(ctx, message)
+			}
+
+			processed, err := database.IsProcessed(ctx, db, database.InboxKey(sagaID, EventType(message)))
+
+			if err != nil {
+				return err
+			}
+
+			if processed {
+				logger.Info("Skipping duplicate message", zap.String("type", EventType(message)), zap.String("saga_id", sagaID))
+				return nil
+			}
+
+			return next(ctx, message)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package router
+
+import (
+	"context"
+	"sync"
+
+	"go.uber.org/zap"
+
+	"saga-pattern/internal/client"
+)
+
+// offsetTracker commits the offsets of messages handled out of order by the workers.
+// The offset of a partition only moves up to the oldest message still in flight, so
+// a restart never skips a message that was not handled yet.
+type offsetTracker struct {
+	mu         sync.Mutex
+	api        client.API
+	logger     *zap.Logger
+	partitions map[partitionKey]*partitionOffsets
+}
+
+type partitionKey struct {
+	topic     string
+	partition int
+}
+
+type partitionOffsets struct {
+	// pending holds the in flight offsets in the order they were read
+	pending  []int64
+	inflight map[int64]bool
+	done     map[int64]client.Message
+}
+
+func newOffsetTracker(api client.API, logger *zap.Logger) *offsetTracker {
+	return &offsetTracker{
+		api:        api,
+		logger:     logger,
+		partitions: make(map[partitionKey]*partitionOffsets),
+	}
+}
+
+func (t *offsetTracker) partition(message client.Message) *partitionOffsets {
+	key := partitionKey{topic: message.Topic, partition: message.Partition}
+	offsets, ok := t.partitions[key]
+
+	if !ok {
+		offsets = &partitionOffsets{
+			inflight: make(map[int64]bool),
+			done:     make(map[int64]client.Message),
+		}
+		t.partitions[key] = offsets
+	}
+
+	return offsets
+}
+
+// track registers a message read from the API
+func (t *offsetTracker) track(message client.Message) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	offsets := t.partition(message)
+
+	if offsets.inflight[message.Offset] {
+		return
+	}
+
+	offsets.inflight[message.Offset] = true
+	offsets.pending = append(offsets.pending, message.Offset)
+}
+
+// complete records a handled message and commits every contiguous handled offset
+func (t *offsetTracker) complete(ctx context.Context, message client.Message) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	offsets := t.partition(message)
+	offsets.done[message.Offset] = message
+
+	var last *client.Message
+
+	for len(offsets.pending) > 0 {
+		head, ok := offsets.done[offsets.pending[0]]
+
+		if !ok {
+			break
+		}
+
+		last = &head
+		delete(offsets.done, head.Offset)
+		delete(offsets.inflight, head.Offset)
+		offsets.pending = offsets.pending[1:]
+	}
+
+	if last == nil {
+		return
+	}
+
+	if err := t.api.Ack(ctx, *last); err != nil {
+		t.logger.Error("Failed to acknowledge message", zap.Error(err))
+	}
+}
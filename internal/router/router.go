@@ -0,0 +1,304 @@
+package router
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"saga-pattern/internal/client"
+	"saga-pattern/internal/events"
+)
+
+var ErrUnknownEventType = errors.New("unknown event type")
+
+// Handler handles a raw message, middlewares wrap it
+type Handler func(ctx context.Context, message client.Message) error
+
+type Middleware func(next Handler) Handler
+
+// UnknownPolicy decides what happens to messages without a registered handler
+type UnknownPolicy int
+
+const (
+	// IgnoreUnknown logs the message and acknowledges it
+	IgnoreUnknown UnknownPolicy = iota
+
+	// RejectUnknown fails the message with ErrUnknownEventType so it goes
+	// through the retry middleware and ends up in the dead letter topic
+	RejectUnknown
+)
+
+const (
+	DefaultWorkers   = 4
+	DefaultQueueSize = 16
+
+	// DefaultRedeliveryBackoff is the wait before a worker handles a failed message again
+	DefaultRedeliveryBackoff = time.Second
+)
+
+var listener_workers = os.Getenv("LISTENER_WORKERS")
+
+// defaultWorkers reads the number of workers from LISTENER_WORKERS
+func defaultWorkers() int {
+	if workers, err := strconv.Atoi(listener_workers); err == nil && workers > 0 {
+		return workers
+	}
+
+	return DefaultWorkers
+}
+
+type Router struct {
+	handlers    map[string]Handler
+	middlewares []Middleware
+	unknown     UnknownPolicy
+	logger      *zap.Logger
+
+	workers   int
+	queueSize int
+	backoff   time.Duration
+}
+
+type Option func(r *Router)
+
+func WithUnknownPolicy(policy UnknownPolicy) Option {
+	return func(r *Router) {
+		r.unknown = policy
+	}
+}
+
+// WithConcurrency sets the number of workers and the size of their queues. Messages
+// are sharded by key, so the messages of an aggregate are handled in order by one
+// worker while different aggregates are handled in parallel.
+func WithConcurrency(workers int, queueSize int) Option {
+	return func(r *Router) {
+		if workers > 0 {
+			r.workers = workers
+		}
+
+		if queueSize > 0 {
+			r.queueSize = queueSize
+		}
+	}
+}
+
+// WithRedeliveryBackoff sets the wait before a worker handles a failed message again
+func WithRedeliveryBackoff(backoff time.Duration) Option {
+	return func(r *Router) {
+		if backoff > 0 {
+			r.backoff = backoff
+		}
+	}
+}
+
+func New(logger *zap.Logger, options ...Option) *Router {
+	r := &Router{
+		handlers:  make(map[string]Handler),
+		unknown:   IgnoreUnknown,
+		logger:    logger,
+		workers:   defaultWorkers(),
+		queueSize: DefaultQueueSize,
+		backoff:   DefaultRedeliveryBackoff,
+	}
+
+	for _, option := range options {
+		option(r)
+	}
+
+	return r
+}
+
+// Use appends middlewares to the chain, the first one is the outermost
+func (r *Router) Use(middlewares ...Middleware) {
+	r.middlewares = append(r.middlewares, middlewares...)
+}
+
+type envelopeKey struct{}
+
+// Envelope returns the envelope of the event being handled
+func Envelope(ctx context.Context) events.Envelope {
+	envelope, _ := ctx.Value(envelopeKey{}).(events.Envelope)
+	return envelope
+}
+
+// Handle registers the handler of the events of type T. The message is decoded
+// with events.Decode and its envelope is available through Envelope(ctx).
+func Handle[T events.Event](r *Router, handler func(ctx context.Context, event T) error) {
+	var zero T
+
+	r.register(zero.EventType(), func(ctx context.Context, message client.Message) error {
+		envelope, event, err := events.Decode[T](message.Headers, message.Value)
+
+		if err != nil {
+			return err
+		}
+
+		return handler(context.WithValue(ctx, envelopeKey{}, envelope), event)
+	})
+}
+
+// HandleType registers the handler of an event type whose Go type is not known, like the
+// replies a saga waits for. The handler gets the raw message and Envelope(ctx) still
+// returns its envelope.
+func (r *Router) HandleType(eventType string, handler Handler) {
+	r.register(eventType, func(ctx context.Context, message client.Message) error {
+		envelope, err := events.DecodeEnvelope(message.Headers)
+
+		if err != nil {
+			return err
+		}
+
+		return handler(context.WithValue(ctx, envelopeKey{}, envelope), message)
+	})
+}
+
+func (r *Router) register(eventType string, handler Handler) {
+	if _, ok := r.handlers[eventType]; ok {
+		panic(fmt.Sprintf("router: handler already registered for %s", eventType))
+	}
+
+	r.handlers[eventType] = handler
+}
+
+// EventType returns the type of the event carried by a message
+func EventType(message client.Message) string {
+	return message.Headers[events.HeaderType]
+}
+
+// Dispatch runs the middleware chain and the handler of the message event type
+func (r *Router) Dispatch(ctx context.Context, message client.Message) error {
+	handler := r.route
+
+	for i := len(r.middlewares) - 1; i >= 0; i-- {
+		handler = r.middlewares[i](handler)
+	}
+
+	return handler(ctx, message)
+}
+
+func (r *Router) route(ctx context.Context, message client.Message) error {
+	eventType := EventType(message)
+
+	if handler, ok := r.handlers[eventType]; ok {
+		return handler(ctx, message)
+	}
+
+	if r.unknown == RejectUnknown {
+		return fmt.Errorf("%w: %q", ErrUnknownEventType, eventType)
+	}
+
+	r.logger.Warn("Unknown message type", zap.String("type", eventType))
+
+	return nil
+}
+
+// Run reads messages from the API until ctx is done and hands them to the workers.
+// Reading blocks while the queue of the target worker is full, and offsets are
+// committed in order once every earlier message of the partition was handled.
+// Run returns after the messages already queued were handled.
+//
+// A worker handles a failed message again in place, so the later messages of its key
+// wait for it. Once ctx is done a message that still fails is left uncommitted with the
+// later messages of its key, and all of them are redelivered in order after a restart.
+func (r *Router) Run(ctx context.Context, api client.API) {
+	tracker := newOffsetTracker(api, r.logger)
+	queues := make([]chan client.Message, r.workers)
+
+	var wg sync.WaitGroup
+
+	// Messages already handed to a worker are handled and committed even once ctx is
+	// cancelled, so a shutdown drains the queues instead of abandoning them half done.
+	handleCtx := context.WithoutCancel(ctx)
+
+	for i := range queues {
+		queues[i] = make(chan client.Message, r.queueSize)
+		wg.Add(1)
+
+		go func(queue chan client.Message) {
+			defer wg.Done()
+
+			blocked := make(map[string]bool)
+
+			for message := range queue {
+				if blocked[string(message.Key)] {
+					continue
+				}
+
+				if err := r.handle(ctx, handleCtx, message); err != nil {
+					blocked[string(message.Key)] = true
+					continue
+				}
+
+				tracker.complete(handleCtx, message)
+			}
+		}(queues[i])
+	}
+
+	defer func() {
+		for _, queue := range queues {
+			close(queue)
+		}
+
+		wg.Wait()
+	}()
+
+	for {
+		message, err := api.ReadMessage(ctx)
+
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+
+			r.logger.Error("Failed to read message", zap.Error(err))
+			continue
+		}
+
+		tracker.track(message)
+
+		select {
+		case queues[r.shard(message)] <- message:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// handle dispatches a message until it succeeds. It stops retrying once ctx is done
+// and returns the last error.
+func (r *Router) handle(ctx context.Context, handleCtx context.Context, message client.Message) error {
+	for attempt := 1; ; attempt++ {
+		err := r.Dispatch(handleCtx, message)
+
+		if err == nil {
+			return nil
+		}
+
+		r.logger.Error("Failed to handle message, retrying",
+			zap.String("type", EventType(message)),
+			zap.String("key", string(message.Key)),
+			zap.Int("attempt", attempt),
+			zap.Error(err))
+
+		select {
+		case <-time.After(r.backoff):
+		case <-ctx.Done():
+			r.logger.Warn("Leaving message uncommitted until the restart", zap.String("type", EventType(message)), zap.String("key", string(message.Key)))
+			return err
+		}
+	}
+}
+
+// shard returns the worker of a message, every message with the same key goes to the same worker
+func (r *Router) shard(message client.Message) int {
+	hash := fnv.New32a()
+	hash.Write(message.Key)
+
+	return int(hash.Sum32() % uint32(r.workers))
+}
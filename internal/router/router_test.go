@@ -0,0 +1,129 @@
+package router
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"go.uber.org/zap"
+
+	"saga-pattern/internal/client"
+	"saga-pattern/internal/events"
+)
+
+func newMessage(t *testing.T, event events.Event) client.Message {
+	t.Helper()
+
+	headers, value, err := events.Encode(events.New(events.SourceOrders, "order-1", event), event)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return client.Message{Topic: "orders", Key: []byte("order-1"), Value: value, Headers: headers}
+}
+
+func TestRouterDispatch(t *testing.T) {
+	logger, _ := zap.NewDevelopment()
+	ctx := context.Background()
+
+	var received events.OrderCreated
+	var envelope events.Envelope
+
+	r := New(logger)
+
+	Handle(r, func(ctx context.Context, event events.OrderCreated) error {
+		received = event
+		envelope = Envelope(ctx)
+		return nil
+	})
+
+	message := newMessage(t, events.OrderCreated{OrderID: "order-1", ID: 2})
+
+	if err := r.Dispatch(ctx, message); err != nil {
+		t.Fatal(err)
+	}
+
+	if received.OrderID != "order-1" || received.ID != 2 {
+		t.Errorf("expected the decoded event, got %+v", received)
+	}
+
+	if envelope.SagaID != "order-1" || envelope.Type != events.OrderCreatedType {
+		t.Errorf("expected the envelope in the context, got %+v", envelope)
+	}
+
+	unknown := newMessage(t, events.RevertOrder{OrderID: "order-1"})
+
+	if err := r.Dispatch(ctx, unknown); err != nil {
+		t.Errorf("expected unknown events to be ignored, got %v", err)
+	}
+
+	strict := New(logger, WithUnknownPolicy(RejectUnknown))
+
+	if err := strict.Dispatch(ctx, unknown); !errors.Is(err, ErrUnknownEventType) {
+		t.Errorf("expected ErrUnknownEventType, got %v", err)
+	}
+}
+
+func TestRouterHandleType(t *testing.T) {
+	logger, _ := zap.NewDevelopment()
+
+	var received client.Message
+	var envelope events.Envelope
+
+	r := New(logger)
+
+	r.HandleType(events.RevertOrderType, func(ctx context.Context, message client.Message) error {
+		received = message
+		envelope = Envelope(ctx)
+		return nil
+	})
+
+	message := newMessage(t, events.RevertOrder{OrderID: "order-1"})
+
+	if err := r.Dispatch(context.Background(), message); err != nil {
+		t.Fatal(err)
+	}
+
+	if string(received.Value) != string(message.Value) || envelope.Type != events.RevertOrderType {
+		t.Errorf("expected the raw message and its envelope, got %s and %+v", received.Value, envelope)
+	}
+}
+
+func TestRouterMiddlewares(t *testing.T) {
+	logger, _ := zap.NewDevelopment()
+	ctx := context.Background()
+	metrics := NewMetrics()
+
+	var order []string
+
+	trace := func(name string) Middleware {
+		return func(next Handler) Handler {
+			return func(ctx context.Context, message client.Message) error {
+				order = append(order, name)
+				return next(ctx, message)
+			}
+		}
+	}
+
+	r := New(logger)
+	r.Use(trace("outer"), metrics.Middleware(), Recover(logger), trace("inner"))
+
+	Handle(r, func(ctx context.Context, event events.OrderCreated) error {
+		panic("boom")
+	})
+
+	err := r.Dispatch(ctx, newMessage(t, events.OrderCreated{OrderID: "order-1"}))
+
+	if err == nil {
+		t.Fatal("expected the panic to be turned into an error")
+	}
+
+	if len(order) != 2 || order[0] != "outer" || order[1] != "inner" {
+		t.Errorf("expected middlewares to run outer first, got %v", order)
+	}
+
+	if snapshot := metrics.Snapshot(events.OrderCreatedType); snapshot.Failed != 1 || snapshot.Handled != 0 {
+		t.Errorf("expected one failed message, got %+v", snapshot)
+	}
+}
@@ -0,0 +1,265 @@
+// Package saga runs orchestrated sagas. A saga is declared as a Definition, an ordered list
+// of steps, and an Engine runs it: it persists every saga instance with bun, sends the
+// commands of the steps through the outbox and moves the saga on the replies read from
+// client.API. When a step fails, the completed steps are compensated in reverse order.
+package saga
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"saga-pattern/internal/client"
+	"saga-pattern/internal/database/models"
+	"saga-pattern/internal/events"
+)
+
+// ErrUnexpectedReply is returned for a reply that does not answer the current step of the
+// saga, like a late reply to a step that was already handled
+var ErrUnexpectedReply = errors.New("reply does not match the current saga step")
+
+// ErrNotRunning is returned when expiring a saga that is already compensating or over
+var ErrNotRunning = errors.New("saga is not running")
+
+const (
+	DefaultTopic       = "saga"
+	DefaultSagaTimeout = 5 * time.Minute
+	DefaultStepTimeout = 30 * time.Second
+)
+
+// Step is a local transaction of a participant. The engine sends the action command and
+// waits for one of the events completing or failing the step. Once a later step fails,
+// the compensation command is sent and the engine waits for one of the compensated events.
+type Step[E events.Event] struct {
+	Name string
+
+	// Action builds the command of the step from the event that started the saga, nil
+	// when that event already did the step
+	Action func(started E) events.Event
+
+	// Compensation builds the command undoing the step, nil when there is nothing to undo.
+	// reason is the reason of the failure being compensated.
+	Compensation func(started E, reason string) events.Event
+
+	// Types of the replies completing, failing and compensating the step. The reason of a
+	// failure is read from the "reason" field of the reply.
+	CompletedBy   []string
+	FailedBy      []string
+	CompensatedBy []string
+
+	// AbortedBy lists the replies telling the step cannot be compensated anymore. The saga
+	// stops there as failed, undoing the steps before it is left to a manual action.
+	AbortedBy []string
+
+	// Timeout is how long to wait for a reply before sending the command again
+	Timeout time.Duration
+
+	// Retry bounds how many times the action is sent, the step fails once it gets no reply
+	// after Retry.MaxAttempts sends. Compensations are sent until they succeed. It is also
+	// the retry policy of the handlers of the replies.
+	Retry client.RetryPolicy
+}
+
+func (s Step[E]) timeout() time.Duration {
+	if s.Timeout > 0 {
+		return s.Timeout
+	}
+
+	return DefaultStepTimeout
+}
+
+func (s Step[E]) maxAttempts() int {
+	if s.Retry.MaxAttempts > 0 {
+		return s.Retry.MaxAttempts
+	}
+
+	return client.DefaultRetryPolicy.MaxAttempts
+}
+
+// Definition declares a saga started by the events of type E. The event is stored with
+// the saga instance and every command is built from it.
+type Definition[E events.Event] struct {
+	Name  string
+	Steps []Step[E]
+
+	// Topic receives the commands, DefaultTopic when empty
+	Topic string
+
+	// Timeout is the deadline of the whole saga, it is compensated when still running past it
+	Timeout time.Duration
+
+	// CanceledBy lists the events aborting a running saga, like a cancellation requested by
+	// a user. The saga is compensated with the reason of the event, including the current
+	// step since its participant may already have done it.
+	CanceledBy []string
+}
+
+func (d Definition[E]) topic() string {
+	if d.Topic != "" {
+		return d.Topic
+	}
+
+	return DefaultTopic
+}
+
+func (d Definition[E]) timeout() time.Duration {
+	if d.Timeout > 0 {
+		return d.Timeout
+	}
+
+	return DefaultSagaTimeout
+}
+
+// RetryPolicies returns the retry policy of the event starting the saga and of every reply
+func (d Definition[E]) RetryPolicies() client.RetryPolicies {
+	var started E
+
+	policies := client.RetryPolicies{started.EventType(): client.DefaultRetryPolicy}
+
+	for _, cancel := range d.CanceledBy {
+		policies[cancel] = client.DefaultRetryPolicy
+	}
+
+	for _, step := range d.Steps {
+		policy := step.Retry
+
+		if policy.MaxAttempts == 0 {
+			policy = client.DefaultRetryPolicy
+		}
+
+		for _, replies := range [][]string{step.CompletedBy, step.FailedBy, step.CompensatedBy, step.AbortedBy} {
+			for _, reply := range replies {
+				policies[reply] = policy
+			}
+		}
+	}
+
+	return policies
+}
+
+// Replies returns the type of every reply the saga waits for, and of the events canceling it
+func (d Definition[E]) Replies() []string {
+	var replies []string
+
+	seen := make(map[string]bool)
+
+	groups := [][]string{d.CanceledBy}
+
+	for _, step := range d.Steps {
+		groups = append(groups, step.CompletedBy, step.FailedBy, step.CompensatedBy, step.AbortedBy)
+	}
+
+	for _, types := range groups {
+		for _, reply := range types {
+			if !seen[reply] {
+				seen[reply] = true
+				replies = append(replies, reply)
+			}
+		}
+	}
+
+	return replies
+}
+
+// Start sets up a new saga instance and returns the first command to send
+func (d Definition[E]) Start(instance *models.SagaInstance, started E) events.Event {
+	instance.Name = d.Name
+	instance.Status = models.SagaStatusRunning
+	instance.CurrentStep = -1
+	instance.Deadline = time.Now().Add(d.timeout())
+
+	return d.forward(instance, started)
+}
+
+// Handle applies the reply of a participant, or an event canceling the saga, and returns
+// the next command to send, nil once the saga is over
+func (d Definition[E]) Handle(instance *models.SagaInstance, started E, replyType string, reason string) (events.Event, error) {
+	if instance.CurrentStep < 0 || instance.CurrentStep >= len(d.Steps) {
+		return nil, ErrUnexpectedReply
+	}
+
+	step := d.Steps[instance.CurrentStep]
+
+	switch {
+	case instance.Status == models.SagaStatusRunning && slices.Contains(d.CanceledBy, replyType):
+		return d.Expire(instance, started, reason)
+
+	case instance.Status == models.SagaStatusRunning && slices.Contains(step.CompletedBy, replyType):
+		return d.forward(instance, started), nil
+
+	case instance.Status == models.SagaStatusRunning && slices.Contains(step.FailedBy, replyType):
+		instance.Status = models.SagaStatusCompensating
+		instance.FailureReason = reason
+
+		return d.backward(instance, started), nil
+
+	case instance.Status == models.SagaStatusCompensating && slices.Contains(step.CompensatedBy, replyType):
+		return d.backward(instance, started), nil
+
+	case instance.Status == models.SagaStatusCompensating && slices.Contains(step.AbortedBy, replyType):
+		instance.Status = models.SagaStatusFailed
+		instance.FailureReason = fmt.Sprintf("%s, %s cannot be compensated: %s", instance.FailureReason, step.Name, reason)
+
+		return nil, nil
+	}
+
+	return nil, ErrUnexpectedReply
+}
+
+// Expire fails the current step of a running saga that got no reply in time and returns
+// the first compensation to send. The participant may still do the step after the engine
+// gave up on it, so the step itself is compensated as well.
+func (d Definition[E]) Expire(instance *models.SagaInstance, started E, reason string) (events.Event, error) {
+	if instance.Status != models.SagaStatusRunning {
+		return nil, ErrNotRunning
+	}
+
+	instance.Status = models.SagaStatusCompensating
+	instance.FailureReason = reason
+	instance.CurrentStep++
+
+	return d.backward(instance, started), nil
+}
+
+// Command rebuilds the command of the current step, to send it again
+func (d Definition[E]) Command(instance *models.SagaInstance, started E) events.Event {
+	step := d.Steps[instance.CurrentStep]
+
+	if instance.Status == models.SagaStatusCompensating {
+		return step.Compensation(started, instance.FailureReason)
+	}
+
+	return step.Action(started)
+}
+
+// forward moves to the next step with an action. Steps without one were done by the event
+// starting the saga.
+func (d Definition[E]) forward(instance *models.SagaInstance, started E) events.Event {
+	for step := instance.CurrentStep + 1; step < len(d.Steps); step++ {
+		instance.CurrentStep = step
+
+		if action := d.Steps[step].Action; action != nil {
+			return action(started)
+		}
+	}
+
+	instance.Status = models.SagaStatusCompleted
+
+	return nil
+}
+
+// backward moves to the previous step with a compensation
+func (d Definition[E]) backward(instance *models.SagaInstance, started E) events.Event {
+	for step := instance.CurrentStep - 1; step >= 0; step-- {
+		instance.CurrentStep = step
+
+		if compensation := d.Steps[step].Compensation; compensation != nil {
+			return compensation(started, instance.FailureReason)
+		}
+	}
+
+	instance.Status = models.SagaStatusCompensated
+
+	return nil
+}
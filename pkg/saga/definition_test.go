@@ -0,0 +1,179 @@
+package saga
+
+import (
+	"errors"
+	"testing"
+
+	"saga-pattern/internal/database/models"
+	"saga-pattern/internal/events"
+)
+
+// testSaga has a step without compensation between two compensable ones
+var testSaga = Definition[events.OrderCreated]{
+	Name: "test",
+	Steps: []Step[events.OrderCreated]{
+		{
+			Name: "first",
+			Action: func(order events.OrderCreated) events.Event {
+				return events.ReserveInventory{OrderID: order.OrderID}
+			},
+			Compensation: func(order events.OrderCreated, reason string) events.Event {
+				return events.RevertOrder{OrderID: order.OrderID, Reason: reason}
+			},
+			CompletedBy:   []string{"FirstDone"},
+			FailedBy:      []string{"FirstFailed"},
+			CompensatedBy: []string{"FirstUndone"},
+			AbortedBy:     []string{"FirstStuck"},
+		},
+		{
+			Name: "second",
+			Action: func(order events.OrderCreated) events.Event {
+				return events.ReserveInventory{OrderID: order.OrderID}
+			},
+			CompletedBy: []string{"SecondDone"},
+			FailedBy:    []string{"SecondFailed"},
+		},
+		{
+			Name: "third",
+			Action: func(order events.OrderCreated) events.Event {
+				return events.ReserveInventory{OrderID: order.OrderID}
+			},
+			CompletedBy: []string{"ThirdDone"},
+			FailedBy:    []string{"ThirdFailed"},
+		},
+	},
+}
+
+func TestDefinitionCompletes(t *testing.T) {
+	order := events.OrderCreated{OrderID: "order-1"}
+	instance := &models.SagaInstance{}
+
+	if command := testSaga.Start(instance, order); command == nil || instance.CurrentStep != 0 {
+		t.Fatalf("expected the first step to start, got step %d", instance.CurrentStep)
+	}
+
+	for _, reply := range []string{"FirstDone", "SecondDone", "ThirdDone"} {
+		if _, err := testSaga.Handle(instance, order, reply, ""); err != nil {
+			t.Fatalf("%s: %v", reply, err)
+		}
+	}
+
+	if instance.Status != models.SagaStatusCompleted {
+		t.Errorf("expected the saga to be completed, got %s", instance.Status)
+	}
+}
+
+func TestDefinitionCompensatesInReverseOrder(t *testing.T) {
+	order := events.OrderCreated{OrderID: "order-1"}
+	instance := &models.SagaInstance{}
+
+	testSaga.Start(instance, order)
+
+	for _, reply := range []string{"FirstDone", "SecondDone"} {
+		if _, err := testSaga.Handle(instance, order, reply, ""); err != nil {
+			t.Fatalf("%s: %v", reply, err)
+		}
+	}
+
+	// The second step has nothing to undo, so the first one is compensated right away
+	command, err := testSaga.Handle(instance, order, "ThirdFailed", "out of stock")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	revert, ok := command.(events.RevertOrder)
+
+	if !ok || revert.Reason != "out of stock" {
+		t.Fatalf("expected the compensation of the first step, got %#v", command)
+	}
+
+	if instance.Status != models.SagaStatusCompensating || instance.CurrentStep != 0 {
+		t.Fatalf("expected to compensate step 0, got %s at step %d", instance.Status, instance.CurrentStep)
+	}
+
+	if _, err := testSaga.Handle(instance, order, "SecondDone", ""); !errors.Is(err, ErrUnexpectedReply) {
+		t.Errorf("expected a late reply to be unexpected, got %v", err)
+	}
+
+	command, err = testSaga.Handle(instance, order, "FirstUndone", "")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if command != nil || instance.Status != models.SagaStatusCompensated {
+		t.Errorf("expected the saga to be compensated, got %s and %#v", instance.Status, command)
+	}
+}
+
+func TestDefinitionCancelCompensatesTheCurrentStep(t *testing.T) {
+	definition := testSaga
+	definition.CanceledBy = []string{"Canceled"}
+
+	order := events.OrderCreated{OrderID: "order-1"}
+	instance := &models.SagaInstance{}
+
+	definition.Start(instance, order)
+
+	// The first step is in flight, its participant may already have done it
+	command, err := definition.Handle(instance, order, "Canceled", "canceled by the user")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := command.(events.RevertOrder); !ok || instance.CurrentStep != 0 {
+		t.Fatalf("expected the compensation of the first step, got %#v at step %d", command, instance.CurrentStep)
+	}
+
+	if instance.Status != models.SagaStatusCompensating || instance.FailureReason != "canceled by the user" {
+		t.Errorf("expected the saga to compensate the cancellation, got %s with %q", instance.Status, instance.FailureReason)
+	}
+
+	// A saga already compensating is not canceled again
+	if _, err := definition.Handle(instance, order, "Canceled", ""); !errors.Is(err, ErrUnexpectedReply) {
+		t.Errorf("expected a second cancellation to be unexpected, got %v", err)
+	}
+}
+
+func TestDefinitionFailsWhenAStepCannotBeCompensated(t *testing.T) {
+	order := events.OrderCreated{OrderID: "order-1"}
+	instance := &models.SagaInstance{}
+
+	testSaga.Start(instance, order)
+
+	// A step that is not compensating cannot abort
+	if _, err := testSaga.Handle(instance, order, "FirstStuck", ""); !errors.Is(err, ErrUnexpectedReply) {
+		t.Fatalf("expected an abort of a running step to be unexpected, got %v", err)
+	}
+
+	for _, reply := range []string{"FirstDone", "SecondDone"} {
+		if _, err := testSaga.Handle(instance, order, reply, ""); err != nil {
+			t.Fatalf("%s: %v", reply, err)
+		}
+	}
+
+	if _, err := testSaga.Handle(instance, order, "ThirdFailed", "out of stock"); err != nil {
+		t.Fatal(err)
+	}
+
+	command, err := testSaga.Handle(instance, order, "FirstStuck", "already shipped")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if command != nil || instance.Status != models.SagaStatusFailed || instance.CurrentStep != 0 {
+		t.Fatalf("expected the saga to stop as failed at step 0, got %s at step %d and %#v", instance.Status, instance.CurrentStep, command)
+	}
+
+	if instance.FailureReason != "out of stock, first cannot be compensated: already shipped" {
+		t.Errorf("expected both reasons in the failure, got %q", instance.FailureReason)
+	}
+
+	// Nothing moves a failed saga anymore
+	if _, err := testSaga.Handle(instance, order, "FirstUndone", ""); !errors.Is(err, ErrUnexpectedReply) {
+		t.Errorf("expected a reply to a failed saga to be unexpected, got %v", err)
+	}
+}
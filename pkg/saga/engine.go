@@ -0,0 +1,420 @@
+package saga
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/uptrace/bun"
+	"go.uber.org/zap"
+
+	"saga-pattern/internal/client"
+	"saga-pattern/internal/database"
+	"saga-pattern/internal/database/models"
+	"saga-pattern/internal/events"
+	"saga-pattern/internal/router"
+)
+
+// Engine runs the sagas of a Definition. The instance update, the inbox entry of the
+// message being handled and the next command are written in the same transaction.
+type Engine[E events.Event] struct {
+	db         *bun.DB
+	logger     *zap.Logger
+	definition Definition[E]
+}
+
+func NewEngine[E events.Event](logger *zap.Logger, db *bun.DB, definition Definition[E]) *Engine[E] {
+	return &Engine[E]{
+		db:         db,
+		logger:     logger,
+		definition: definition,
+	}
+}
+
+// Name returns the name of the saga definition
+func (e *Engine[E]) Name() string {
+	return e.definition.Name
+}
+
+// Register adds the handlers of the event starting the saga and of its replies to r
+func (e *Engine[E]) Register(r *router.Router) {
+	router.Handle(r, func(ctx context.Context, started E) error {
+		return e.Start(ctx, router.Envelope(ctx), started)
+	})
+
+	for _, reply := range e.definition.Replies() {
+		r.HandleType(reply, func(ctx context.Context, message client.Message) error {
+			return e.Reply(ctx, router.Envelope(ctx), message.Value)
+		})
+	}
+}
+
+// Router returns a router running the saga with the usual middlewares, to read the
+// messages of client.API with Router.Run
+func (e *Engine[E]) Router() *router.Router {
+	r := router.New(e.logger, router.WithUnknownPolicy(router.IgnoreUnknown))
+
+	r.Use(
+		router.Logging(e.logger),
+		router.Retry(client.NewRetrier(e.logger, e.db, e.definition.RetryPolicies())),
+		router.Deduplicate(e.db, e.logger),
+		router.Recover(e.logger),
+	)
+
+	e.Register(r)
+
+	return r
+}
+
+// Run handles the messages read from api until ctx is done
+func (e *Engine[E]) Run(ctx context.Context, api client.API) {
+	e.Router().Run(ctx, api)
+}
+
+// Start creates the saga of envelope and sends the command of its first step
+func (e *Engine[E]) Start(ctx context.Context, envelope events.Envelope, started E) error {
+	err := e.db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		if err := database.MarkProcessed(ctx, tx, database.InboxKey(envelope.SagaID, envelope.Type)); err != nil {
+			return err
+		}
+
+		payload, err := json.Marshal(started)
+
+		if err != nil {
+			return err
+		}
+
+		instance := &models.SagaInstance{SagaID: envelope.SagaID, Payload: payload}
+		command := e.definition.Start(instance, started)
+
+		if _, err := tx.NewInsert().Model(instance).Exec(ctx); err != nil {
+			return err
+		}
+
+		// The steps before the current one were done by the event starting the saga
+		for position := 0; position < instance.CurrentStep; position++ {
+			step := &models.SagaStep{
+				SagaID:       instance.SagaID,
+				Name:         e.definition.Steps[position].Name,
+				Position:     position,
+				Status:       models.SagaStepStatusSucceeded,
+				Reply:        envelope.Type,
+				ReplyPayload: payload,
+				FinishedAt:   time.Now(),
+			}
+
+			if _, err := tx.NewInsert().Model(step).Exec(ctx); err != nil {
+				return err
+			}
+		}
+
+		e.logger.Info("Saga started",
+			zap.String("saga", e.definition.Name),
+			zap.String("sagaID", instance.SagaID),
+			zap.String("step", e.definition.Steps[instance.CurrentStep].Name))
+
+		return e.send(ctx, tx, envelope, instance, command)
+	})
+
+	if errors.Is(err, database.ErrDuplicateMessage) {
+		e.logger.Info("Skipping duplicate saga start", zap.String("sagaID", envelope.SagaID), zap.String("type", envelope.Type))
+		return nil
+	}
+
+	return err
+}
+
+// Reply moves the saga of envelope on the reply of a participant. Replies that do not
+// answer the current step are logged and dropped.
+func (e *Engine[E]) Reply(ctx context.Context, envelope events.Envelope, payload []byte) error {
+	var failure struct {
+		Reason string `json:"reason"`
+	}
+
+	if err := json.Unmarshal(payload, &failure); err != nil {
+		return err
+	}
+
+	err := e.db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		if err := database.MarkProcessed(ctx, tx, database.InboxKey(envelope.SagaID, envelope.Type)); err != nil {
+			return err
+		}
+
+		instance, started, err := e.load(ctx, tx, envelope.SagaID)
+
+		if err != nil {
+			return err
+		}
+
+		position, status := instance.CurrentStep, instance.Status
+
+		command, err := e.definition.Handle(instance, started, envelope.Type, failure.Reason)
+
+		if errors.Is(err, ErrUnexpectedReply) {
+			e.logger.Warn("Dropping reply that does not match the saga state",
+				zap.String("sagaID", envelope.SagaID),
+				zap.String("type", envelope.Type),
+				zap.Stringer("status", instance.Status),
+				zap.Int("step", instance.CurrentStep))
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if err := save(ctx, tx, instance); err != nil {
+			return err
+		}
+
+		// The step failed when the reply started the compensation or stopped it
+		stepStatus := models.SagaStepStatusSucceeded
+
+		if status == models.SagaStatusRunning && instance.Status == models.SagaStatusCompensating || instance.Status == models.SagaStatusFailed {
+			stepStatus = models.SagaStepStatusFailed
+		}
+
+		reply := &models.SagaStep{Status: stepStatus, Reply: envelope.Type, ReplyPayload: payload}
+
+		if err := complete(ctx, tx, instance.SagaID, position, status == models.SagaStatusCompensating, reply); err != nil {
+			return err
+		}
+
+		if instance.Status == models.SagaStatusFailed {
+			e.logger.Error("Saga failed, it needs a manual action",
+				zap.String("sagaID", instance.SagaID),
+				zap.String("reply", envelope.Type),
+				zap.String("reason", instance.FailureReason))
+		}
+
+		e.logger.Info("Saga moved",
+			zap.String("sagaID", instance.SagaID),
+			zap.String("reply", envelope.Type),
+			zap.Stringer("status", instance.Status),
+			zap.String("step", e.definition.Steps[instance.CurrentStep].Name))
+
+		return e.send(ctx, tx, envelope, instance, command)
+	})
+
+	if errors.Is(err, database.ErrDuplicateMessage) {
+		e.logger.Info("Skipping duplicate reply", zap.String("sagaID", envelope.SagaID), zap.String("type", envelope.Type))
+		return nil
+	}
+
+	return err
+}
+
+// Timeout handles a pending step past its deadline. The command is sent again until the
+// step runs out of attempts, the saga is then compensated. Steps that got their reply in
+// the meantime are left alone.
+func (e *Engine[E]) Timeout(ctx context.Context, stepID int64) error {
+	err := e.db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		step := &models.SagaStep{ID: stepID}
+
+		if err := tx.NewSelect().Model(step).WherePK().Scan(ctx); err != nil {
+			return err
+		}
+
+		if step.Status != models.SagaStepStatusPending || step.Deadline.After(time.Now()) {
+			return nil
+		}
+
+		instance, started, err := e.load(ctx, tx, step.SagaID)
+
+		if err != nil {
+			return err
+		}
+
+		definition := e.definition.Steps[step.Position]
+
+		if !step.Compensation && step.Attempts >= definition.maxAttempts() {
+			return e.expire(ctx, tx, instance, started, fmt.Sprintf("%s timed out after %d attempts", step.Name, step.Attempts))
+		}
+
+		command := e.definition.Command(instance, started)
+
+		step.Attempts++
+		step.Deadline = time.Now().Add(definition.timeout())
+
+		res, err := tx.NewUpdate().Model(step).Column("attempts", "deadline").WherePK().
+			Where("status = ?", models.SagaStepStatusPending).
+			Exec(ctx)
+
+		if err != nil {
+			return err
+		}
+
+		if affected, err := res.RowsAffected(); err != nil || affected == 0 {
+			return errors.Join(err, database.ErrVersionMismatch)
+		}
+
+		e.logger.Warn("Saga step timed out, sending its command again",
+			zap.String("sagaID", step.SagaID),
+			zap.String("step", step.Name),
+			zap.Bool("compensation", step.Compensation),
+			zap.Int("attempt", step.Attempts))
+
+		envelope := events.New(events.SourceOrchestrator, instance.SagaID, command)
+
+		return database.EnqueueEvent(ctx, tx, e.definition.topic(), envelope, command)
+	})
+
+	if errors.Is(err, database.ErrVersionMismatch) {
+		e.logger.Info("Saga step got its reply while timing out", zap.Int64("stepID", stepID))
+		return nil
+	}
+
+	return err
+}
+
+// Expire compensates a saga still running past its deadline
+func (e *Engine[E]) Expire(ctx context.Context, sagaID string) error {
+	err := e.db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		instance, started, err := e.load(ctx, tx, sagaID)
+
+		if err != nil {
+			return err
+		}
+
+		if instance.Status != models.SagaStatusRunning || instance.Deadline.After(time.Now()) {
+			return nil
+		}
+
+		return e.expire(ctx, tx, instance, started, "saga timed out")
+	})
+
+	if errors.Is(err, database.ErrVersionMismatch) {
+		e.logger.Info("Saga moved while expiring", zap.String("sagaID", sagaID))
+		return nil
+	}
+
+	return err
+}
+
+// expire fails the current step of the saga and sends the first compensation
+func (e *Engine[E]) expire(ctx context.Context, tx bun.Tx, instance *models.SagaInstance, started E, reason string) error {
+	position := instance.CurrentStep
+
+	command, err := e.definition.Expire(instance, started, reason)
+
+	if err != nil {
+		return err
+	}
+
+	if err := save(ctx, tx, instance); err != nil {
+		return err
+	}
+
+	if err := complete(ctx, tx, instance.SagaID, position, false, &models.SagaStep{Status: models.SagaStepStatusFailed}); err != nil {
+		return err
+	}
+
+	e.logger.Warn("Saga expired, compensating",
+		zap.String("sagaID", instance.SagaID),
+		zap.String("reason", reason),
+		zap.Stringer("status", instance.Status))
+
+	// The watchdog has no message to answer, the commands only carry the saga ID
+	return e.send(ctx, tx, events.Envelope{SagaID: instance.SagaID}, instance, command)
+}
+
+// save writes back a saga loaded by load. It returns database.ErrVersionMismatch when the
+// saga was written in between, the caller then rolls back and decides again on the new state.
+func save(ctx context.Context, db bun.IDB, instance *models.SagaInstance) error {
+	version := instance.Version
+
+	instance.UpdatedAt = time.Now()
+	instance.Version++
+
+	res, err := db.NewUpdate().Model(instance).WherePK().Where("version = ?", version).Exec(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	affected, err := res.RowsAffected()
+
+	if err != nil {
+		return err
+	}
+
+	if affected == 0 {
+		return database.ErrVersionMismatch
+	}
+
+	return nil
+}
+
+// load reads a saga and the event that started it
+func (e *Engine[E]) load(ctx context.Context, db bun.IDB, sagaID string) (*models.SagaInstance, E, error) {
+	instance := &models.SagaInstance{}
+
+	var started E
+
+	if err := db.NewSelect().Model(instance).Where("saga_id = ?", sagaID).Scan(ctx); err != nil {
+		return nil, started, err
+	}
+
+	if err := json.Unmarshal(instance.Payload, &started); err != nil {
+		return nil, started, err
+	}
+
+	return instance, started, nil
+}
+
+// send writes the command answering cause to the outbox and adds it to the history of the
+// saga, nothing is sent once the saga is over
+func (e *Engine[E]) send(ctx context.Context, db bun.IDB, cause events.Envelope, instance *models.SagaInstance, command events.Event) error {
+	if command == nil {
+		return nil
+	}
+
+	payload, err := json.Marshal(command)
+
+	if err != nil {
+		return err
+	}
+
+	step := &models.SagaStep{
+		SagaID:       instance.SagaID,
+		Name:         e.definition.Steps[instance.CurrentStep].Name,
+		Position:     instance.CurrentStep,
+		Compensation: instance.Status == models.SagaStatusCompensating,
+		Status:       models.SagaStepStatusPending,
+		Command:      command.EventType(),
+		Payload:      payload,
+		Attempts:     1,
+		Deadline:     time.Now().Add(e.definition.Steps[instance.CurrentStep].timeout()),
+	}
+
+	if _, err := db.NewInsert().Model(step).Exec(ctx); err != nil {
+		return err
+	}
+
+	return database.EnqueueEvent(ctx, db, e.definition.topic(), events.NewFrom(cause, events.SourceOrchestrator, command), command)
+}
+
+// complete stores the outcome of the pending command of a step: its status and, unless
+// the step expired without one, the reply
+func complete(ctx context.Context, db bun.IDB, sagaID string, position int, compensation bool, outcome *models.SagaStep) error {
+	query := db.NewUpdate().Model((*models.SagaStep)(nil)).
+		Set("status = ?", outcome.Status).
+		Set("finished_at = ?", time.Now())
+
+	if outcome.Reply != "" {
+		query = query.
+			Set("reply = ?", outcome.Reply).
+			Set("reply_payload = ?", outcome.ReplyPayload)
+	}
+
+	_, err := query.
+		Where("saga_id = ?", sagaID).
+		Where("position = ?", position).
+		Where("compensation = ?", compensation).
+		Where("status = ?", models.SagaStepStatusPending).
+		Exec(ctx)
+
+	return err
+}
@@ -0,0 +1,186 @@
+package saga
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/uptrace/bun"
+	"go.uber.org/zap"
+
+	"saga-pattern/internal/client"
+	"saga-pattern/internal/database"
+	"saga-pattern/internal/database/models"
+	"saga-pattern/internal/events"
+)
+
+const testAttempts = 3
+
+// orderSaga creates an order and reserves its stock
+var orderSaga = Definition[events.OrderCreated]{
+	Name: "order",
+	Steps: []Step[events.OrderCreated]{
+		{
+			Name: "create_order",
+			Compensation: func(order events.OrderCreated, reason string) events.Event {
+				return events.RevertOrder{OrderID: order.OrderID, Reason: reason}
+			},
+			CompensatedBy: []string{events.OrderRevertedType},
+		},
+		{
+			Name: "reserve_inventory",
+			Action: func(order events.OrderCreated) events.Event {
+				return events.ReserveInventory{OrderID: order.OrderID, Lines: order.Lines}
+			},
+			CompletedBy: []string{events.InventoryReservedType},
+			FailedBy:    []string{events.InventoryReservationFailedType},
+			Retry:       client.RetryPolicy{MaxAttempts: testAttempts},
+		},
+	},
+}
+
+func setupEngine(t *testing.T) (*bun.DB, *Engine[events.OrderCreated]) {
+	db := database.NewMockDatabase(t,
+		&models.SagaInstance{}, &models.SagaStep{}, &models.Lease{}, &models.OutboxMessage{}, &models.InboxMessage{})
+
+	return db, NewEngine(zap.NewNop(), db, orderSaga)
+}
+
+func startSaga(t *testing.T, engine *Engine[events.OrderCreated], orderID string) {
+	order := events.OrderCreated{OrderID: orderID, Lines: []events.OrderLine{{Product: "1", Quantity: 3}}}
+
+	if err := engine.Start(context.Background(), events.New(events.SourceOrders, orderID, order), order); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func dispatch(t *testing.T, engine *Engine[events.OrderCreated], envelope events.Envelope, event events.Event) {
+	t.Helper()
+
+	headers, value, err := events.Encode(envelope, event)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	message := client.Message{Key: []byte(envelope.SagaID), Value: value, Headers: headers}
+
+	if err := engine.Router().Dispatch(context.Background(), message); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDefinitionReplies(t *testing.T) {
+	expected := []string{events.OrderRevertedType, events.InventoryReservedType, events.InventoryReservationFailedType}
+
+	replies := orderSaga.Replies()
+
+	if len(replies) != len(expected) {
+		t.Fatalf("expected replies %v, got %v", expected, replies)
+	}
+
+	for i := range expected {
+		if replies[i] != expected[i] {
+			t.Errorf("expected replies %v, got %v", expected, replies)
+			break
+		}
+	}
+
+	policies := orderSaga.RetryPolicies()
+
+	if _, ok := policies[events.OrderCreatedType]; !ok {
+		t.Errorf("expected a retry policy for the event starting the saga")
+	}
+
+	if policy := policies[events.InventoryReservedType]; policy.MaxAttempts != testAttempts {
+		t.Errorf("expected the replies to use the retry policy of their step, got %+v", policy)
+	}
+}
+
+func TestEngineCompensatesWithTheReasonOfTheReply(t *testing.T) {
+	db, engine := setupEngine(t)
+	ctx := context.Background()
+
+	order := events.OrderCreated{OrderID: "order-1", Lines: []events.OrderLine{{Product: "1", Quantity: 3}}}
+	created := events.New(events.SourceOrders, order.OrderID, order)
+
+	dispatch(t, engine, created, order)
+
+	failed := events.InventoryReservationFailed{OrderID: order.OrderID, Reason: "insufficient inventory"}
+	dispatch(t, engine, events.NewFrom(created, events.SourceInventory, failed), failed)
+
+	var messages []models.OutboxMessage
+
+	if err := db.NewSelect().Model(&messages).Order("id").Scan(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(messages) != 2 || messages[1].Headers[events.HeaderType] != events.RevertOrderType {
+		t.Fatalf("expected ReserveInventory then RevertOrder, got %d messages", len(messages))
+	}
+
+	instance := &models.SagaInstance{}
+
+	if err := db.NewSelect().Model(instance).Where("saga_id = ?", order.OrderID).Scan(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if instance.Status != models.SagaStatusCompensating || instance.FailureReason != failed.Reason {
+		t.Errorf("expected the saga to compensate %q, got %s with %q", failed.Reason, instance.Status, instance.FailureReason)
+	}
+
+	// The reply of the failed step is kept in the history
+	step := &models.SagaStep{}
+
+	if err := db.NewSelect().Model(step).Where("name = ?", "reserve_inventory").Scan(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if step.Status != models.SagaStepStatusFailed || len(step.ReplyPayload) == 0 {
+		t.Errorf("expected the failed step to store its reply, got %s with %q", step.Status, step.ReplyPayload)
+	}
+}
+
+func TestTimeoutDoesNotOverwriteAReplyInBetween(t *testing.T) {
+	db, engine := setupEngine(t)
+	ctx := context.Background()
+
+	order := events.OrderCreated{OrderID: "order-1", Lines: []events.OrderLine{{Product: "1", Quantity: 3}}}
+	created := events.New(events.SourceOrders, order.OrderID, order)
+
+	dispatch(t, engine, created, order)
+
+	// The watchdog loads the saga waiting for its reservation...
+	stale, started, err := engine.load(ctx, db, order.OrderID)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// ...the reply completes it...
+	reserved := events.InventoryReserved{OrderID: order.OrderID, Lines: order.Lines}
+	dispatch(t, engine, events.NewFrom(created, events.SourceInventory, reserved), reserved)
+
+	// ...and the watchdog expires the saga it loaded
+	err = db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		return engine.expire(ctx, tx, stale, started, "reserve_inventory timed out")
+	})
+
+	if !errors.Is(err, database.ErrVersionMismatch) {
+		t.Fatalf("expected the stale write to be refused, got %v", err)
+	}
+
+	instance := &models.SagaInstance{}
+
+	if err := db.NewSelect().Model(instance).Where("saga_id = ?", order.OrderID).Scan(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if instance.Status != models.SagaStatusCompleted || instance.Version != 2 {
+		t.Errorf("expected the reply to complete the saga at version 2, got %s at version %d", instance.Status, instance.Version)
+	}
+
+	if sent := sentCommands(t, db); len(sent) != 1 {
+		t.Errorf("expected no compensation to be sent, got %v", sent)
+	}
+}
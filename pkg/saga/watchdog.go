@@ -0,0 +1,186 @@
+package saga
+
+import (
+	"context"
+	"os"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/uptrace/bun"
+	"go.uber.org/fx"
+	"go.uber.org/zap"
+
+	"saga-pattern/internal/database"
+	"saga-pattern/internal/database/models"
+)
+
+const (
+	// WatchdogLease prefixes the name of the lease electing the replica running the
+	// watchdog of a saga, the lease of a saga is WatchdogLease:<name>
+	WatchdogLease = "saga-watchdog"
+
+	watchdogInterval  = 5 * time.Second
+	watchdogBatchSize = 50
+)
+
+// Expirer handles the sagas and steps of a definition that are past their deadline, it is
+// implemented by Engine
+type Expirer interface {
+	Name() string
+	Expire(ctx context.Context, sagaID string) error
+	Timeout(ctx context.Context, stepID int64) error
+}
+
+// Watchdog finds the sagas and steps past their deadline. Every replica runs one, the
+// replica holding the lease does the work and the others stand by.
+type Watchdog struct {
+	engine    Expirer
+	db        *bun.DB
+	logger    *zap.Logger
+	holder    string
+	interval  time.Duration
+	batchSize int
+}
+
+func NewWatchdog(logger *zap.Logger, db *bun.DB, engine Expirer) *Watchdog {
+	hostname, _ := os.Hostname()
+
+	return &Watchdog{
+		engine:    engine,
+		db:        db,
+		logger:    logger,
+		holder:    hostname + "-" + uuid.New().String(),
+		interval:  watchdogInterval,
+		batchSize: watchdogBatchSize,
+	}
+}
+
+// Lease returns the name of the lease of the watchdog
+func (w *Watchdog) Lease() string {
+	return WatchdogLease + ":" + w.engine.Name()
+}
+
+// Check handles the expired sagas and steps when the replica holds the lease. It returns
+// how many were handled.
+func (w *Watchdog) Check(ctx context.Context) (int, error) {
+	// The lease outlives a few intervals so a slow check does not hand it over
+	leader, err := database.AcquireLease(ctx, w.db, w.Lease(), w.holder, 3*w.interval)
+
+	if err != nil || !leader {
+		return 0, err
+	}
+
+	now := time.Now()
+	handled := 0
+
+	var sagas []string
+
+	err = w.db.NewSelect().Model((*models.SagaInstance)(nil)).
+		Column("saga_id").
+		Where("name = ?", w.engine.Name()).
+		Where("status = ?", models.SagaStatusRunning).
+		Where("deadline < ?", now).
+		Order("deadline").
+		Limit(w.batchSize).
+		Scan(ctx, &sagas)
+
+	if err != nil {
+		return handled, err
+	}
+
+	for _, sagaID := range sagas {
+		if err := w.engine.Expire(ctx, sagaID); err != nil {
+			if ctx.Err() != nil {
+				return handled, ctx.Err()
+			}
+
+			w.logger.Error("Failed to expire saga", zap.String("sagaID", sagaID), zap.Error(err))
+			continue
+		}
+
+		handled++
+	}
+
+	var steps []int64
+
+	instances := w.db.NewSelect().Model((*models.SagaInstance)(nil)).
+		Column("saga_id").
+		Where("name = ?", w.engine.Name())
+
+	err = w.db.NewSelect().Model((*models.SagaStep)(nil)).
+		Column("id").
+		Where("saga_id IN (?)", instances).
+		Where("status = ?", models.SagaStepStatusPending).
+		Where("deadline < ?", now).
+		Order("deadline").
+		Limit(w.batchSize).
+		Scan(ctx, &steps)
+
+	if err != nil {
+		return handled, err
+	}
+
+	for _, stepID := range steps {
+		if err := w.engine.Timeout(ctx, stepID); err != nil {
+			if ctx.Err() != nil {
+				return handled, ctx.Err()
+			}
+
+			w.logger.Error("Failed to handle saga step timeout", zap.Int64("stepID", stepID), zap.Error(err))
+			continue
+		}
+
+		handled++
+	}
+
+	return handled, nil
+}
+
+// StartWatchdog runs the watchdog every interval until the application stops
+func StartWatchdog(lc fx.Lifecycle, watchdog *Watchdog) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+
+	lc.Append(fx.Hook{
+		OnStart: func(context.Context) error {
+			go func() {
+				defer close(done)
+
+				ticker := time.NewTicker(watchdog.interval)
+				defer ticker.Stop()
+
+				watchdog.logger.Info("Starting saga watchdog",
+					zap.String("saga", watchdog.engine.Name()),
+					zap.String("holder", watchdog.holder))
+
+				for {
+					select {
+					case <-ctx.Done():
+						return
+					case <-ticker.C:
+						handled, err := watchdog.Check(ctx)
+
+						if err != nil && ctx.Err() == nil {
+							watchdog.logger.Error("Failed to check saga deadlines", zap.Error(err))
+						}
+
+						if handled > 0 {
+							watchdog.logger.Info("Handled expired sagas", zap.Int("count", handled))
+						}
+					}
+				}
+			}()
+			return nil
+		},
+		OnStop: func(stopCtx context.Context) error {
+			cancel()
+
+			select {
+			case <-done:
+				return nil
+			case <-stopCtx.Done():
+				return stopCtx.Err()
+			}
+		},
+	})
+}
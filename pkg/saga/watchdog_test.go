@@ -0,0 +1,176 @@
+package saga
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/uptrace/bun"
+	"go.uber.org/zap"
+
+	"saga-pattern/internal/database/models"
+	"saga-pattern/internal/events"
+)
+
+func setupWatchdog(t *testing.T) (*bun.DB, *Engine[events.OrderCreated], *Watchdog) {
+	db, engine := setupEngine(t)
+
+	return db, engine, NewWatchdog(zap.NewNop(), db, engine)
+}
+
+// expireSteps moves the deadline of the pending steps to the past
+func expireSteps(t *testing.T, db *bun.DB) {
+	_, err := db.NewUpdate().Model((*models.SagaStep)(nil)).
+		Set("deadline = ?", time.Now().Add(-time.Second)).
+		Where("status = ?", models.SagaStepStatusPending).
+		Exec(context.Background())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func sentCommands(t *testing.T, db *bun.DB) []string {
+	var messages []models.OutboxMessage
+
+	if err := db.NewSelect().Model(&messages).Order("id").Scan(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	types := make([]string, len(messages))
+
+	for i, message := range messages {
+		types[i] = message.Headers[events.HeaderType]
+	}
+
+	return types
+}
+
+func TestWatchdogResendsThenCompensates(t *testing.T) {
+	db, engine, watchdog := setupWatchdog(t)
+	ctx := context.Background()
+
+	startSaga(t, engine, "order-1")
+
+	for attempt := 2; attempt <= testAttempts; attempt++ {
+		expireSteps(t, db)
+
+		if _, err := watchdog.Check(ctx); err != nil {
+			t.Fatal(err)
+		}
+
+		step := &models.SagaStep{}
+
+		if err := db.NewSelect().Model(step).Where("name = ?", "reserve_inventory").Scan(ctx); err != nil {
+			t.Fatal(err)
+		}
+
+		if step.Attempts != attempt || step.Status != models.SagaStepStatusPending {
+			t.Fatalf("expected attempt %d to be pending, got attempt %d %s", attempt, step.Attempts, step.Status)
+		}
+	}
+
+	expireSteps(t, db)
+
+	if _, err := watchdog.Check(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	instance := &models.SagaInstance{}
+
+	if err := db.NewSelect().Model(instance).Where("saga_id = ?", "order-1").Scan(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if instance.Status != models.SagaStatusCompensating {
+		t.Errorf("expected the saga to compensate once the step ran out of attempts, got %s", instance.Status)
+	}
+
+	expected := []string{
+		events.ReserveInventoryType,
+		events.ReserveInventoryType,
+		events.ReserveInventoryType,
+		events.RevertOrderType,
+	}
+
+	sent := sentCommands(t, db)
+
+	if len(sent) != len(expected) {
+		t.Fatalf("expected commands %v, got %v", expected, sent)
+	}
+
+	for i := range expected {
+		if sent[i] != expected[i] {
+			t.Errorf("expected commands %v, got %v", expected, sent)
+			break
+		}
+	}
+}
+
+func TestWatchdogCompensatesExpiredSaga(t *testing.T) {
+	db, engine, watchdog := setupWatchdog(t)
+	ctx := context.Background()
+
+	startSaga(t, engine, "order-1")
+
+	_, err := db.NewUpdate().Model((*models.SagaInstance)(nil)).
+		Set("deadline = ?", time.Now().Add(-time.Second)).
+		Where("saga_id = ?", "order-1").
+		Exec(ctx)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if handled, err := watchdog.Check(ctx); err != nil || handled != 1 {
+		t.Fatalf("expected a single expired saga, got %d: %v", handled, err)
+	}
+
+	instance := &models.SagaInstance{}
+
+	if err := db.NewSelect().Model(instance).Where("saga_id = ?", "order-1").Scan(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if instance.Status != models.SagaStatusCompensating || instance.FailureReason != "saga timed out" {
+		t.Errorf("expected the saga to compensate after timing out, got %s: %q", instance.Status, instance.FailureReason)
+	}
+}
+
+func TestWatchdogRunsOnTheLeaseHolderOnly(t *testing.T) {
+	db, engine, leader := setupWatchdog(t)
+	ctx := context.Background()
+
+	standby := NewWatchdog(zap.NewNop(), db, engine)
+
+	if _, err := leader.Check(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	startSaga(t, engine, "order-1")
+	expireSteps(t, db)
+
+	if handled, err := standby.Check(ctx); err != nil || handled != 0 {
+		t.Fatalf("expected the standby replica to do nothing, got %d: %v", handled, err)
+	}
+
+	if handled, err := leader.Check(ctx); err != nil || handled != 1 {
+		t.Fatalf("expected the leader to handle the expired step, got %d: %v", handled, err)
+	}
+
+	// The standby takes over once the lease of the leader expires
+	_, err := db.NewUpdate().Model((*models.Lease)(nil)).
+		Set("expires_at = ?", time.Now().Add(-time.Second)).
+		Where("name = ?", leader.Lease()).
+		Exec(ctx)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expireSteps(t, db)
+
+	if handled, err := standby.Check(ctx); err != nil || handled != 1 {
+		t.Fatalf("expected the standby to take over, got %d: %v", handled, err)
+	}
+}